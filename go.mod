@@ -17,6 +17,7 @@ require (
 	golang.org/x/crypto v0.0.0-20190605123033-f99c8df09eb5
 	gomodules.xyz/cert v1.0.0
 	gomodules.xyz/stow v0.2.0
+	gomodules.xyz/version v0.0.0-20190507203204-7cec7ee542d3
 	gopkg.in/olivere/elastic.v5 v5.0.61
 	gopkg.in/olivere/elastic.v6 v6.2.17
 	k8s.io/api v0.0.0-20190503110853-61630f889b3c
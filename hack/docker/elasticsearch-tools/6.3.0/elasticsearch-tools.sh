@@ -100,7 +100,7 @@ case "$AUTH_PLUGIN" in
   "$ES_AUTH_NONE")
     ES_URL=$(echo "${DB_SCHEME}://${DB_HOST}:${DB_PORT}")
     ;;
-  "$ES_AUTH_SEARCHGUARD")
+  "$ES_AUTH_SEARCHGUARD" | "$ES_AUTH_XPACK")
     ES_URL=$(echo "${DB_SCHEME}://${DB_USER}:${DB_PASSWORD}@${DB_HOST}:${DB_PORT}")
     ;;
 esac
@@ -6,6 +6,86 @@ set -o pipefail
 
 sync
 
+# configure Search Guard for the format of the keystores the operator mounted into certs directory.
+# custom config files are merged afterwards, so they can still override these settings.
+CONFIG_FILE="/elasticsearch/config/elasticsearch.yml"
+
+case "$KEYSTORE_FORMAT" in
+pkcs12)
+	sed -i -r '/^searchguard\.ssl\.(transport|http)\.(keystore|truststore|pem)/d' $CONFIG_FILE
+	cat >>$CONFIG_FILE <<EOF
+searchguard.ssl.transport.keystore_type: PKCS12
+searchguard.ssl.transport.keystore_filepath: certs/node.p12
+searchguard.ssl.transport.keystore_password: \${KEY_PASS}
+searchguard.ssl.transport.truststore_filepath: certs/root.jks
+searchguard.ssl.transport.truststore_password: \${KEY_PASS}
+searchguard.ssl.http.keystore_type: PKCS12
+searchguard.ssl.http.keystore_filepath: certs/client.p12
+searchguard.ssl.http.keystore_password: \${KEY_PASS}
+searchguard.ssl.http.truststore_filepath: certs/root.jks
+searchguard.ssl.http.truststore_password: \${KEY_PASS}
+EOF
+	;;
+pem)
+	sed -i -r '/^searchguard\.ssl\.(transport|http)\.(keystore|truststore|pem)/d' $CONFIG_FILE
+	cat >>$CONFIG_FILE <<EOF
+searchguard.ssl.transport.pemcert_filepath: certs/node.pem
+searchguard.ssl.transport.pemkey_filepath: certs/node-key.pem
+searchguard.ssl.transport.pemtrustedcas_filepath: certs/root.pem
+searchguard.ssl.http.pemcert_filepath: certs/client.pem
+searchguard.ssl.http.pemkey_filepath: certs/client-key.pem
+searchguard.ssl.http.pemtrustedcas_filepath: certs/root.pem
+EOF
+	;;
+esac
+
+# hot and warm data nodes are told apart by the box_type attribute, which the operator
+# requires on indices through index.routing.allocation.require.box_type
+if [ -n "$NODE_TAG" ]; then
+  sed -i -r '/^node\.attr\.box_type:/d' $CONFIG_FILE
+  echo "node.attr.box_type: ${NODE_TAG}" >>$CONFIG_FILE
+fi
+
+# add the custom node attributes of the node pool, eg, NODE_ATTRIBUTES="rack=r1,zone=a"
+if [ -n "$NODE_ATTRIBUTES" ]; then
+  IFS=',' read -ra attributes <<<"$NODE_ATTRIBUTES"
+  for attribute in "${attributes[@]}"; do
+    sed -i "/^node\.attr\.${attribute%%=*}:/d" $CONFIG_FILE
+    echo "node.attr.${attribute%%=*}: ${attribute#*=}" >>$CONFIG_FILE
+  done
+fi
+
+# fs repositories of native snapshots are mounted by the operator and listed in REPO_LOCATIONS
+if [ -n "$REPO_LOCATIONS" ]; then
+  sed -i -r '/^path\.repo:/d' $CONFIG_FILE
+  echo "path.repo: [${REPO_LOCATIONS}]" >>$CONFIG_FILE
+fi
+
+# credentials of s3, gcs and azure repositories are mounted as one file per client setting,
+# eg, s3.client.<name>.access_key. The endpoint is not a secure setting, so it goes into the config.
+REPOSITORY_CREDENTIALS_DIR="/elasticsearch/config/repository-credentials"
+if [ -d "$REPOSITORY_CREDENTIALS_DIR" ]; then
+  keystore="/elasticsearch/config/elasticsearch.keystore"
+  [ -f "$keystore" ] || /elasticsearch/bin/elasticsearch-keystore create
+  sed -i -r '/^s3\.client\.[^.]+\.endpoint:/d' $CONFIG_FILE
+  for file in "$REPOSITORY_CREDENTIALS_DIR"/*; do
+    [ -f "$file" ] || continue
+    setting="$(basename "$file")"
+    case "$setting" in
+    *.endpoint)
+      echo "$setting: $(cat "$file")" >>$CONFIG_FILE
+      ;;
+    *.credentials_file)
+      /elasticsearch/bin/elasticsearch-keystore add-file "$setting" "$file"
+      ;;
+    *)
+      /elasticsearch/bin/elasticsearch-keystore add -x -f "$setting" <"$file"
+      ;;
+    esac
+  done
+  chown elasticsearch:elasticsearch "$keystore"
+fi
+
 # if custom config file exist then process them
 CUSTOM_CONFIG_DIR="/elasticsearch/custom-config"
 
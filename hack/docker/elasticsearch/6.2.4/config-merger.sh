@@ -26,7 +26,7 @@ if [[ "$NODE_DATA" == true ]]; then
 fi
 
 # if it is client node and client-config file exist then apply it
-if [[ "$NODE_INGEST" == true ]]; then
+if [[ "$MODE" == client ]]; then
   if [ -f $CUSTOM_CONFIG_DIR/client-config.yml ]; then
     yq merge -i --overwrite $CONFIG_FILE $CUSTOM_CONFIG_DIR/client-config.yml
   elif [ -f $CUSTOM_CONFIG_DIR/client-config.yaml ]; then
@@ -34,6 +34,15 @@ if [[ "$NODE_INGEST" == true ]]; then
   fi
 fi
 
+# if it is dedicated ingest node and ingest-config file exist then apply it
+if [[ "$MODE" == ingest ]]; then
+  if [ -f $CUSTOM_CONFIG_DIR/ingest-config.yml ]; then
+    yq merge -i --overwrite $CONFIG_FILE $CUSTOM_CONFIG_DIR/ingest-config.yml
+  elif [ -f $CUSTOM_CONFIG_DIR/ingest-config.yaml ]; then
+    yq merge -i --overwrite $CONFIG_FILE $CUSTOM_CONFIG_DIR/ingest-config.yaml
+  fi
+fi
+
 # if it is master node and mater-config file exist then apply it
 if [[ "$NODE_MASTER" == true ]]; then
   if [ -f $CUSTOM_CONFIG_DIR/master-config.yml ]; then
@@ -15,9 +15,18 @@ until curl -s "$SERVER" --insecure; do
   sleep 0.1
 done
 
+case "$KEYSTORE_FORMAT" in
+pkcs12)
+  tls=(-ks "$certs"/sgadmin.p12 -kst PKCS12 -kspass "$KEY_PASS" -ts "$certs"/root.jks -tspass "$KEY_PASS")
+  ;;
+pem)
+  tls=(-cert "$certs"/sgadmin.pem -key "$certs"/sgadmin-key.pem -cacert "$certs"/root.pem)
+  ;;
+*)
+  tls=(-ks "$certs"/sgadmin.jks -kspass "$KEY_PASS" -ts "$certs"/root.jks -tspass "$KEY_PASS")
+  ;;
+esac
+
 "$searchguard"/tools/sgadmin.sh \
-  -ks "$certs"/sgadmin.jks \
-  -kspass "$KEY_PASS" \
-  -ts "$certs"/root.jks \
-  -tspass "$KEY_PASS" \
+  "${tls[@]}" \
   -cd "$searchguard"/sgconfig -icl -nhnv
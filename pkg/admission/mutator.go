@@ -102,7 +102,13 @@ func setDefaultValues(client kubernetes.Interface, extClient cs.Interface, elast
 	}
 
 	topology := elasticsearch.Spec.Topology
-	if topology != nil {
+	if topology != nil && len(topology.NodePools) > 0 {
+		for i := range topology.NodePools {
+			if topology.NodePools[i].Replicas == nil {
+				topology.NodePools[i].Replicas = types.Int32P(1)
+			}
+		}
+	} else if topology != nil {
 		if topology.Client.Replicas == nil {
 			topology.Client.Replicas = types.Int32P(1)
 		}
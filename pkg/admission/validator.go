@@ -2,18 +2,26 @@ package admission
 
 import (
 	"fmt"
+	"reflect"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/appscode/go/arrays"
 	"github.com/appscode/go/log"
 	"github.com/pkg/errors"
+	"gomodules.xyz/version"
 	admission "k8s.io/api/admission/v1beta1"
+	core "k8s.io/api/core/v1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/mergepatch"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	meta_util "kmodules.xyz/client-go/meta"
@@ -38,11 +46,30 @@ var forbiddenEnvVars = []string{
 	"NODE_DATA",
 }
 
+var supportedNodeRoles = []api.ElasticsearchNodeRole{
+	api.ElasticsearchNodeRoleMaster,
+	api.ElasticsearchNodeRoleData,
+	api.ElasticsearchNodeRoleIngest,
+	api.ElasticsearchNodeRoleCoordinating,
+}
+
 var supportedAuthPlugin = []api.ElasticsearchAuthPlugin{
 	api.ElasticsearchAuthPluginNone,
 	api.ElasticsearchAuthPluginSearchGuard,
+	api.ElasticsearchAuthPluginXpack,
 }
 
+// sgAdminCommonName is the common name of the sgadmin certificate issued by the operator.
+const sgAdminCommonName = "sgadmin"
+
+// DefaultCertificateRenewBefore is how long before their expiry certificates are renewed,
+// if spec.tls.renewBefore is not set.
+const DefaultCertificateRenewBefore = 30 * 24 * time.Hour
+
+// xpackMinVersion is the first version, whose basic license includes X-Pack security. Before,
+// security needs a paid license, and a trial license stops the cluster after 30 days.
+var xpackMinVersion = version.Must(version.NewVersion("6.8.0"))
+
 func (a *ElasticsearchValidator) Resource() (plural schema.GroupVersionResource, singular string) {
 	return schema.GroupVersionResource{
 			Group:    "validators.kubedb.com",
@@ -127,6 +154,14 @@ func (a *ElasticsearchValidator) Admit(req *admission.AdmissionRequest) *admissi
 			if err := validateUpdate(elasticsearch, oldElasticsearch, req.Kind.Kind); err != nil {
 				return hookapi.StatusBadRequest(fmt.Errorf("%v", err))
 			}
+
+			if err := validateVersionUpgrade(a.extClient, oldElasticsearch, elasticsearch); err != nil {
+				return hookapi.StatusForbidden(err)
+			}
+
+			if err := validateNodePoolUpdate(oldElasticsearch, elasticsearch); err != nil {
+				return hookapi.StatusForbidden(err)
+			}
 		}
 		// validate database specs
 		if err = ValidateElasticsearch(a.client, a.extClient, obj.(*api.Elasticsearch), false); err != nil {
@@ -172,43 +207,15 @@ func ValidateElasticsearch(client kubernetes.Interface, extClient cs.Interface,
 		if elasticsearch.Spec.PodTemplate.Spec.Resources.Size() != 0 {
 			return errors.New("doesn't support spec.resources when spec.topology is set")
 		}
-
-		if topology.Client.Prefix == topology.Master.Prefix {
-			return errors.New("client & master node should not have same prefix")
-		}
-		if topology.Client.Prefix == topology.Data.Prefix {
-			return errors.New("client & hot node should not have same prefix")
-		}
-		if topology.Client.Prefix == topology.Warm.Prefix {
-			return errors.New("client & warm node should not have same prefix")
-		}
-		if topology.Master.Prefix == topology.Data.Prefix {
-			return errors.New("master & hot node should not have same prefix")
-		}
-		if topology.Master.Prefix == topology.Warm.Prefix {
-			return errors.New("master & warm node should not have same prefix")
-		}
-		if topology.Warm.Prefix == topology.Data.Prefix {
-			return errors.New("warm & hot node should not have same prefix")
-		}
-		if topology.Client.Replicas == nil || *topology.Client.Replicas < 1 {
-			return fmt.Errorf(`topology.client.replicas "%v" invalid. Must be greater than zero`, topology.Client.Replicas)
-		}
-		if err := amv.ValidateStorage(client, topology.Client.StorageType, topology.Client.Storage); err != nil {
-			return err
-		}
-
-		if topology.Master.Replicas == nil || *topology.Master.Replicas < 1 {
-			return fmt.Errorf(`topology.master.replicas "%v" invalid. Must be greater than zero`, topology.Master.Replicas)
-		}
-		if err := amv.ValidateStorage(client, topology.Master.StorageType, topology.Master.Storage); err != nil {
-			return err
+		if elasticsearch.Spec.HeapSize != nil {
+			return errors.New("doesn't support spec.heapSize when spec.topology is set")
 		}
 
-		if topology.Data.Replicas == nil || *topology.Data.Replicas < 1 {
-			return fmt.Errorf(`topology.data.replicas "%v" invalid. Must be greater than zero`, topology.Data.Replicas)
-		}
-		if err := amv.ValidateStorage(client, topology.Data.StorageType, topology.Data.Storage); err != nil {
+		if len(topology.NodePools) > 0 {
+			if err := validateNodePools(client, topology); err != nil {
+				return err
+			}
+		} else if err := validateTopologyNodes(client, topology); err != nil {
 			return err
 		}
 	} else {
@@ -219,6 +226,10 @@ func ValidateElasticsearch(client kubernetes.Interface, extClient cs.Interface,
 		if err := amv.ValidateStorage(client, elasticsearch.Spec.StorageType, elasticsearch.Spec.Storage); err != nil {
 			return err
 		}
+
+		if err := validateHeapSize("spec.heapSize", elasticsearch.Spec.HeapSize, elasticsearch.Spec.PodTemplate.Spec.Resources); err != nil {
+			return err
+		}
 	}
 
 	if err := amv.ValidateEnvVar(elasticsearch.Spec.PodTemplate.Spec.Env, forbiddenEnvVars, api.ResourceKindElasticsearch); err != nil {
@@ -240,6 +251,40 @@ func ValidateElasticsearch(client kubernetes.Interface, extClient cs.Interface,
 			}
 		}
 
+		if security := elasticsearch.Spec.Security; security != nil {
+			for _, user := range security.Users {
+				secret, err := client.CoreV1().Secrets(elasticsearch.Namespace).Get(user.PasswordSecret.Name, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				if len(secret.Data[user.PasswordSecret.Key]) == 0 {
+					return fmt.Errorf(`secret "%v" of spec.security.users "%v" has no password in key %s`, secret.Name, user.Name, user.PasswordSecret.Key)
+				}
+			}
+			if security.LDAP != nil && security.LDAP.BindSecret != nil {
+				secret, err := client.CoreV1().Secrets(elasticsearch.Namespace).Get(security.LDAP.BindSecret.Name, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				if _, ok := secret.Data[core.BasicAuthUsernameKey]; !ok {
+					return fmt.Errorf(`secret "%v" of spec.security.ldap.bindSecret has no bind DN in key %s`, secret.Name, core.BasicAuthUsernameKey)
+				}
+				if _, ok := secret.Data[core.BasicAuthPasswordKey]; !ok {
+					return fmt.Errorf(`secret "%v" of spec.security.ldap.bindSecret has no bind password in key %s`, secret.Name, core.BasicAuthPasswordKey)
+				}
+			}
+		}
+
+		if tls := elasticsearch.Spec.TLS; tls != nil && tls.CASecret != nil {
+			caSecret, err := client.CoreV1().Secrets(elasticsearch.Namespace).Get(tls.CASecret.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if len(caSecret.Data[core.TLSCertKey]) == 0 || len(caSecret.Data[core.TLSPrivateKeyKey]) == 0 {
+				return fmt.Errorf(`secret "%v" in spec.tls.caSecret must have %s and %s`, caSecret.Name, core.TLSCertKey, core.TLSPrivateKeyKey)
+			}
+		}
+
 		// Check if elasticsearchVersion is deprecated.
 		// If deprecated, return error
 		elasticsearchVersion, err := extClient.CatalogV1alpha1().ElasticsearchVersions().Get(string(elasticsearch.Spec.Version), metav1.GetOptions{})
@@ -277,6 +322,19 @@ func ValidateElasticsearch(client kubernetes.Interface, extClient cs.Interface,
 	} else if ok, _ := arrays.Contains(supportedAuthPlugin, elasticsearch.Spec.AuthPlugin); !ok {
 		return fmt.Errorf(`'spec.authPlugin: %s' is not supported`, elasticsearch.Spec.AuthPlugin)
 	}
+	if elasticsearch.Spec.AuthPlugin == api.ElasticsearchAuthPluginXpack {
+		elasticsearchVersion, err := extClient.CatalogV1alpha1().ElasticsearchVersions().Get(string(elasticsearch.Spec.Version), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		v, err := version.NewVersion(elasticsearchVersion.Spec.Version)
+		if err != nil {
+			return fmt.Errorf("failed to parse version of ElasticsearchVersion %s. Reason: %v", elasticsearchVersion.Name, err)
+		}
+		if v.LessThan(xpackMinVersion) {
+			return fmt.Errorf(`'spec.authPlugin: %s' requires elasticsearch %v or later`, elasticsearch.Spec.AuthPlugin, xpackMinVersion)
+		}
+	}
 
 	monitorSpec := elasticsearch.Spec.Monitor
 	if monitorSpec != nil {
@@ -285,6 +343,49 @@ func ValidateElasticsearch(client kubernetes.Interface, extClient cs.Interface,
 		}
 	}
 
+	if tls := elasticsearch.Spec.TLS; tls != nil && tls.RenewBefore != nil && tls.RenewBefore.Duration <= 0 {
+		return fmt.Errorf(`spec.tls.renewBefore "%v" invalid. Must be greater than zero`, tls.RenewBefore.Duration)
+	}
+
+	if tls := elasticsearch.Spec.TLS; tls != nil {
+		switch tls.KeyStoreFormat {
+		case "", api.ElasticsearchKeyStoreFormatJKS, api.ElasticsearchKeyStoreFormatPKCS12, api.ElasticsearchKeyStoreFormatPEM:
+		default:
+			return fmt.Errorf(`spec.tls.keyStoreFormat "%v" invalid. Must be one of jks, pkcs12 or pem`, tls.KeyStoreFormat)
+		}
+		if err := validateKeySettings(tls); err != nil {
+			return err
+		}
+	}
+
+	if tls := elasticsearch.Spec.TLS; tls != nil && tls.IssuerRef != nil {
+		if tls.CASecret != nil {
+			return fmt.Errorf(`spec.tls.issuerRef and spec.tls.caSecret can't be used together`)
+		}
+		if subject := tls.Subject; subject != nil &&
+			(len(subject.OrganizationalUnits) > 0 || len(subject.Countries) > 0 || len(subject.Provinces) > 0 || len(subject.Localities) > 0) {
+			return fmt.Errorf(`spec.tls.subject only supports organizations with spec.tls.issuerRef`)
+		}
+		if tls.IssuerRef.Name == "" {
+			return fmt.Errorf(`spec.tls.issuerRef.name can't be empty`)
+		}
+		if tls.IssuerRef.Kind != "Issuer" && tls.IssuerRef.Kind != "ClusterIssuer" {
+			return fmt.Errorf(`spec.tls.issuerRef.kind "%v" invalid. Must be Issuer or ClusterIssuer`, tls.IssuerRef.Kind)
+		}
+	}
+
+	if err := validateClientCertificates(elasticsearch); err != nil {
+		return err
+	}
+
+	if err := validateSecurity(elasticsearch); err != nil {
+		return err
+	}
+
+	if err := validateLifecyclePolicies(elasticsearch); err != nil {
+		return err
+	}
+
 	if err := matchWithDormantDatabase(extClient, elasticsearch); err != nil {
 		return err
 	}
@@ -292,6 +393,326 @@ func ValidateElasticsearch(client kubernetes.Interface, extClient cs.Interface,
 	return nil
 }
 
+// validateTopologyNodes checks the master, data, client, warm and ingest nodes of the topology.
+func validateTopologyNodes(client kubernetes.Interface, topology *api.ElasticsearchClusterTopology) error {
+	if topology.Client.Prefix == topology.Master.Prefix {
+		return errors.New("client & master node should not have same prefix")
+	}
+	if topology.Client.Prefix == topology.Data.Prefix {
+		return errors.New("client & hot node should not have same prefix")
+	}
+	if topology.Client.Prefix == topology.Warm.Prefix {
+		return errors.New("client & warm node should not have same prefix")
+	}
+	if topology.Master.Prefix == topology.Data.Prefix {
+		return errors.New("master & hot node should not have same prefix")
+	}
+	if topology.Master.Prefix == topology.Warm.Prefix {
+		return errors.New("master & warm node should not have same prefix")
+	}
+	if topology.Warm.Prefix == topology.Data.Prefix {
+		return errors.New("warm & hot node should not have same prefix")
+	}
+	if topology.Ingest.Replicas != nil {
+		for _, node := range []struct {
+			name   string
+			prefix string
+		}{
+			{"client", topology.Client.Prefix},
+			{"master", topology.Master.Prefix},
+			{"hot", topology.Data.Prefix},
+			{"warm", topology.Warm.Prefix},
+		} {
+			if topology.Ingest.Prefix == node.prefix {
+				return fmt.Errorf("ingest & %v node should not have same prefix", node.name)
+			}
+		}
+		if *topology.Ingest.Replicas < 1 {
+			return fmt.Errorf(`topology.ingest.replicas "%v" invalid. Must be greater than zero`, topology.Ingest.Replicas)
+		}
+		if err := amv.ValidateStorage(client, topology.Ingest.StorageType, topology.Ingest.Storage); err != nil {
+			return err
+		}
+		if err := validateHeapSize("topology.ingest.heapSize", topology.Ingest.HeapSize, topology.Ingest.Resources); err != nil {
+			return err
+		}
+	} else if topology.RouteToIngest {
+		return errors.New("topology.routeToIngest requires topology.ingest")
+	}
+	if topology.Client.Replicas == nil || *topology.Client.Replicas < 1 {
+		return fmt.Errorf(`topology.client.replicas "%v" invalid. Must be greater than zero`, topology.Client.Replicas)
+	}
+	if err := amv.ValidateStorage(client, topology.Client.StorageType, topology.Client.Storage); err != nil {
+		return err
+	}
+
+	if topology.Master.Replicas == nil || *topology.Master.Replicas < 1 {
+		return fmt.Errorf(`topology.master.replicas "%v" invalid. Must be greater than zero`, topology.Master.Replicas)
+	}
+	if err := amv.ValidateStorage(client, topology.Master.StorageType, topology.Master.Storage); err != nil {
+		return err
+	}
+
+	if topology.Data.Replicas == nil || *topology.Data.Replicas < 1 {
+		return fmt.Errorf(`topology.data.replicas "%v" invalid. Must be greater than zero`, topology.Data.Replicas)
+	}
+	if err := amv.ValidateStorage(client, topology.Data.StorageType, topology.Data.Storage); err != nil {
+		return err
+	}
+
+	if err := validateHeapSize("topology.client.heapSize", topology.Client.HeapSize, topology.Client.Resources); err != nil {
+		return err
+	}
+	if err := validateHeapSize("topology.master.heapSize", topology.Master.HeapSize, topology.Master.Resources); err != nil {
+		return err
+	}
+	if err := validateHeapSize("topology.data.heapSize", topology.Data.HeapSize, topology.Data.Resources); err != nil {
+		return err
+	}
+	if err := validateHeapSize("topology.warm.heapSize", topology.Warm.HeapSize, topology.Warm.Resources); err != nil {
+		return err
+	}
+	return nil
+}
+
+// nodeAttributeKey is a custom node attribute, node.attr.<key>
+var nodeAttributeKey = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// validateNodePools checks the node pools of the topology. The cluster needs master, data and
+// coordinating nodes, and a StatefulSet per node pool.
+func validateNodePools(client kubernetes.Interface, topology *api.ElasticsearchClusterTopology) error {
+	for _, node := range []struct {
+		name string
+		node api.ElasticsearchNode
+	}{
+		{"master", topology.Master},
+		{"data", topology.Data},
+		{"client", topology.Client},
+		{"warm", topology.Warm},
+		{"ingest", topology.Ingest},
+	} {
+		if node.node.Replicas != nil || node.node.Prefix != "" {
+			return fmt.Errorf("doesn't support topology.%v when topology.nodePools is set", node.name)
+		}
+	}
+
+	names := sets.NewString()
+	roles := make(map[api.ElasticsearchNodeRole]bool)
+	hasIngestNodes := false
+	for i, pool := range topology.NodePools {
+		if errs := validation.IsDNS1123Label(pool.Name); len(errs) > 0 {
+			return fmt.Errorf(`topology.nodePools[%d].name "%v" invalid. Reason: %v`, i, pool.Name, strings.Join(errs, "; "))
+		}
+		if names.Has(pool.Name) {
+			return fmt.Errorf(`topology.nodePools name "%v" is duplicated`, pool.Name)
+		}
+		names.Insert(pool.Name)
+
+		if len(pool.Roles) == 0 {
+			return fmt.Errorf(`topology.nodePools "%v" must have at least one role`, pool.Name)
+		}
+		poolRoles := make(map[api.ElasticsearchNodeRole]bool)
+		for _, role := range pool.Roles {
+			if ok, _ := arrays.Contains(supportedNodeRoles, role); !ok {
+				return fmt.Errorf(`topology.nodePools "%v" role "%v" invalid. Must be one of %v`, pool.Name, role, supportedNodeRoles)
+			}
+			if poolRoles[role] {
+				return fmt.Errorf(`topology.nodePools "%v" role "%v" is duplicated`, pool.Name, role)
+			}
+			poolRoles[role] = true
+			roles[role] = true
+		}
+		hasIngestNodes = hasIngestNodes || (poolRoles[api.ElasticsearchNodeRoleIngest] && !poolRoles[api.ElasticsearchNodeRoleCoordinating])
+
+		for k, v := range pool.Attributes {
+			if !nodeAttributeKey.MatchString(k) {
+				return fmt.Errorf(`topology.nodePools "%v" attribute "%v" invalid. Must match %v`, pool.Name, k, nodeAttributeKey)
+			}
+			if v == "" || strings.Contains(v, ",") {
+				return fmt.Errorf(`topology.nodePools "%v" attribute "%v" value "%v" invalid. Must be non-empty and without ","`, pool.Name, k, v)
+			}
+		}
+
+		if pool.Replicas == nil || *pool.Replicas < 1 {
+			return fmt.Errorf(`topology.nodePools "%v" replicas "%v" invalid. Must be greater than zero`, pool.Name, pool.Replicas)
+		}
+		if err := amv.ValidateStorage(client, pool.StorageType, pool.Storage); err != nil {
+			return err
+		}
+		if err := validateHeapSize(fmt.Sprintf("topology.nodePools[%d].heapSize", i), pool.HeapSize, pool.Resources); err != nil {
+			return err
+		}
+	}
+
+	for _, role := range []api.ElasticsearchNodeRole{
+		api.ElasticsearchNodeRoleMaster,
+		api.ElasticsearchNodeRoleData,
+		api.ElasticsearchNodeRoleCoordinating,
+	} {
+		if !roles[role] {
+			return fmt.Errorf(`topology.nodePools must have a node pool with role "%v"`, role)
+		}
+	}
+	if topology.RouteToIngest && !hasIngestNodes {
+		return errors.New("topology.routeToIngest requires a node pool with role ingest, but not coordinating")
+	}
+	return nil
+}
+
+// validateNodePoolUpdate checks that the roles and storage of the existing node pools are not
+// changed. The roles are part of the selector of the StatefulSet of a node pool.
+func validateNodePoolUpdate(oldElasticsearch, elasticsearch *api.Elasticsearch) error {
+	if oldElasticsearch.Spec.Topology == nil || elasticsearch.Spec.Topology == nil {
+		return nil
+	}
+	oldPools := make(map[string]api.ElasticsearchNodePool)
+	for _, pool := range oldElasticsearch.Spec.Topology.NodePools {
+		oldPools[pool.Name] = pool
+	}
+	for _, pool := range elasticsearch.Spec.Topology.NodePools {
+		oldPool, ok := oldPools[pool.Name]
+		if !ok {
+			continue
+		}
+		if !nodeRoleSet(oldPool.Roles).Equal(nodeRoleSet(pool.Roles)) {
+			return fmt.Errorf(`roles of topology.nodePools "%v" can't be changed`, pool.Name)
+		}
+		if oldPool.StorageType != pool.StorageType || !reflect.DeepEqual(oldPool.Storage, pool.Storage) {
+			return fmt.Errorf(`storage of topology.nodePools "%v" can't be changed`, pool.Name)
+		}
+	}
+	return nil
+}
+
+func nodeRoleSet(roles []api.ElasticsearchNodeRole) sets.String {
+	set := sets.NewString()
+	for _, role := range roles {
+		set.Insert(string(role))
+	}
+	return set
+}
+
+// validateHeapSize checks that an explicit heap size fits into the memory limit, or request
+// if no limit is set, of the container.
+func validateHeapSize(field string, heapSize *resource.Quantity, resources core.ResourceRequirements) error {
+	if heapSize == nil {
+		return nil
+	}
+	if heapSize.Sign() <= 0 {
+		return fmt.Errorf(`%s "%v" invalid. Must be greater than zero`, field, heapSize.String())
+	}
+
+	memory, found := resources.Limits[core.ResourceMemory]
+	if !found {
+		memory, found = resources.Requests[core.ResourceMemory]
+	}
+	if found && heapSize.Cmp(memory) > 0 {
+		return fmt.Errorf(`%s "%v" is larger than the container memory "%v"`, field, heapSize.String(), memory.String())
+	}
+	return nil
+}
+
+// hasWarmNodes reports whether the topology has warm data nodes, or node pools with the box_type warm.
+func hasWarmNodes(topology *api.ElasticsearchClusterTopology) bool {
+	if topology == nil {
+		return false
+	}
+	if topology.Warm.Replicas != nil {
+		return true
+	}
+	for _, pool := range topology.NodePools {
+		if ok, _ := arrays.Contains(pool.Roles, api.ElasticsearchNodeRoleData); ok && pool.Attributes["box_type"] == "warm" {
+			return true
+		}
+	}
+	return false
+}
+
+func validateLifecyclePolicies(elasticsearch *api.Elasticsearch) error {
+	topology := elasticsearch.Spec.Topology
+	for i, policy := range elasticsearch.Spec.LifecyclePolicies {
+		if policy.IndexPattern == "" {
+			return fmt.Errorf(`'spec.lifecyclePolicies[%d].indexPattern' is missing`, i)
+		}
+		if policy.WarmAfter != nil && !hasWarmNodes(topology) {
+			return fmt.Errorf(`'spec.lifecyclePolicies[%d].warmAfter' requires warm nodes in 'spec.topology'`, i)
+		}
+		if policy.ForceMergeSegments != nil {
+			if policy.WarmAfter == nil {
+				return fmt.Errorf(`'spec.lifecyclePolicies[%d].forceMergeSegments' requires 'warmAfter'`, i)
+			}
+			if *policy.ForceMergeSegments < 1 {
+				return fmt.Errorf(`'spec.lifecyclePolicies[%d].forceMergeSegments' must be greater than zero`, i)
+			}
+		}
+		if policy.WarmAfter != nil && policy.DeleteAfter != nil && policy.DeleteAfter.Duration <= policy.WarmAfter.Duration {
+			return fmt.Errorf(`'spec.lifecyclePolicies[%d].deleteAfter' must be greater than 'warmAfter'`, i)
+		}
+	}
+	return nil
+}
+
+// rollingUpgradeMinors maps a major version to its last minor version, which can be upgraded
+// to the next major version without a full cluster restart.
+var rollingUpgradeMinors = map[int]int{
+	5: 6,
+	6: 8,
+}
+
+// validateVersionUpgrade checks that a change of spec.version is an upgrade that can be done
+// node by node. If the target ElasticsearchVersion has an upgradeConstraint, the current
+// version must satisfy it. Otherwise, upgrades within a major version are allowed, and upgrades
+// to the next major version only from the last minor version of the previous one.
+func validateVersionUpgrade(extClient cs.Interface, oldElasticsearch, elasticsearch *api.Elasticsearch) error {
+	if oldElasticsearch.Spec.Version == elasticsearch.Spec.Version {
+		return nil
+	}
+
+	oldVersion, err := extClient.CatalogV1alpha1().ElasticsearchVersions().Get(string(oldElasticsearch.Spec.Version), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	newVersion, err := extClient.CatalogV1alpha1().ElasticsearchVersions().Get(string(elasticsearch.Spec.Version), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	from, err := version.NewVersion(oldVersion.Spec.Version)
+	if err != nil {
+		return fmt.Errorf("failed to parse version of ElasticsearchVersion %s. Reason: %v", oldVersion.Name, err)
+	}
+	to, err := version.NewVersion(newVersion.Spec.Version)
+	if err != nil {
+		return fmt.Errorf("failed to parse version of ElasticsearchVersion %s. Reason: %v", newVersion.Name, err)
+	}
+
+	if to.LessThan(from) {
+		return fmt.Errorf("can't downgrade elasticsearch from version %v to %v", from, to)
+	}
+
+	if newVersion.Spec.UpgradeConstraint != "" {
+		constraint, err := version.NewConstraint(newVersion.Spec.UpgradeConstraint)
+		if err != nil {
+			return fmt.Errorf("failed to parse upgradeConstraint of ElasticsearchVersion %s. Reason: %v", newVersion.Name, err)
+		}
+		if !constraint.Check(from) {
+			return fmt.Errorf("can't upgrade elasticsearch from version %v to %v. Supported versions to upgrade from: %s", from, to, constraint)
+		}
+		return nil
+	}
+
+	fromSegments, toSegments := from.Segments(), to.Segments()
+	switch toSegments[0] - fromSegments[0] {
+	case 0:
+		return nil
+	case 1:
+		if minor, ok := rollingUpgradeMinors[fromSegments[0]]; ok && fromSegments[1] >= minor {
+			return nil
+		}
+		return fmt.Errorf("can't upgrade elasticsearch from version %v to %v. Upgrade to %d.%d first", from, to, fromSegments[0], rollingUpgradeMinors[fromSegments[0]])
+	}
+	return fmt.Errorf("can't upgrade elasticsearch from version %v to %v. Upgrade one major version at a time", from, to)
+}
+
 func matchWithDormantDatabase(extClient cs.Interface, elasticsearch *api.Elasticsearch) error {
 	// Check if DormantDatabase exists or not
 	dormantDb, err := extClient.KubedbV1alpha1().DormantDatabases(elasticsearch.Namespace).Get(elasticsearch.Name, metav1.GetOptions{})
@@ -364,11 +785,158 @@ func getPreconditionFunc() []mergepatch.PreconditionFunc {
 	return preconditions
 }
 
+// validateKeySettings checks the key algorithm, key size and validity of the certificates
+// the operator issues.
+func validateKeySettings(tls *api.ElasticsearchTLSConfig) error {
+	switch tls.KeyAlgorithm {
+	case "", api.ElasticsearchKeyAlgorithmRSA:
+		if tls.KeySize != 0 && tls.KeySize != 2048 && tls.KeySize != 3072 && tls.KeySize != 4096 {
+			return fmt.Errorf(`spec.tls.keySize "%v" invalid. RSA keys must be 2048, 3072 or 4096 bits`, tls.KeySize)
+		}
+	case api.ElasticsearchKeyAlgorithmECDSA:
+		if tls.KeySize != 0 && tls.KeySize != 256 && tls.KeySize != 384 {
+			return fmt.Errorf(`spec.tls.keySize "%v" invalid. ECDSA keys must be 256 or 384 bits`, tls.KeySize)
+		}
+	default:
+		return fmt.Errorf(`spec.tls.keyAlgorithm "%v" invalid. Must be rsa or ecdsa`, tls.KeyAlgorithm)
+	}
+
+	if tls.Duration != nil {
+		renewBefore := DefaultCertificateRenewBefore
+		if tls.RenewBefore != nil {
+			renewBefore = tls.RenewBefore.Duration
+		}
+		if tls.Duration.Duration <= renewBefore {
+			return fmt.Errorf(`spec.tls.duration "%v" invalid. Must be longer than spec.tls.renewBefore "%v"`, tls.Duration.Duration, renewBefore)
+		}
+	}
+	return nil
+}
+
+// validateClientCertificates checks the names and roles of the client certificates in
+// spec.tls.clientCertificates. They authenticate with Search Guard over HTTPS.
+func validateClientCertificates(elasticsearch *api.Elasticsearch) error {
+	if elasticsearch.Spec.TLS == nil || len(elasticsearch.Spec.TLS.ClientCertificates) == 0 {
+		return nil
+	}
+	if !elasticsearch.Spec.EnableSSL || elasticsearch.Spec.AuthPlugin != api.ElasticsearchAuthPluginSearchGuard {
+		return fmt.Errorf(`spec.tls.clientCertificates requires spec.enableSSL and authPlugin SearchGuard`)
+	}
+
+	names := sets.NewString()
+	for _, cc := range elasticsearch.Spec.TLS.ClientCertificates {
+		if errs := validation.IsDNS1123Label(cc.Name); len(errs) > 0 {
+			return fmt.Errorf(`spec.tls.clientCertificates name "%v" invalid. Reason: %v`, cc.Name, strings.Join(errs, "; "))
+		}
+		if names.Has(cc.Name) {
+			return fmt.Errorf(`spec.tls.clientCertificates name "%v" is duplicated`, cc.Name)
+		}
+		// the subject of client certificates only differs from the node and sgadmin certificates
+		// by the common name, so these names would get the privileges of the nodes or sgadmin
+		if cc.Name == elasticsearch.OffshootName() || cc.Name == sgAdminCommonName {
+			return fmt.Errorf(`spec.tls.clientCertificates name "%v" is reserved for the nodes and sgadmin`, cc.Name)
+		}
+		names.Insert(cc.Name)
+		if len(cc.Roles) == 0 {
+			return fmt.Errorf(`spec.tls.clientCertificates "%v" must have at least one role`, cc.Name)
+		}
+	}
+	return nil
+}
+
+// reservedUsers and reservedRoles are created by the operator and can't be declared in spec.security.
+var (
+	reservedUsers = sets.NewString("admin", "readall", "admin-rotation", "readall-rotation")
+	reservedRoles = sets.NewString("sg_all_access", "sg_readall", "all_access", "readall")
+)
+
+// validateSecurity checks the users, roles and role mappings in spec.security.
+func validateSecurity(elasticsearch *api.Elasticsearch) error {
+	security := elasticsearch.Spec.Security
+	if security == nil || (len(security.Users) == 0 && len(security.Roles) == 0 && len(security.RoleMappings) == 0 && security.LDAP == nil) {
+		return nil
+	}
+	if elasticsearch.Spec.AuthPlugin != api.ElasticsearchAuthPluginSearchGuard {
+		return fmt.Errorf(`spec.security requires authPlugin SearchGuard`)
+	}
+
+	users := sets.NewString()
+	for _, user := range security.Users {
+		if user.Name == "" {
+			return fmt.Errorf(`spec.security.users name can't be empty`)
+		}
+		if reservedUsers.Has(user.Name) || users.Has(user.Name) {
+			return fmt.Errorf(`spec.security.users name "%v" is reserved or duplicated`, user.Name)
+		}
+		users.Insert(user.Name)
+		if user.PasswordSecret.Name == "" || user.PasswordSecret.Key == "" {
+			return fmt.Errorf(`spec.security.users "%v" must have passwordSecret name and key`, user.Name)
+		}
+	}
+
+	roles := sets.NewString()
+	for _, role := range security.Roles {
+		if role.Name == "" {
+			return fmt.Errorf(`spec.security.roles name can't be empty`)
+		}
+		if reservedRoles.Has(role.Name) || roles.Has(role.Name) {
+			return fmt.Errorf(`spec.security.roles name "%v" is reserved or duplicated`, role.Name)
+		}
+		roles.Insert(role.Name)
+		for _, index := range role.Indices {
+			if index.Index == "" || len(index.Permissions) == 0 {
+				return fmt.Errorf(`spec.security.roles "%v" must have index and permissions for all indices`, role.Name)
+			}
+		}
+	}
+
+	mapped := sets.NewString()
+	for _, m := range security.RoleMappings {
+		if m.Role == "" {
+			return fmt.Errorf(`spec.security.roleMappings role can't be empty`)
+		}
+		if mapped.Has(m.Role) {
+			return fmt.Errorf(`spec.security.roleMappings role "%v" is duplicated`, m.Role)
+		}
+		mapped.Insert(m.Role)
+		if len(m.Users) == 0 && len(m.BackendRoles) == 0 {
+			return fmt.Errorf(`spec.security.roleMappings "%v" must have users or backendRoles`, m.Role)
+		}
+	}
+
+	if ldap := security.LDAP; ldap != nil {
+		if len(ldap.Hosts) == 0 {
+			return fmt.Errorf(`spec.security.ldap.hosts can't be empty`)
+		}
+		if ldap.UserBase == "" {
+			return fmt.Errorf(`spec.security.ldap.userBase can't be empty`)
+		}
+		if ldap.BindSecret != nil && ldap.BindSecret.Name == "" {
+			return fmt.Errorf(`spec.security.ldap.bindSecret must have name`)
+		}
+		if tls := ldap.TLS; tls != nil {
+			if tls.EnableSSL && tls.EnableStartTLS {
+				return fmt.Errorf(`spec.security.ldap.tls can't enable both SSL and StartTLS`)
+			}
+			if tls.CASecret != nil && (tls.CASecret.Name == "" || tls.CASecret.Key == "") {
+				return fmt.Errorf(`spec.security.ldap.tls.caSecret must have name and key`)
+			}
+		}
+	}
+	return nil
+}
+
 var preconditionSpecFields = []string{
 	"spec.topology.*.prefix",
 	"spec.topology.*.storage",
 	"spec.enableSSL",
 	"spec.certificateSecret",
+	"spec.tls.caSecret",
+	"spec.tls.issuerRef",
+	"spec.tls.subject",
+	"spec.tls.keyAlgorithm",
+	"spec.tls.keySize",
+	"spec.tls.duration",
 	"spec.authPlugin",
 	"spec.databaseSecret",
 	"spec.storageType",
@@ -3,7 +3,9 @@ package admission
 import (
 	"net/http"
 	"testing"
+	"time"
 
+	jtypes "github.com/appscode/go/encoding/json/types"
 	"github.com/appscode/go/types"
 	admission "k8s.io/api/admission/v1beta1"
 	apps "k8s.io/api/apps/v1"
@@ -47,6 +49,33 @@ func TestElasticsearchValidator_Admit(t *testing.T) {
 					ObjectMeta: metaV1.ObjectMeta{
 						Name: "5.6",
 					},
+					Spec: catalog.ElasticsearchVersionSpec{
+						Version: "5.6",
+					},
+				},
+				&catalog.ElasticsearchVersion{
+					ObjectMeta: metaV1.ObjectMeta{
+						Name: "6.5",
+					},
+					Spec: catalog.ElasticsearchVersionSpec{
+						Version: "6.5.3",
+					},
+				},
+				&catalog.ElasticsearchVersion{
+					ObjectMeta: metaV1.ObjectMeta{
+						Name: "6.8",
+					},
+					Spec: catalog.ElasticsearchVersionSpec{
+						Version: "6.8.0",
+					},
+				},
+				&catalog.ElasticsearchVersion{
+					ObjectMeta: metaV1.ObjectMeta{
+						Name: "7.3",
+					},
+					Spec: catalog.ElasticsearchVersionSpec{
+						Version: "7.3.2",
+					},
 				},
 			)
 			validator.client = fake.NewSimpleClientset(
@@ -56,6 +85,25 @@ func TestElasticsearchValidator_Admit(t *testing.T) {
 						Namespace: "default",
 					},
 				},
+				&core.Secret{
+					ObjectMeta: metaV1.ObjectMeta{
+						Name:      "foo-ldap-bind",
+						Namespace: "default",
+					},
+					Data: map[string][]byte{
+						core.BasicAuthUsernameKey: []byte("cn=admin,dc=example,dc=org"),
+						core.BasicAuthPasswordKey: []byte("secret"),
+					},
+				},
+				&core.Secret{
+					ObjectMeta: metaV1.ObjectMeta{
+						Name:      "foo-ldap-bind-without-password",
+						Namespace: "default",
+					},
+					Data: map[string][]byte{
+						core.BasicAuthUsernameKey: []byte("cn=admin,dc=example,dc=org"),
+					},
+				},
 				&storageV1beta1.StorageClass{
 					ObjectMeta: metaV1.ObjectMeta{
 						Name: "standard",
@@ -140,6 +188,226 @@ var cases = []struct {
 		false,
 		false,
 	},
+	{"Create Elasticsearch with cert-manager Issuer",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setIssuerRef(sampleElasticsearch(), "Issuer"),
+		api.Elasticsearch{},
+		false,
+		true,
+	},
+	{"Create Elasticsearch with invalid cert-manager issuer kind",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setIssuerRef(sampleElasticsearch(), "Certificate"),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
+	{"Create Elasticsearch with X-Pack",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setAuthPlugin(sampleElasticsearch(), api.ElasticsearchAuthPluginXpack, "6.8"),
+		api.Elasticsearch{},
+		false,
+		true,
+	},
+	{"Create Elasticsearch with X-Pack on a version without X-Pack security",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setAuthPlugin(sampleElasticsearch(), api.ElasticsearchAuthPluginXpack, "5.6"),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
+	{"Create Elasticsearch with X-Pack on a version without security in the basic license",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setAuthPlugin(sampleElasticsearch(), api.ElasticsearchAuthPluginXpack, "6.5"),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
+	{"Create Elasticsearch with unsupported Open Distro",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setAuthPlugin(sampleElasticsearch(), api.ElasticsearchAuthPluginOpenDistro, "6.8"),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
+	{"Create Elasticsearch with users, roles and role mappings",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setSecurity(sampleElasticsearch(), "app"),
+		api.Elasticsearch{},
+		false,
+		true,
+	},
+	{"Create Elasticsearch with reserved user",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setSecurity(sampleElasticsearch(), "admin"),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
+	{"Create Elasticsearch with LDAP",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setLDAP(sampleElasticsearch(), "ldap.example.org:389"),
+		api.Elasticsearch{},
+		false,
+		true,
+	},
+	{"Create Elasticsearch with LDAP without hosts",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setLDAP(sampleElasticsearch()),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
+	{"Create Elasticsearch with LDAP bind secret",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setLDAPBindSecret(setLDAP(sampleElasticsearch(), "ldap.example.org:389"), "foo-ldap-bind"),
+		api.Elasticsearch{},
+		false,
+		true,
+	},
+	{"Create Elasticsearch with LDAP bind secret without password",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setLDAPBindSecret(setLDAP(sampleElasticsearch(), "ldap.example.org:389"), "foo-ldap-bind-without-password"),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
+	{"Create Elasticsearch with dedicated ingest nodes",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setIngestTopology(sampleElasticsearch(), "ingest"),
+		api.Elasticsearch{},
+		false,
+		true,
+	},
+	{"Create Elasticsearch with ingest nodes having the prefix of client nodes",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setIngestTopology(sampleElasticsearch(), "client"),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
+	{"Create Elasticsearch with node pools",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setNodePools(sampleElasticsearch(), api.ElasticsearchNodeRoleCoordinating),
+		api.Elasticsearch{},
+		false,
+		true,
+	},
+	{"Create Elasticsearch with node pools without coordinating nodes",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setNodePools(sampleElasticsearch(), api.ElasticsearchNodeRoleIngest),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
+	{"Edit roles of a node pool",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		setNodePools(sampleElasticsearch(), api.ElasticsearchNodeRoleCoordinating, api.ElasticsearchNodeRoleIngest),
+		setNodePools(sampleElasticsearch(), api.ElasticsearchNodeRoleCoordinating),
+		false,
+		false,
+	},
+	{"Create Elasticsearch with client certificates",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setClientCertificates(sampleElasticsearch(), true, "app"),
+		api.Elasticsearch{},
+		false,
+		true,
+	},
+	{"Create Elasticsearch with duplicated client certificates",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setClientCertificates(sampleElasticsearch(), true, "app", "app"),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
+	{"Create Elasticsearch with client certificate named sgadmin",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setClientCertificates(sampleElasticsearch(), true, "sgadmin"),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
+	{"Create Elasticsearch with client certificate named after the database",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setClientCertificates(sampleElasticsearch(), true, "foo"),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
+	{"Create Elasticsearch with client certificates without SSL",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		setClientCertificates(sampleElasticsearch(), false, "app"),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
 	{"Edit Elasticsearch Spec.DatabaseSecret with Existing Secret",
 		requestKind,
 		"foo",
@@ -200,6 +468,136 @@ var cases = []struct {
 		false,
 		true,
 	},
+	{"Edit Spec.LifecyclePolicies",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		editLifecyclePolicies(sampleElasticsearch()),
+		sampleElasticsearch(),
+		false,
+		true,
+	},
+	{"Edit Spec.LifecyclePolicies without warm nodes",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		editInvalidLifecyclePolicies(sampleElasticsearch()),
+		sampleElasticsearch(),
+		false,
+		false,
+	},
+	{"Edit Spec.HeapSize",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		editHeapSize(sampleElasticsearch(), "64Mi"),
+		sampleElasticsearch(),
+		false,
+		true,
+	},
+	{"Edit Spec.HeapSize larger than memory",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		editHeapSize(sampleElasticsearch(), "1Gi"),
+		sampleElasticsearch(),
+		false,
+		false,
+	},
+	{"Edit Spec.TLS.KeyStoreFormat",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		editKeyStoreFormat(sampleElasticsearch(), api.ElasticsearchKeyStoreFormatPEM),
+		sampleElasticsearch(),
+		false,
+		true,
+	},
+	{"Edit Spec.TLS.KeyStoreFormat to unknown format",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		editKeyStoreFormat(sampleElasticsearch(), "der"),
+		sampleElasticsearch(),
+		false,
+		false,
+	},
+	{"Create Elasticsearch with Spec.TLS key settings",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		editKeySettings(sampleElasticsearch(), api.ElasticsearchKeyAlgorithmECDSA, 384),
+		api.Elasticsearch{},
+		false,
+		true,
+	},
+	{"Create Elasticsearch with invalid Spec.TLS key size",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		editKeySettings(sampleElasticsearch(), api.ElasticsearchKeyAlgorithmECDSA, 4096),
+		api.Elasticsearch{},
+		false,
+		false,
+	},
+	{"Edit Spec.TLS key settings",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		editKeySettings(sampleElasticsearch(), api.ElasticsearchKeyAlgorithmECDSA, 384),
+		sampleElasticsearch(),
+		false,
+		false,
+	},
+	{"Edit Spec.TLS key settings with invalid key size",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		editKeySettings(sampleElasticsearch(), api.ElasticsearchKeyAlgorithmECDSA, 4096),
+		sampleElasticsearch(),
+		false,
+		false,
+	},
+	{"Upgrade Spec.Version to next major version",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		editVersion(sampleElasticsearch(), "6.8"),
+		sampleElasticsearch(),
+		false,
+		true,
+	},
+	{"Upgrade Spec.Version skipping a major version",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		editVersion(sampleElasticsearch(), "7.3"),
+		sampleElasticsearch(),
+		false,
+		false,
+	},
+	{"Downgrade Spec.Version",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		sampleElasticsearch(),
+		editVersion(sampleElasticsearch(), "6.8"),
+		false,
+		false,
+	},
 	{"Delete Elasticsearch when Spec.TerminationPolicy=DoNotTerminate",
 		requestKind,
 		"foo",
@@ -334,3 +732,182 @@ func pauseDatabase(old api.Elasticsearch) api.Elasticsearch {
 	old.Spec.TerminationPolicy = api.TerminationPolicyPause
 	return old
 }
+
+func editLifecyclePolicies(old api.Elasticsearch) api.Elasticsearch {
+	old.Spec.LifecyclePolicies = []api.ElasticsearchLifecyclePolicy{
+		{
+			IndexPattern: "logs-*",
+			DeleteAfter:  &metaV1.Duration{Duration: 30 * 24 * time.Hour},
+		},
+	}
+	return old
+}
+
+// should be failed because indices can't be moved without warm nodes
+func editInvalidLifecyclePolicies(old api.Elasticsearch) api.Elasticsearch {
+	old.Spec.LifecyclePolicies = []api.ElasticsearchLifecyclePolicy{
+		{
+			IndexPattern: "logs-*",
+			WarmAfter:    &metaV1.Duration{Duration: 7 * 24 * time.Hour},
+		},
+	}
+	return old
+}
+
+func editHeapSize(old api.Elasticsearch, heapSize string) api.Elasticsearch {
+	quantity := resource.MustParse(heapSize)
+	old.Spec.HeapSize = &quantity
+	return old
+}
+
+func editKeyStoreFormat(old api.Elasticsearch, format api.ElasticsearchKeyStoreFormat) api.Elasticsearch {
+	old.Spec.TLS = &api.ElasticsearchTLSConfig{
+		KeyStoreFormat: format,
+	}
+	return old
+}
+
+func editKeySettings(old api.Elasticsearch, algorithm api.ElasticsearchKeyAlgorithm, size int) api.Elasticsearch {
+	old.Spec.TLS = &api.ElasticsearchTLSConfig{
+		KeyAlgorithm: algorithm,
+		KeySize:      size,
+		Duration:     &metaV1.Duration{Duration: 90 * 24 * time.Hour},
+	}
+	return old
+}
+
+func editVersion(old api.Elasticsearch, version string) api.Elasticsearch {
+	old.Spec.Version = jtypes.StrYo(version)
+	return old
+}
+
+func setIssuerRef(old api.Elasticsearch, kind string) api.Elasticsearch {
+	old.Spec.TLS = &api.ElasticsearchTLSConfig{
+		IssuerRef: &core.TypedLocalObjectReference{
+			APIGroup: types.StringP("certmanager.k8s.io"),
+			Kind:     kind,
+			Name:     "elasticsearch-issuer",
+		},
+	}
+	return old
+}
+
+func setClientCertificates(old api.Elasticsearch, enableSSL bool, names ...string) api.Elasticsearch {
+	old.Spec.EnableSSL = enableSSL
+	old.Spec.TLS = &api.ElasticsearchTLSConfig{}
+	for _, name := range names {
+		old.Spec.TLS.ClientCertificates = append(old.Spec.TLS.ClientCertificates, api.ElasticsearchClientCertificate{
+			Name:  name,
+			Roles: []string{"sg_readall"},
+		})
+	}
+	return old
+}
+
+func setAuthPlugin(old api.Elasticsearch, authPlugin api.ElasticsearchAuthPlugin, version string) api.Elasticsearch {
+	old.Spec.AuthPlugin = authPlugin
+	old.Spec.Version = jtypes.StrYo(version)
+	return old
+}
+
+func setSecurity(old api.Elasticsearch, user string) api.Elasticsearch {
+	old.Spec.Security = &api.ElasticsearchSecuritySpec{
+		Users: []api.ElasticsearchUser{
+			{
+				Name: user,
+				PasswordSecret: core.SecretKeySelector{
+					LocalObjectReference: core.LocalObjectReference{
+						Name: "foo-users",
+					},
+					Key: "password",
+				},
+			},
+		},
+		Roles: []api.ElasticsearchRole{
+			{
+				Name:    "logs_reader",
+				Cluster: []string{"CLUSTER_COMPOSITE_OPS_RO"},
+				Indices: []api.ElasticsearchIndexPermission{
+					{
+						Index:       "logs-*",
+						Permissions: []string{"READ"},
+					},
+				},
+			},
+		},
+		RoleMappings: []api.ElasticsearchRoleMapping{
+			{
+				Role:  "logs_reader",
+				Users: []string{user},
+			},
+		},
+	}
+	return old
+}
+
+func setLDAP(old api.Elasticsearch, hosts ...string) api.Elasticsearch {
+	old.Spec.Security = &api.ElasticsearchSecuritySpec{
+		LDAP: &api.ElasticsearchLDAPSpec{
+			Hosts:      hosts,
+			UserBase:   "ou=people,dc=example,dc=org",
+			UserSearch: "(uid={0})",
+			RoleBase:   "ou=groups,dc=example,dc=org",
+		},
+	}
+	return old
+}
+
+func setLDAPBindSecret(old api.Elasticsearch, secretName string) api.Elasticsearch {
+	old.Spec.Security.LDAP.BindSecret = &core.LocalObjectReference{Name: secretName}
+	return old
+}
+
+func setIngestTopology(old api.Elasticsearch, ingestPrefix string) api.Elasticsearch {
+	node := func(prefix string) api.ElasticsearchNode {
+		return api.ElasticsearchNode{
+			Replicas:    types.Int32P(1),
+			Prefix:      prefix,
+			StorageType: api.StorageTypeEphemeral,
+		}
+	}
+	old.Spec.Replicas = nil
+	old.Spec.Storage = nil
+	old.Spec.StorageType = ""
+	old.Spec.PodTemplate.Spec.Resources = core.ResourceRequirements{}
+	old.Spec.Topology = &api.ElasticsearchClusterTopology{
+		Master:        node("master"),
+		Data:          node("data"),
+		Client:        node("client"),
+		Warm:          api.ElasticsearchNode{Prefix: "warm"},
+		Ingest:        node(ingestPrefix),
+		RouteToIngest: true,
+	}
+	return old
+}
+
+// setNodePools replaces the nodes with master, hot and warm data node pools, and a node pool
+// with the given roles.
+func setNodePools(old api.Elasticsearch, roles ...api.ElasticsearchNodeRole) api.Elasticsearch {
+	pool := func(name string, attributes map[string]string, roles ...api.ElasticsearchNodeRole) api.ElasticsearchNodePool {
+		return api.ElasticsearchNodePool{
+			Name:        name,
+			Roles:       roles,
+			Attributes:  attributes,
+			Replicas:    types.Int32P(1),
+			StorageType: api.StorageTypeEphemeral,
+		}
+	}
+	old.Spec.Replicas = nil
+	old.Spec.Storage = nil
+	old.Spec.StorageType = ""
+	old.Spec.PodTemplate.Spec.Resources = core.ResourceRequirements{}
+	old.Spec.Topology = &api.ElasticsearchClusterTopology{
+		NodePools: []api.ElasticsearchNodePool{
+			pool("master", nil, api.ElasticsearchNodeRoleMaster),
+			pool("hot", map[string]string{"box_type": "hot", "zone": "a"}, api.ElasticsearchNodeRoleData, api.ElasticsearchNodeRoleIngest),
+			pool("warm", map[string]string{"box_type": "warm"}, api.ElasticsearchNodeRoleData),
+			pool("client", nil, roles...),
+		},
+	}
+	return old
+}
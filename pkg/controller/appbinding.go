@@ -59,7 +59,7 @@ func (c *Controller) ensureAppBinding(db *api.Elasticsearch) (kutil.VerbType, er
 			Port:   defaultClientPort.Port,
 		}
 		in.Spec.ClientConfig.CABundle = caBundle
-		in.Spec.ClientConfig.InsecureSkipTLSVerify = false
+		in.Spec.ClientConfig.InsecureSkipTLSVerify = db.Spec.TLS != nil && db.Spec.TLS.InsecureSkipVerify
 
 		in.Spec.Secret = &core.LocalObjectReference{
 			Name: db.Spec.DatabaseSecret.SecretName,
@@ -0,0 +1,330 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/appscode/go/crypto/rand"
+	"github.com/appscode/go/log"
+	"github.com/pkg/errors"
+	"gomodules.xyz/cert"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/reference"
+	core_util "kmodules.xyz/client-go/core/v1"
+	dynamic_util "kmodules.xyz/client-go/dynamic"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	"kubedb.dev/apimachinery/pkg/eventer"
+	"kubedb.dev/elasticsearch/pkg/keytool"
+)
+
+const (
+	// AnnotationCertManagerRevision is set on the certificate secret to the digest of the
+	// certificates issued by cert-manager, that its keystores are created from.
+	AnnotationCertManagerRevision = "elasticsearch.kubedb.com/cert-manager-revision"
+
+	certManagerCAKey = "ca.crt"
+)
+
+// certManagerCheckInterval is how often pending cert-manager Certificates are checked.
+var certManagerCheckInterval = 10 * time.Second
+
+var certManagerCertificates = schema.GroupVersionResource{
+	Group:    "certmanager.k8s.io",
+	Version:  "v1alpha1",
+	Resource: "certificates",
+}
+
+// certManagerIdentity is a certificate requested from cert-manager and the keystores
+// of the certificate secret it is converted to.
+type certManagerIdentity struct {
+	name       string
+	commonName string
+	dnsNames   []string
+	usages     []string
+	labels     map[string]string
+	files      identityFiles
+}
+
+var serverClientUsages = []string{"server auth", "client auth"}
+
+func usesCertManager(elasticsearch *api.Elasticsearch) bool {
+	return elasticsearch.Spec.TLS != nil && elasticsearch.Spec.TLS.IssuerRef != nil
+}
+
+func (c *Controller) certManagerIdentities(elasticsearch *api.Elasticsearch) []certManagerIdentity {
+	identities := []certManagerIdentity{
+		{
+			name:       "node",
+			commonName: elasticsearch.OffshootName(),
+			dnsNames:   c.nodeDNSNames(elasticsearch),
+			usages:     serverClientUsages,
+			files:      nodeFiles,
+		},
+		{
+			name:       "sgadmin",
+			commonName: sgAdminCommonName,
+			dnsNames:   []string{"localhost"},
+			usages:     serverClientUsages,
+			files:      sgAdminFiles,
+		},
+	}
+	if elasticsearch.Spec.EnableSSL {
+		identities = append(identities, certManagerIdentity{
+			name:       "client",
+			commonName: elasticsearch.OffshootName(),
+			dnsNames:   clientDNSNames(elasticsearch),
+			usages:     serverClientUsages,
+			files:      clientFiles,
+		})
+	}
+	return identities
+}
+
+func certManagerCertificateName(elasticsearch *api.Elasticsearch, identity certManagerIdentity) string {
+	return fmt.Sprintf("%v-%v-cert", elasticsearch.OffshootName(), identity.name)
+}
+
+// ensureCertManagerCertificates requests the node, sgadmin and client certificates from the
+// cert-manager issuer in spec.tls.issuerRef, and converts the issued secrets into the keystores
+// of the certificate secret. It checks the certificates once instead of blocking the worker.
+// If they are not issued yet, the key is requeued and false is returned.
+// Whenever cert-manager renews the certificates, the nodes are restarted one at a time.
+func (c *Controller) ensureCertManagerCertificates(elasticsearch *api.Elasticsearch) (bool, error) {
+	if !usesCertManager(elasticsearch) {
+		return true, nil
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(elasticsearch)
+	if err != nil {
+		return false, err
+	}
+
+	identities := c.certManagerIdentities(elasticsearch)
+	secrets := make(map[string]*core.Secret)
+	var pending []string
+	for _, identity := range identities {
+		crt, err := c.ensureCertManagerCertificate(elasticsearch, identity)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to ensure cert-manager Certificate %s", certManagerCertificateName(elasticsearch, identity))
+		}
+		secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(certManagerCertificateName(elasticsearch, identity), metav1.GetOptions{})
+		if err != nil && !kerr.IsNotFound(err) {
+			return false, err
+		}
+		if err != nil ||
+			!isCertManagerCertificateReady(crt) ||
+			len(secret.Data[core.TLSCertKey]) == 0 ||
+			len(secret.Data[core.TLSPrivateKeyKey]) == 0 {
+			pending = append(pending, crt.GetName())
+			continue
+		}
+		secrets[identity.name] = secret
+	}
+	if len(pending) > 0 {
+		log.Infof("Elasticsearch %v is waiting for cert-manager to issue Certificates %v", key, strings.Join(pending, ", "))
+		c.esQueue.GetQueue().AddAfter(key, certManagerCheckInterval)
+		return false, nil
+	}
+
+	return true, c.upsertCertManagerKeyStores(elasticsearch, identities, secrets)
+}
+
+func (c *Controller) ensureCertManagerCertificate(elasticsearch *api.Elasticsearch, identity certManagerIdentity) (*unstructured.Unstructured, error) {
+	ref, err := reference.GetReference(clientsetscheme.Scheme, elasticsearch)
+	if err != nil {
+		return nil, err
+	}
+	tls := elasticsearch.Spec.TLS
+	issuer := tls.IssuerRef
+	meta := metav1.ObjectMeta{
+		Name:      certManagerCertificateName(elasticsearch, identity),
+		Namespace: elasticsearch.Namespace,
+	}
+
+	crt, _, err := dynamic_util.CreateOrPatch(c.DynamicClient, certManagerCertificates, meta, func(in *unstructured.Unstructured) *unstructured.Unstructured {
+		in.SetAPIVersion(certManagerCertificates.GroupVersion().String())
+		in.SetKind("Certificate")
+		in.SetLabels(core_util.UpsertMap(elasticsearch.OffshootLabels(), identity.labels))
+		core_util.EnsureOwnerReference(in, ref)
+
+		spec := map[string]interface{}{
+			"secretName":   meta.Name,
+			"commonName":   identity.commonName,
+			"organization": toInterfaceSlice(certificateSubject(elasticsearch, identity.commonName).Organization),
+			"usages":       toInterfaceSlice(identity.usages),
+			"keyEncoding":  "pkcs8",
+			"issuerRef": map[string]interface{}{
+				"name": issuer.Name,
+				"kind": issuer.Kind,
+			},
+		}
+		if len(identity.dnsNames) > 0 {
+			spec["dnsNames"] = toInterfaceSlice(identity.dnsNames)
+			spec["ipAddresses"] = []interface{}{"127.0.0.1"}
+		}
+		if tls.KeyAlgorithm != "" {
+			spec["keyAlgorithm"] = string(tls.KeyAlgorithm)
+		}
+		if tls.KeySize != 0 {
+			spec["keySize"] = int64(tls.KeySize)
+		}
+		if tls.Duration != nil {
+			spec["duration"] = tls.Duration.Duration.String()
+		}
+		if issuer.APIGroup != nil {
+			spec["issuerRef"].(map[string]interface{})["group"] = *issuer.APIGroup
+		}
+		in.Object["spec"] = spec
+		return in
+	})
+	return crt, err
+}
+
+func toInterfaceSlice(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i := range values {
+		out[i] = values[i]
+	}
+	return out
+}
+
+// isCertManagerCertificateReady reports whether the Ready condition of a cert-manager
+// Certificate is True, ie, its secret holds a certificate that matches its spec.
+func isCertManagerCertificateReady(crt *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(crt.Object, "status", "conditions")
+	for _, cond := range conditions {
+		m, ok := cond.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if m["type"] == "Ready" {
+			return m["status"] == string(core.ConditionTrue)
+		}
+	}
+	return false
+}
+
+// upsertCertManagerKeyStores creates the certificate secret with the keystores, that are
+// mounted into the nodes, from the secrets issued by cert-manager. The truststore holds
+// the CA of the issuer.
+func (c *Controller) upsertCertManagerKeyStores(elasticsearch *api.Elasticsearch, identities []certManagerIdentity, secrets map[string]*core.Secret) error {
+	certSecret, err := c.findCertSecret(elasticsearch)
+	if err != nil {
+		return err
+	}
+
+	revision := certManagerRevision(identities, secrets)
+	var oldRevision string
+	pass := rand.Characters(6)
+	if certSecret != nil {
+		oldRevision = certSecret.Annotations[AnnotationCertManagerRevision]
+		if p := certSecret.Data["key_pass"]; len(p) > 0 {
+			pass = string(p)
+		}
+	}
+
+	if oldRevision != revision {
+		data := map[string][]byte{
+			"key_pass": []byte(pass),
+		}
+		for _, identity := range identities {
+			if err := createCertManagerKeyStore(data, identity, secrets[identity.name], pass); err != nil {
+				return err
+			}
+		}
+
+		meta := metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v-cert", elasticsearch.OffshootName()),
+			Namespace: elasticsearch.Namespace,
+		}
+		if certSecret, _, err = core_util.CreateOrPatchSecret(c.Client, meta, func(in *core.Secret) *core.Secret {
+			in.Labels = elasticsearch.OffshootLabels()
+			in.Annotations = core_util.UpsertMap(in.Annotations, map[string]string{
+				AnnotationCertManagerRevision: revision,
+			})
+			in.Type = core.SecretTypeOpaque
+			in.Data = data
+			return in
+		}); err != nil {
+			return err
+		}
+	}
+
+	if elasticsearch.Spec.CertificateSecret == nil {
+		es, _, err := util.PatchElasticsearch(c.ExtClient.KubedbV1alpha1(), elasticsearch, func(in *api.Elasticsearch) *api.Elasticsearch {
+			in.Spec.CertificateSecret = &core.SecretVolumeSource{
+				SecretName: certSecret.Name,
+			}
+			return in
+		})
+		if err != nil {
+			return err
+		}
+		elasticsearch.Spec.CertificateSecret = es.Spec.CertificateSecret
+	}
+
+	if oldRevision == "" || oldRevision == revision {
+		return nil
+	}
+	c.recorder.Event(elasticsearch, core.EventTypeNormal, eventer.EventReasonSuccessful, "Successfully loaded certificates renewed by cert-manager")
+	return c.restartForRenewedCertificates(elasticsearch)
+}
+
+// createCertManagerKeyStore converts the certificate and key issued by cert-manager for
+// identity to the keystores of the certificate secret. The CA is read from ca.crt, or else from the chain in tls.crt.
+func createCertManagerKeyStore(data map[string][]byte, identity certManagerIdentity, secret *core.Secret, pass string) error {
+	certs, err := cert.ParseCertsPEM(secret.Data[core.TLSCertKey])
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse %s of secret %s/%s", core.TLSCertKey, secret.Namespace, secret.Name)
+	}
+	key, err := cert.ParsePrivateKeyPEM(secret.Data[core.TLSPrivateKeyKey])
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse %s of secret %s/%s", core.TLSPrivateKeyKey, secret.Namespace, secret.Name)
+	}
+
+	caCerts := certs[1:]
+	if ca := secret.Data[certManagerCAKey]; len(ca) > 0 {
+		if caCerts, err = cert.ParseCertsPEM(ca); err != nil {
+			return errors.Wrapf(err, "failed to parse %s of secret %s/%s", certManagerCAKey, secret.Namespace, secret.Name)
+		}
+	}
+	if len(caCerts) == 0 {
+		return fmt.Errorf("secret %s/%s has neither %s nor the chain of the CA in %s", secret.Namespace, secret.Name, certManagerCAKey, core.TLSCertKey)
+	}
+
+	pfxData, err := keytool.NewPKCS12(key, certs[0], caCerts, pass)
+	if err != nil {
+		return fmt.Errorf("failed to generate %s. Reason: %v", identity.files.pkcs12, errors.Cause(err))
+	}
+	if err := storeKeyStores(data, identity.files, pfxData, pass); err != nil {
+		return err
+	}
+
+	if identity.files == nodeFiles {
+		if err := createTrustStore(data, caCerts, pass); err != nil {
+			return err
+		}
+		data[rootCert] = encodeCertsPEM(caCerts)
+	}
+	return nil
+}
+
+// certManagerRevision returns the digest of the certificates issued by cert-manager.
+func certManagerRevision(identities []certManagerIdentity, secrets map[string]*core.Secret) string {
+	h := sha256.New()
+	for _, identity := range identities {
+		secret := secrets[identity.name]
+		h.Write(secret.Data[core.TLSCertKey])
+		h.Write(secret.Data[certManagerCAKey])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
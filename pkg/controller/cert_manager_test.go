@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"gomodules.xyz/cert"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	core_util "kmodules.xyz/client-go/core/v1"
+	"kmodules.xyz/client-go/tools/queue"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	kubedb_fake "kubedb.dev/apimachinery/client/clientset/versioned/fake"
+	amc "kubedb.dev/apimachinery/pkg/controller"
+)
+
+// fakeCertificates is a dynamic client that only knows cert-manager Certificates.
+type fakeCertificates struct {
+	dynamic.NamespaceableResourceInterface
+	objects map[string]*unstructured.Unstructured
+}
+
+func (f *fakeCertificates) Resource(resource schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return f
+}
+
+func (f *fakeCertificates) Namespace(string) dynamic.ResourceInterface {
+	return f
+}
+
+func (f *fakeCertificates) Get(name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if obj, ok := f.objects[name]; ok {
+		return obj.DeepCopy(), nil
+	}
+	return nil, kerr.NewNotFound(certManagerCertificates.GroupResource(), name)
+}
+
+func (f *fakeCertificates) Create(obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	f.objects[obj.GetName()] = obj.DeepCopy()
+	return obj, nil
+}
+
+func newCertManagerElasticsearch() *api.Elasticsearch {
+	return &api.Elasticsearch{
+		TypeMeta:   metav1.TypeMeta{APIVersion: api.SchemeGroupVersion.String(), Kind: api.ResourceKindElasticsearch},
+		ObjectMeta: metav1.ObjectMeta{Name: "es", Namespace: "demo"},
+		Spec: api.ElasticsearchSpec{
+			TLS: &api.ElasticsearchTLSConfig{
+				IssuerRef: &core.TypedLocalObjectReference{Kind: "Issuer", Name: "ca-issuer"},
+			},
+		},
+	}
+}
+
+// newIssuedSecret returns a secret like the ones cert-manager creates for a Certificate.
+func newIssuedSecret(t *testing.T, elasticsearch *api.Elasticsearch, name string, withCA bool) *core.Secret {
+	caKey, caCert, err := createCaCertificate(map[string][]byte{}, elasticsearch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := newPrivateKey(elasticsearch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := NewSignedCert(certConfig{
+		Subject:  pkix.Name{CommonName: name},
+		Usages:   []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		Duration: time.Hour,
+	}, key, caCert, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := cert.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: elasticsearch.Namespace},
+		Data: map[string][]byte{
+			core.TLSPrivateKeyKey: keyPEM,
+		},
+	}
+	if withCA {
+		secret.Data[core.TLSCertKey] = cert.EncodeCertPEM(crt)
+		secret.Data[certManagerCAKey] = cert.EncodeCertPEM(caCert)
+	} else {
+		secret.Data[core.TLSCertKey] = encodeCertsPEM([]*x509.Certificate{crt, caCert})
+	}
+	return secret
+}
+
+func readyCertificate(name string, ready bool) *unstructured.Unstructured {
+	status := string(core.ConditionFalse)
+	if ready {
+		status = string(core.ConditionTrue)
+	}
+	crt := &unstructured.Unstructured{Object: map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Issuing", "status": "True"},
+				map[string]interface{}{"type": "Ready", "status": status},
+			},
+		},
+	}}
+	crt.SetName(name)
+	return crt
+}
+
+func TestIsCertManagerCertificateReady(t *testing.T) {
+	if !isCertManagerCertificateReady(readyCertificate("crt", true)) {
+		t.Errorf("expected a Certificate with Ready=True to be ready")
+	}
+	if isCertManagerCertificateReady(readyCertificate("crt", false)) {
+		t.Errorf("expected a Certificate with Ready=False not to be ready")
+	}
+	if isCertManagerCertificateReady(&unstructured.Unstructured{Object: map[string]interface{}{}}) {
+		t.Errorf("expected a Certificate without status not to be ready")
+	}
+}
+
+func TestCreateCertManagerKeyStore(t *testing.T) {
+	elasticsearch := newCertManagerElasticsearch()
+	identity := certManagerIdentity{name: "node", files: nodeFiles}
+
+	for _, withCA := range []bool{true, false} {
+		secret := newIssuedSecret(t, elasticsearch, "es-node-cert", withCA)
+		data := make(map[string][]byte)
+		if err := createCertManagerKeyStore(data, identity, secret, "secret"); err != nil {
+			t.Fatalf("withCA=%v: %v", withCA, err)
+		}
+		for _, name := range []string{nodeKeyStore, nodePKCS12, nodeCert, nodeKey, rootKeyStore, rootCert} {
+			if len(data[name]) == 0 {
+				t.Errorf("withCA=%v: expected %s to be created", withCA, name)
+			}
+		}
+		caCerts, err := cert.ParseCertsPEM(data[rootCert])
+		if err != nil || len(caCerts) != 1 || !caCerts[0].IsCA {
+			t.Errorf("withCA=%v: expected %s to hold the CA, got %v (%v)", withCA, rootCert, caCerts, err)
+		}
+	}
+
+	// a certificate without its CA can't be trusted by the nodes
+	secret := newIssuedSecret(t, elasticsearch, "es-node-cert", true)
+	delete(secret.Data, certManagerCAKey)
+	if err := createCertManagerKeyStore(map[string][]byte{}, identity, secret, "secret"); err == nil {
+		t.Errorf("expected an error without the CA")
+	}
+}
+
+func TestEnsureCertManagerCertificatesPending(t *testing.T) {
+	wait := certManagerCheckInterval
+	certManagerCheckInterval = 0
+	defer func() { certManagerCheckInterval = wait }()
+
+	elasticsearch := newCertManagerElasticsearch()
+	certificates := &fakeCertificates{objects: map[string]*unstructured.Unstructured{}}
+	c := &Controller{
+		Controller: &amc.Controller{
+			Client:        fake.NewSimpleClientset(),
+			DynamicClient: certificates,
+		},
+		Config:   amc.Config{GoverningService: "kubedb"},
+		esQueue:  queue.New("Elasticsearch", 5, 1, func(string) error { return nil }),
+		recorder: record.NewFakeRecorder(10),
+	}
+
+	ready, err := c.ensureCertManagerCertificates(elasticsearch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ready {
+		t.Errorf("expected the certificates not to be ready before cert-manager issued them")
+	}
+	if n := c.esQueue.GetQueue().Len(); n != 1 {
+		t.Errorf("expected Elasticsearch to be requeued, got %d keys in the queue", n)
+	}
+
+	for _, name := range []string{"es-node-cert", "es-sgadmin-cert"} {
+		crt, ok := certificates.objects[name]
+		if !ok {
+			t.Fatalf("expected Certificate %s to be created", name)
+		}
+		secretName, _, _ := unstructured.NestedString(crt.Object, "spec", "secretName")
+		issuer, _, _ := unstructured.NestedString(crt.Object, "spec", "issuerRef", "name")
+		if secretName != name || issuer != "ca-issuer" {
+			t.Errorf("unexpected spec of Certificate %s: %v", name, crt.Object["spec"])
+		}
+	}
+	if _, ok := certificates.objects["es-client-cert"]; ok {
+		t.Errorf("expected no client Certificate without enableSSL")
+	}
+}
+
+func TestUpsertCertManagerKeyStores(t *testing.T) {
+	elasticsearch := newCertManagerElasticsearch()
+	elasticsearch.Spec.CertificateSecret = &core.SecretVolumeSource{SecretName: "es-cert"}
+	c := &Controller{
+		Controller: &amc.Controller{
+			Client:    fake.NewSimpleClientset(),
+			ExtClient: kubedb_fake.NewSimpleClientset(),
+		},
+		Config:   amc.Config{GoverningService: "kubedb"},
+		recorder: record.NewFakeRecorder(10),
+	}
+	// created through the client, as the tracker guesses the resource of added objects from their kind
+	if _, err := c.ExtClient.KubedbV1alpha1().Elasticsearches("demo").Create(elasticsearch); err != nil {
+		t.Fatal(err)
+	}
+	identities := c.certManagerIdentities(elasticsearch)
+	issue := func() map[string]*core.Secret {
+		secrets := make(map[string]*core.Secret)
+		for _, identity := range identities {
+			secrets[identity.name] = newIssuedSecret(t, elasticsearch, certManagerCertificateName(elasticsearch, identity), true)
+		}
+		return secrets
+	}
+
+	// the first certificates are loaded without restarting the nodes
+	secrets := issue()
+	if err := c.upsertCertManagerKeyStores(elasticsearch, identities, secrets); err != nil {
+		t.Fatal(err)
+	}
+	certSecret, err := c.Client.CoreV1().Secrets("demo").Get("es-cert", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certSecret.Annotations[AnnotationCertManagerRevision] != certManagerRevision(identities, secrets) {
+		t.Errorf("expected the revision of the certificates, got %v", certSecret.Annotations)
+	}
+	if renewed := renewedAnnotation(t, c); renewed != "" {
+		t.Errorf("expected no restart for the first certificates, got %q", renewed)
+	}
+
+	skipWithoutPatchSupport(t)
+
+	// renewed certificates restart the nodes
+	secrets = issue()
+	if err := c.upsertCertManagerKeyStores(elasticsearch, identities, secrets); err != nil {
+		t.Fatal(err)
+	}
+	certSecret, err = c.Client.CoreV1().Secrets("demo").Get("es-cert", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certSecret.Annotations[AnnotationCertManagerRevision] != certManagerRevision(identities, secrets) {
+		t.Errorf("expected the revision of the renewed certificates, got %v", certSecret.Annotations)
+	}
+	if renewedAnnotation(t, c) == "" {
+		t.Errorf("expected the nodes to be restarted for renewed certificates")
+	}
+}
+
+func renewedAnnotation(t *testing.T, c *Controller) string {
+	es, err := c.ExtClient.KubedbV1alpha1().Elasticsearches("demo").Get("es", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return es.Annotations[AnnotationCertificatesRenewed]
+}
+
+// skipWithoutPatchSupport skips the rest of a test that patches objects, if the vendored
+// json-iterator can't encode them with the Go version running the tests.
+func skipWithoutPatchSupport(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skipf("patches are not supported by the vendored json-iterator: %v", r)
+		}
+	}()
+	cur := &core.Secret{ObjectMeta: metav1.ObjectMeta{Name: "patch", Namespace: "test"}}
+	mod := cur.DeepCopy()
+	mod.Data = map[string][]byte{"key": []byte("value")}
+	_, _, _ = core_util.PatchSecretObject(fake.NewSimpleClientset(cur), cur, mod)
+}
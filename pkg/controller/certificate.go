@@ -1,143 +1,342 @@
 package controller
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	cryptorand "crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"math/big"
 	"net"
-	"os/exec"
-	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/appscode/go/crypto/rand"
-	"github.com/appscode/go/ioutil"
 	"github.com/pkg/errors"
 	"gomodules.xyz/cert"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
 	"kubedb.dev/elasticsearch/pkg/keytool"
 )
 
 const (
-	certsDir = "/tmp/certs/certs"
-
 	rootKey      = "root-key.pem"
 	rootCert     = "root.pem"
 	rootKeyStore = "root.jks"
 	rootAlias    = "root-ca"
 
-	nodeKey      = "node-key.pem"
-	nodeCert     = "node.pem"
-	nodePKCS12   = "node.pkcs12"
+	nodePKCS12   = "node.p12"
 	nodeKeyStore = "node.jks"
+	nodeCert     = "node.pem"
+	nodeKey      = "node-key.pem"
 	nodeAlias    = "elasticsearch-node"
 
-	sgAdminKey      = "sgadmin-key.pem"
-	sgAdminCert     = "sgadmin.pem"
-	sgAdminPKCS12   = "sgadmin.pkcs12"
-	sgAdminKeyStore = "sgadmin.jks"
-	sgAdminAlias    = "elasticsearch-sgadmin"
+	sgAdminPKCS12     = "sgadmin.p12"
+	sgAdminKeyStore   = "sgadmin.jks"
+	sgAdminCert       = "sgadmin.pem"
+	sgAdminKey        = "sgadmin-key.pem"
+	sgAdminAlias      = "elasticsearch-sgadmin"
+	sgAdminCommonName = "sgadmin"
 
-	clientKey      = "client-key.pem"
-	clientCert     = "client.pem"
-	clientPKCS12   = "client.pkcs12"
+	clientPKCS12   = "client.p12"
 	clientKeyStore = "client.jks"
+	clientCert     = "client.pem"
+	clientKey      = "client-key.pem"
 	clientAlias    = "elasticsearch-client"
 )
 
-func createCaCertificate(certPath string) (*rsa.PrivateKey, *x509.Certificate, string, error) {
+// identityFiles are the keys of the certificate secret an identity is stored under,
+// one for each keystore format.
+type identityFiles struct {
+	keyStore string
+	pkcs12   string
+	cert     string
+	key      string
+	alias    string
+}
+
+var (
+	nodeFiles    = identityFiles{nodeKeyStore, nodePKCS12, nodeCert, nodeKey, nodeAlias}
+	sgAdminFiles = identityFiles{sgAdminKeyStore, sgAdminPKCS12, sgAdminCert, sgAdminKey, sgAdminAlias}
+	clientFiles  = identityFiles{clientKeyStore, clientPKCS12, clientCert, clientKey, clientAlias}
+)
+
+// keyStoreFormat returns the format of the keystores Elasticsearch is configured to use.
+func keyStoreFormat(elasticsearch *api.Elasticsearch) api.ElasticsearchKeyStoreFormat {
+	if elasticsearch.Spec.TLS == nil || elasticsearch.Spec.TLS.KeyStoreFormat == "" {
+		return api.ElasticsearchKeyStoreFormatJKS
+	}
+	return elasticsearch.Spec.TLS.KeyStoreFormat
+}
+
+// forFormat returns the keys of the identity, that Elasticsearch is configured to read
+// for the keystore format.
+func (f identityFiles) forFormat(format api.ElasticsearchKeyStoreFormat) []string {
+	switch format {
+	case api.ElasticsearchKeyStoreFormatPKCS12:
+		return []string{f.pkcs12}
+	case api.ElasticsearchKeyStoreFormatPEM:
+		return []string{f.cert, f.key}
+	default:
+		return []string{f.keyStore}
+	}
+}
+
+// storeKeyStores stores the PKCS#12 keystore of the identity in data, along with its
+// conversions to a JKS keystore and to a PEM encoded certificate chain and private key.
+func storeKeyStores(data map[string][]byte, files identityFiles, pfxData []byte, pass string) error {
+	jksData, err := keytool.PKCS12ToJKS(pfxData, pass, files.alias)
+	if err != nil {
+		return fmt.Errorf("failed to convert %s to %s. Reason: %v", files.pkcs12, files.keyStore, errors.Cause(err))
+	}
+	keyPEM, certPEM, err := keytool.PKCS12ToPEM(pfxData, pass)
+	if err != nil {
+		return fmt.Errorf("failed to convert %s to %s. Reason: %v", files.pkcs12, files.cert, errors.Cause(err))
+	}
+
+	data[files.pkcs12] = pfxData
+	data[files.keyStore] = jksData
+	data[files.cert] = certPEM
+	data[files.key] = keyPEM
+	return nil
+}
+
+func createCaCertificate(data map[string][]byte, elasticsearch *api.Elasticsearch) (crypto.Signer, *x509.Certificate, error) {
 	cfg := cert.Config{
 		CommonName:   "KubeDB Com. Root CA",
-		Organization: []string{"Elasticsearch Operator"},
+		Organization: certificateSubject(elasticsearch, "").Organization,
 	}
 
-	caKey, err := cert.NewPrivateKey()
+	caKey, err := newPrivateKey(elasticsearch)
 	if err != nil {
-		return nil, nil, "", errors.New("failed to generate key for CA certificate")
+		return nil, nil, errors.New("failed to generate key for CA certificate")
 	}
 
 	caCert, err := cert.NewSelfSignedCACert(cfg, caKey)
 	if err != nil {
-		return nil, nil, "", errors.New("failed to generate CA certificate")
+		return nil, nil, errors.New("failed to generate CA certificate")
 	}
 
-	nodeKeyByte := cert.EncodePrivateKeyPEM(caKey)
-	if !ioutil.WriteString(filepath.Join(certPath, rootKey), string(nodeKeyByte)) {
-		return nil, nil, "", errors.New("failed to write key for CA certificate")
+	// kept to re-issue the node certificates from the same CA before they expire
+	data[rootKey], err = cert.MarshalPrivateKeyToPEM(caKey)
+	if err != nil {
+		return nil, nil, errors.New("failed to encode key of CA certificate")
 	}
-	caCertByte := cert.EncodeCertPEM(caCert)
-	if !ioutil.WriteString(filepath.Join(certPath, rootCert), string(caCertByte)) {
-		return nil, nil, "", errors.New("failed to write CA certificate")
+
+	return caKey, caCert, nil
+}
+
+// createTrustStore creates the truststore with the certificate chain of the CA, starting
+// with the issuing CA.
+func createTrustStore(data map[string][]byte, caCerts []*x509.Certificate, pass string) error {
+	root, err := keytool.NewTrustStore(caCerts, pass, rootAlias)
+	if err != nil {
+		return fmt.Errorf("failed to generate %s. Reason: %v", rootKeyStore, errors.Cause(err))
 	}
+	data[rootKeyStore] = root
+	return nil
+}
 
-	pass := rand.Characters(6)
+// getIssuingCA returns the key and the certificate chain of the CA in spec.tls.caSecret.
+// It returns nil, if no CA is provided.
+func (c *Controller) getIssuingCA(elasticsearch *api.Elasticsearch) (crypto.Signer, []*x509.Certificate, error) {
+	if elasticsearch.Spec.TLS == nil || elasticsearch.Spec.TLS.CASecret == nil {
+		return nil, nil, nil
+	}
 
-	err = keytool.PEMToJKS(filepath.Join(certPath, rootCert), filepath.Join(certPath, rootKeyStore), pass, rootAlias)
+	secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(elasticsearch.Spec.TLS.CASecret.Name, metav1.GetOptions{})
 	if err != nil {
-		return nil, nil, "", fmt.Errorf("failed to convert %s to %s. Reason: %v", rootCert, rootKeyStore, errors.Cause(err))
+		return nil, nil, err
 	}
+	caCerts, err := cert.ParseCertsPEM(secret.Data[core.TLSCertKey])
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to parse %s of secret %s/%s", core.TLSCertKey, secret.Namespace, secret.Name)
+	}
+	key, err := cert.ParsePrivateKeyPEM(secret.Data[core.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to parse %s of secret %s/%s", core.TLSPrivateKeyKey, secret.Namespace, secret.Name)
+	}
+	caKey, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s of secret %s/%s is not a RSA or ECDSA private key", core.TLSPrivateKeyKey, secret.Namespace, secret.Name)
+	}
+	if !caCerts[0].IsCA {
+		return nil, nil, fmt.Errorf("%s of secret %s/%s is not a CA certificate", core.TLSCertKey, secret.Namespace, secret.Name)
+	}
+	return caKey, caCerts, nil
+}
 
-	return caKey, caCert, pass, nil
+// encodeCertsPEM returns the PEM encoded certificate chain.
+func encodeCertsPEM(certs []*x509.Certificate) []byte {
+	var buf []byte
+	for _, crt := range certs {
+		buf = append(buf, cert.EncodeCertPEM(crt)...)
+	}
+	return buf
 }
 
-func createNodeCertificate(certPath string, elasticsearch *api.Elasticsearch, caKey *rsa.PrivateKey, caCert *x509.Certificate, pass string) error {
-	cfg := cert.Config{
-		CommonName:   elasticsearch.OffshootName(),
+// newPrivateKey generates a private key with the algorithm and size in spec.tls.
+func newPrivateKey(elasticsearch *api.Elasticsearch) (crypto.Signer, error) {
+	algorithm, size := api.ElasticsearchKeyAlgorithmRSA, 0
+	if tls := elasticsearch.Spec.TLS; tls != nil {
+		if tls.KeyAlgorithm != "" {
+			algorithm = tls.KeyAlgorithm
+		}
+		size = tls.KeySize
+	}
+
+	switch algorithm {
+	case api.ElasticsearchKeyAlgorithmECDSA:
+		curve := elliptic.P256()
+		if size == 384 {
+			curve = elliptic.P384()
+		}
+		return ecdsa.GenerateKey(curve, cryptorand.Reader)
+	default:
+		if size == 0 {
+			size = defaultRSAKeySize
+		}
+		return rsa.GenerateKey(cryptorand.Reader, size)
+	}
+}
+
+// certificateSubject returns the subject of a certificate with the fields in spec.tls.subject.
+func certificateSubject(elasticsearch *api.Elasticsearch, commonName string) pkix.Name {
+	name := pkix.Name{
+		CommonName:   commonName,
 		Organization: []string{"Elasticsearch Operator"},
+	}
+	if tls := elasticsearch.Spec.TLS; tls != nil && tls.Subject != nil {
+		if len(tls.Subject.Organizations) > 0 {
+			name.Organization = tls.Subject.Organizations
+		}
+		name.OrganizationalUnit = tls.Subject.OrganizationalUnits
+		name.Country = tls.Subject.Countries
+		name.Province = tls.Subject.Provinces
+		name.Locality = tls.Subject.Localities
+	}
+	return name
+}
+
+// certificateDuration returns how long the issued certificates are valid.
+func certificateDuration(elasticsearch *api.Elasticsearch) time.Duration {
+	if tls := elasticsearch.Spec.TLS; tls != nil && tls.Duration != nil {
+		return tls.Duration.Duration
+	}
+	return duration365d
+}
+
+// nodeDN and sgAdminDN are the distinguished names Search Guard identifies the nodes and
+// the sgadmin client by.
+func nodeDN(elasticsearch *api.Elasticsearch) string {
+	return certificateSubject(elasticsearch, elasticsearch.OffshootName()).String()
+}
+
+func sgAdminDN(elasticsearch *api.Elasticsearch) string {
+	return certificateSubject(elasticsearch, sgAdminCommonName).String()
+}
+
+func createNodeCertificate(data map[string][]byte, elasticsearch *api.Elasticsearch, dnsNames []string, caKey crypto.Signer, caCerts []*x509.Certificate, pass string) error {
+	cfg := certConfig{
+		Subject: certificateSubject(elasticsearch, elasticsearch.OffshootName()),
+		AltNames: cert.AltNames{
+			DNSNames: dnsNames,
+			IPs: []net.IP{
+				net.ParseIP("127.0.0.1"),
+			},
+		},
 		Usages: []x509.ExtKeyUsage{
 			x509.ExtKeyUsageServerAuth,
 			x509.ExtKeyUsageClientAuth,
 		},
+		Duration: certificateDuration(elasticsearch),
 	}
 
-	nodePrivateKey, err := cert.NewPrivateKey()
+	nodePrivateKey, err := newPrivateKey(elasticsearch)
 	if err != nil {
 		return errors.New("failed to generate key for node certificate")
 	}
-	nodeCertificate, err := NewSignedCert(cfg, nodePrivateKey, caCert, caKey)
+	nodeCertificate, err := NewSignedCert(cfg, nodePrivateKey, caCerts[0], caKey)
 	if err != nil {
 		return errors.New("failed to sign node certificate")
 	}
 
-	nodeKeyByte := cert.EncodePrivateKeyPEM(nodePrivateKey)
-	if !ioutil.WriteString(filepath.Join(certPath, nodeKey), string(nodeKeyByte)) {
-		return errors.New("failed to write key for node certificate")
-	}
-	nodeCertByte := cert.EncodeCertPEM(nodeCertificate)
-	if !ioutil.WriteString(filepath.Join(certPath, nodeCert), string(nodeCertByte)) {
-		return errors.New("failed to write node certificate")
-	}
-
-	_, err = exec.Command(
-		"openssl",
-		"pkcs12",
-		"-export",
-		"-certfile", filepath.Join(certPath, rootCert),
-		"-inkey", filepath.Join(certPath, nodeKey),
-		"-in", filepath.Join(certPath, nodeCert),
-		"-password", fmt.Sprintf("pass:%s", pass),
-		"-out", filepath.Join(certPath, nodePKCS12),
-	).Output()
+	pfxData, err := keytool.NewPKCS12(nodePrivateKey, nodeCertificate, caCerts, pass)
 	if err != nil {
-		return errors.New(fmt.Sprintf("failed to generate %s. Reason: %s", nodePKCS12, err.Error()))
+		return fmt.Errorf("failed to generate %s. Reason: %v", nodePKCS12, errors.Cause(err))
 	}
+	return storeKeyStores(data, nodeFiles, pfxData, pass)
+}
 
-	err = keytool.PKCS12ToJKS(filepath.Join(certPath, nodePKCS12), filepath.Join(certPath, nodeKeyStore), pass, nodeAlias)
-	if err != nil {
-		return fmt.Errorf("failed to convert %s to %s. Reason: %v", nodePKCS12, nodeKeyStore, errors.Cause(err))
+var (
+	clusterDomainOnce sync.Once
+	clusterDomainName string
+)
+
+// clusterDomain returns the DNS domain of the cluster, taken from the search path of the
+// operator pod, eg, "svc.cluster.local". It defaults to cluster.local.
+func clusterDomain() string {
+	clusterDomainOnce.Do(func() {
+		clusterDomainName = "cluster.local"
+		data, err := ioutil.ReadFile("/etc/resolv.conf")
+		if err != nil {
+			return
+		}
+		if domain := parseClusterDomain(string(data)); domain != "" {
+			clusterDomainName = domain
+		}
+	})
+	return clusterDomainName
+}
+
+func parseClusterDomain(resolvConf string) string {
+	for _, line := range strings.Split(resolvConf, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "search" {
+			continue
+		}
+		for _, domain := range fields[1:] {
+			if strings.HasPrefix(domain, "svc.") {
+				return strings.TrimPrefix(domain, "svc.")
+			}
+		}
 	}
+	return ""
+}
 
-	return nil
+// nodeDNSNames returns the DNS names the node certificate is issued for: the stable name of
+// every pod under the governing service, the master discovery service and the client service.
+// Pod names are also listed with the cluster domain, as transport connections are verified
+// against the name the peer address resolves to.
+func (c *Controller) nodeDNSNames(elasticsearch *api.Elasticsearch) []string {
+	names := []string{
+		"localhost",
+		elasticsearch.ServiceName(),
+		fmt.Sprintf("%v.%v.svc", elasticsearch.ServiceName(), elasticsearch.Namespace),
+		elasticsearch.MasterServiceName(),
+		fmt.Sprintf("%v.%v.svc", elasticsearch.MasterServiceName(), elasticsearch.Namespace),
+	}
+
+	for _, pool := range nodePools(elasticsearch) {
+		statefulSetName := nodeStatefulSetName(elasticsearch, pool.Name)
+		for i := int32(0); i < nodePoolReplicas(pool); i++ {
+			name := fmt.Sprintf("%v-%v.%v.%v.svc", statefulSetName, i, c.GoverningService, elasticsearch.Namespace)
+			names = append(names, name, fmt.Sprintf("%v.%v", name, clusterDomain()))
+		}
+	}
+	return names
 }
 
-func createAdminCertificate(certPath string, caKey *rsa.PrivateKey, caCert *x509.Certificate, pass string) error {
-	cfg := cert.Config{
-		CommonName:   "sgadmin",
-		Organization: []string{"Elasticsearch Operator"},
+func createAdminCertificate(data map[string][]byte, elasticsearch *api.Elasticsearch, caKey crypto.Signer, caCerts []*x509.Certificate, pass string) error {
+	cfg := certConfig{
+		Subject: certificateSubject(elasticsearch, sgAdminCommonName),
 		AltNames: cert.AltNames{
 			DNSNames: []string{
 				"localhost",
@@ -147,142 +346,125 @@ func createAdminCertificate(certPath string, caKey *rsa.PrivateKey, caCert *x509
 			x509.ExtKeyUsageServerAuth,
 			x509.ExtKeyUsageClientAuth,
 		},
+		Duration: certificateDuration(elasticsearch),
 	}
 
-	sgAdminPrivateKey, err := cert.NewPrivateKey()
+	sgAdminPrivateKey, err := newPrivateKey(elasticsearch)
 	if err != nil {
 		return errors.New("failed to generate key for sgadmin certificate")
 	}
-	sgAdminCertificate, err := cert.NewSignedCert(cfg, sgAdminPrivateKey, caCert, caKey)
+	sgAdminCertificate, err := NewSignedCert(cfg, sgAdminPrivateKey, caCerts[0], caKey)
 	if err != nil {
 		return errors.New("failed to sign sgadmin certificate")
 	}
 
-	sgAdminKeyByte := cert.EncodePrivateKeyPEM(sgAdminPrivateKey)
-	if !ioutil.WriteString(filepath.Join(certPath, sgAdminKey), string(sgAdminKeyByte)) {
-		return errors.New("failed to write key for sgadmin certificate")
-	}
-	sgAdminCertByte := cert.EncodeCertPEM(sgAdminCertificate)
-	if !ioutil.WriteString(filepath.Join(certPath, sgAdminCert), string(sgAdminCertByte)) {
-		return errors.New("failed to write sgadmin certificate")
-	}
-
-	_, err = exec.Command(
-		"openssl",
-		"pkcs12",
-		"-export",
-		"-certfile", filepath.Join(certPath, rootCert),
-		"-inkey", filepath.Join(certPath, sgAdminKey),
-		"-in", filepath.Join(certPath, sgAdminCert),
-		"-password", fmt.Sprintf("pass:%s", pass),
-		"-out", filepath.Join(certPath, sgAdminPKCS12),
-	).Output()
+	pfxData, err := keytool.NewPKCS12(sgAdminPrivateKey, sgAdminCertificate, caCerts, pass)
 	if err != nil {
-		return errors.New("failed to generate " + sgAdminKeyStore)
+		return fmt.Errorf("failed to generate %s. Reason: %v", sgAdminPKCS12, errors.Cause(err))
 	}
+	return storeKeyStores(data, sgAdminFiles, pfxData, pass)
+}
 
-	err = keytool.PKCS12ToJKS(filepath.Join(certPath, sgAdminPKCS12), filepath.Join(certPath, sgAdminKeyStore), pass, sgAdminAlias)
-	if err != nil {
-		return fmt.Errorf("failed to convert %s to %s. Reason: %v", sgAdminPKCS12, sgAdminKeyStore, errors.Cause(err))
+// clientDNSNames returns the DNS names of the client service the client certificate is issued for.
+func clientDNSNames(elasticsearch *api.Elasticsearch) []string {
+	return []string{
+		"localhost",
+		elasticsearch.ServiceName(),
+		fmt.Sprintf("%v.%v", elasticsearch.ServiceName(), elasticsearch.Namespace),
+		fmt.Sprintf("%v.%v.svc", elasticsearch.ServiceName(), elasticsearch.Namespace),
+		fmt.Sprintf("%v.%v.svc.cluster.local", elasticsearch.ServiceName(), elasticsearch.Namespace),
 	}
-
-	return nil
 }
 
-func createClientCertificate(certPath string, elasticsearch *api.Elasticsearch, caKey *rsa.PrivateKey, caCert *x509.Certificate, pass string) error {
-	cfg := cert.Config{
-		CommonName:   elasticsearch.OffshootName(),
-		Organization: []string{"Elasticsearch Operator"},
+func createClientCertificate(data map[string][]byte, elasticsearch *api.Elasticsearch, caKey crypto.Signer, caCerts []*x509.Certificate, pass string) error {
+	cfg := certConfig{
+		Subject: certificateSubject(elasticsearch, elasticsearch.OffshootName()),
 		AltNames: cert.AltNames{
-			DNSNames: []string{
-				"localhost",
-				fmt.Sprintf("%v.%v.svc", elasticsearch.OffshootName(), elasticsearch.Namespace),
+			DNSNames: clientDNSNames(elasticsearch),
+			// operator connects through a port-forward tunnel when running outside the cluster
+			IPs: []net.IP{
+				net.ParseIP("127.0.0.1"),
 			},
 		},
 		Usages: []x509.ExtKeyUsage{
 			x509.ExtKeyUsageServerAuth,
 			x509.ExtKeyUsageClientAuth,
 		},
+		Duration: certificateDuration(elasticsearch),
 	}
 
-	clientPrivateKey, err := cert.NewPrivateKey()
+	clientPrivateKey, err := newPrivateKey(elasticsearch)
 	if err != nil {
 		return errors.New("failed to generate key for client certificate")
 	}
 
-	clientCertificate, err := cert.NewSignedCert(cfg, clientPrivateKey, caCert, caKey)
+	clientCertificate, err := NewSignedCert(cfg, clientPrivateKey, caCerts[0], caKey)
 	if err != nil {
 		return errors.New("failed to sign client certificate")
 	}
 
-	clientKeyByte := cert.EncodePrivateKeyPEM(clientPrivateKey)
-	if !ioutil.WriteString(filepath.Join(certPath, clientKey), string(clientKeyByte)) {
-		return errors.New("failed to write key for client certificate")
-	}
-
-	clientCertByte := cert.EncodeCertPEM(clientCertificate)
-	if !ioutil.WriteString(filepath.Join(certPath, clientCert), string(clientCertByte)) {
-		return errors.New("failed to write client certificate")
-	}
-
-	_, err = exec.Command(
-		"openssl",
-		"pkcs12",
-		"-export",
-		"-certfile", filepath.Join(certPath, rootCert),
-		"-inkey", filepath.Join(certPath, clientKey),
-		"-in", filepath.Join(certPath, clientCert),
-		"-password", fmt.Sprintf("pass:%s", pass),
-		"-out", filepath.Join(certPath, clientPKCS12),
-	).Output()
+	pfxData, err := keytool.NewPKCS12(clientPrivateKey, clientCertificate, caCerts, pass)
 	if err != nil {
-		return errors.New("failed to generate client.pkcs12")
+		return fmt.Errorf("failed to generate %s. Reason: %v", clientPKCS12, errors.Cause(err))
 	}
-
-	err = keytool.PKCS12ToJKS(filepath.Join(certPath, clientPKCS12), filepath.Join(certPath, clientKeyStore), pass, clientAlias)
-	if err != nil {
-		return fmt.Errorf("failed to convert %s to %s: Reason: %v", clientPKCS12, clientKeyStore, errors.Cause(err))
-	}
-
-	return nil
+	return storeKeyStores(data, clientFiles, pfxData, pass)
 }
 
 const (
-	duration365d = time.Hour * 24 * 365
+	duration365d      = time.Hour * 24 * 365
+	defaultRSAKeySize = 2048
 )
 
+// certConfig is the configuration of a certificate issued by the operator.
+type certConfig struct {
+	Subject  pkix.Name
+	AltNames cert.AltNames
+	Usages   []x509.ExtKeyUsage
+	Duration time.Duration
+}
+
 // NewSignedCert creates a signed certificate using the given CA certificate and key
-func NewSignedCert(cfg cert.Config, key *rsa.PrivateKey, caCert *x509.Certificate, caKey *rsa.PrivateKey) (*x509.Certificate, error) {
+func NewSignedCert(cfg certConfig, key crypto.Signer, caCert *x509.Certificate, caKey crypto.Signer) (*x509.Certificate, error) {
 	serial, err := cryptorand.Int(cryptorand.Reader, new(big.Int).SetInt64(math.MaxInt64))
 	if err != nil {
 		return nil, err
 	}
-	if len(cfg.CommonName) == 0 {
+	if len(cfg.Subject.CommonName) == 0 {
 		return nil, errors.New("must specify a CommonName")
 	}
 	if len(cfg.Usages) == 0 {
 		return nil, errors.New("must specify at least one ExtKeyUsage")
 	}
 
+	// ECDSA keys can only be used for signatures, RSA keys also encipher the session keys
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := key.Public().(*rsa.PublicKey); ok {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
 	certTmpl := x509.Certificate{
-		Subject: pkix.Name{
-			CommonName:   cfg.CommonName,
-			Organization: cfg.Organization,
-		},
+		Subject:      cfg.Subject,
 		DNSNames:     cfg.AltNames.DNSNames,
 		IPAddresses:  cfg.AltNames.IPs,
 		SerialNumber: serial,
 		NotBefore:    caCert.NotBefore,
-		NotAfter:     time.Now().Add(duration365d).UTC(),
-		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		NotAfter:     time.Now().Add(cfg.Duration).UTC(),
+		KeyUsage:     keyUsage,
 		ExtKeyUsage:  cfg.Usages,
-		ExtraExtensions: []pkix.Extension{
+	}
+	// client certificates of users are identified by their subject only
+	if len(cfg.AltNames.DNSNames) > 0 || len(cfg.AltNames.IPs) > 0 {
+		san, err := marshalSANs(cfg.AltNames.DNSNames, nil, cfg.AltNames.IPs)
+		if err != nil {
+			return nil, err
+		}
+		certTmpl.ExtraExtensions = []pkix.Extension{
 			{
-				Id: oidExtensionSubjectAltName,
+				Id:    oidExtensionSubjectAltName,
+				Value: san,
 			},
-		},
+		}
 	}
-	certTmpl.ExtraExtensions[0].Value, err = marshalSANs(cfg.AltNames.DNSNames, nil, cfg.AltNames.IPs)
 
 	certDERBytes, err := x509.CreateCertificate(cryptorand.Reader, &certTmpl, caCert, key.Public(), caKey)
 	if err != nil {
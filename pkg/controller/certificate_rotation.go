@@ -0,0 +1,405 @@
+package controller
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/appscode/go/log"
+	"github.com/pkg/errors"
+	"gomodules.xyz/cert"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	core_util "kmodules.xyz/client-go/core/v1"
+	"kubedb.dev/apimachinery/apis"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	"kubedb.dev/apimachinery/pkg/eventer"
+	validator "kubedb.dev/elasticsearch/pkg/admission"
+	"kubedb.dev/elasticsearch/pkg/keytool"
+)
+
+const (
+	// AnnotationCertificatesRenewed is set to the time the certificates were last re-issued.
+	// It is copied into the pod template, so the nodes are restarted with the new certificates.
+	AnnotationCertificatesRenewed = "elasticsearch.kubedb.com/certificates-renewed"
+
+	certificateCheckInterval = time.Hour
+
+	// eventReasonCertificateExpiring is the reason of the events about certificates that expire soon
+	eventReasonCertificateExpiring = "CertificateExpiring"
+)
+
+// certificateKeyStores maps the keystores of the certificate secret to the alias of their entry.
+var certificateKeyStores = map[string]string{
+	rootKeyStore:    rootAlias,
+	nodeKeyStore:    nodeAlias,
+	sgAdminKeyStore: sgAdminAlias,
+	clientKeyStore:  clientAlias,
+}
+
+// runCertificateManager periodically publishes the expiry of the certificates of every
+// Elasticsearch in its status, and re-issues them, and the client certificates, before they expire.
+func (c *Controller) runCertificateManager(stopCh <-chan struct{}) {
+	go wait.Until(c.checkCertificates, certificateCheckInterval, stopCh)
+}
+
+func (c *Controller) checkCertificates() {
+	elasticsearches, err := c.esLister.List(labels.Everything())
+	if err != nil {
+		log.Errorln("failed to list Elasticsearch.", err)
+		return
+	}
+	for _, elasticsearch := range elasticsearches {
+		if elasticsearch.DeletionTimestamp != nil ||
+			elasticsearch.Status.Phase != api.DatabasePhaseRunning ||
+			elasticsearch.Spec.CertificateSecret == nil {
+			continue
+		}
+		if err := c.ensureCertificates(elasticsearch.DeepCopy()); err != nil {
+			c.recorder.Eventf(
+				elasticsearch,
+				core.EventTypeWarning,
+				eventer.EventReasonFailedToUpdate,
+				"Failed to check certificates. Reason: %v",
+				err,
+			)
+			log.Errorf("failed to check certificates of Elasticsearch %s/%s. Reason: %v", elasticsearch.Namespace, elasticsearch.Name, err)
+		}
+		if err := c.ensureClientCertificates(elasticsearch.DeepCopy()); err != nil {
+			c.recorder.Eventf(
+				elasticsearch,
+				core.EventTypeWarning,
+				eventer.EventReasonFailedToUpdate,
+				"Failed to ensure client certificates. Reason: %v",
+				err,
+			)
+			log.Errorf("failed to ensure client certificates of Elasticsearch %s/%s. Reason: %v", elasticsearch.Namespace, elasticsearch.Name, err)
+		}
+	}
+}
+
+// ensureCertificates updates status.certificates and warns about certificates that expire
+// within spec.tls.renewBefore. If the secret holds the key of the CA, the node, sgadmin and
+// client certificates are re-issued from the same CA and the nodes are restarted one at a
+// time, so the cluster stays available. The CA itself is never re-issued. Certificates
+// issued by cert-manager are renewed by cert-manager.
+func (c *Controller) ensureCertificates(elasticsearch *api.Elasticsearch) error {
+	if usesCertManager(elasticsearch) {
+		// pick up the certificates renewed by cert-manager
+		if _, err := c.ensureCertManagerCertificates(elasticsearch); err != nil {
+			return err
+		}
+	}
+
+	secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(elasticsearch.Spec.CertificateSecret.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	pass := string(secret.Data["key_pass"])
+
+	var certificates []api.ElasticsearchCertificateStatus
+	for name, alias := range certificateKeyStores {
+		data, ok := secret.Data[name]
+		if !ok {
+			continue
+		}
+		crt, err := keytool.JKSCertificate(data, pass, alias)
+		if err != nil {
+			return fmt.Errorf("failed to read certificate from %s. Reason: %v", name, err)
+		}
+		certificates = append(certificates, api.ElasticsearchCertificateStatus{
+			Name:       name,
+			CommonName: crt.Subject.CommonName,
+			NotAfter:   metav1.NewTime(crt.NotAfter),
+		})
+	}
+	sort.Slice(certificates, func(i, j int) bool {
+		return certificates[i].Name < certificates[j].Name
+	})
+
+	renewable := usesCertManager(elasticsearch) || canIssueCertificates(elasticsearch, secret)
+	if _, err := util.UpdateElasticsearchStatus(c.ExtClient.KubedbV1alpha1(), elasticsearch, func(in *api.ElasticsearchStatus) *api.ElasticsearchStatus {
+		in.Certificates = certificates
+		in.Conditions = setCondition(in.Conditions, certificatesRenewableCondition(renewable, secret))
+		return in
+	}, apis.EnableStatusSubresource); err != nil {
+		return err
+	}
+	if cond := getCondition(elasticsearch.Status.Conditions, api.ElasticsearchConditionCertificatesRenewable); !renewable &&
+		(cond == nil || cond.Status != core.ConditionFalse) {
+		c.recorder.Eventf(
+			elasticsearch,
+			core.EventTypeWarning,
+			eventer.EventReasonFailedToUpdate,
+			"Certificates can't be renewed, as secret %s has no %s. Replace them before they expire",
+			secret.Name,
+			rootKey,
+		)
+	}
+
+	renewBefore := certificateRenewBefore(elasticsearch)
+	renew := false
+	for _, crt := range certificates {
+		if time.Until(crt.NotAfter.Time) > renewBefore {
+			continue
+		}
+		c.recorder.Eventf(
+			elasticsearch,
+			core.EventTypeWarning,
+			eventReasonCertificateExpiring,
+			"Certificate %s expires at %s",
+			crt.Name,
+			crt.NotAfter.UTC().Format(time.RFC3339),
+		)
+		if crt.Name != rootKeyStore {
+			renew = true
+		}
+	}
+	if !renew || !renewable || usesCertManager(elasticsearch) {
+		return nil
+	}
+	return c.renewCertificates(elasticsearch, secret, pass)
+}
+
+// certificatesRenewableCondition reports whether the operator can renew the certificates in
+// secret. Secrets created before the key of the CA was kept in them can't be renewed.
+func certificatesRenewableCondition(renewable bool, secret *core.Secret) api.ElasticsearchCondition {
+	if renewable {
+		return newCondition(api.ElasticsearchConditionCertificatesRenewable, true, "CAKeyAvailable", "")
+	}
+	return newCondition(
+		api.ElasticsearchConditionCertificatesRenewable,
+		false,
+		"MissingCAKey",
+		fmt.Sprintf("secret %s has no %s, so the certificates must be replaced before they expire", secret.Name, rootKey),
+	)
+}
+
+// renewCertificates re-issues the node, sgadmin and client keystores from the CA in secret,
+// and restarts the nodes.
+func (c *Controller) renewCertificates(elasticsearch *api.Elasticsearch, secret *core.Secret, pass string) error {
+	if err := c.reissueCertificates(elasticsearch, secret, pass); err != nil {
+		return err
+	}
+
+	return c.restartForRenewedCertificates(elasticsearch)
+}
+
+// restartForRenewedCertificates rolls out a new revision of the node StatefulSets, so the
+// nodes load the renewed certificates.
+func (c *Controller) restartForRenewedCertificates(elasticsearch *api.Elasticsearch) error {
+	if _, _, err := util.PatchElasticsearch(c.ExtClient.KubedbV1alpha1(), elasticsearch, func(in *api.Elasticsearch) *api.Elasticsearch {
+		if in.Annotations == nil {
+			in.Annotations = make(map[string]string)
+		}
+		in.Annotations[AnnotationCertificatesRenewed] = time.Now().UTC().Format(time.RFC3339)
+		return in
+	}); err != nil {
+		return err
+	}
+
+	msg := "Successfully renewed certificates. Nodes are restarted one at a time"
+	if elasticsearch.Spec.UpdateStrategy.Type != apps.RollingUpdateStatefulSetStrategyType {
+		msg = "Successfully renewed certificates. Restart the nodes to load them"
+	}
+	c.recorder.Event(elasticsearch, core.EventTypeNormal, eventer.EventReasonSuccessful, msg)
+	return nil
+}
+
+// ensureNodeCertificateSANs re-issues the node certificate, if it does not cover the DNS names
+// of all pods, eg, after replicas were increased. Running nodes are not restarted, as their
+// certificate already covers their own name. They load the new one on their next restart.
+// The sgadmin and client certificates are kept, as they don't depend on the topology.
+func (c *Controller) ensureNodeCertificateSANs(elasticsearch *api.Elasticsearch) error {
+	secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(elasticsearch.Spec.CertificateSecret.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !canIssueCertificates(elasticsearch, secret) {
+		return nil
+	}
+	covered, err := c.nodeCertificateCoversPods(elasticsearch, secret)
+	if err != nil || covered {
+		return err
+	}
+
+	data, err := c.issueNodeCertificate(elasticsearch, secret)
+	if err != nil {
+		return err
+	}
+	if err := patchCertificateSecret(c.Client, secret, data); err != nil {
+		return err
+	}
+	c.recorder.Event(elasticsearch, core.EventTypeNormal, eventer.EventReasonSuccessful, "Successfully re-issued node certificate for new DNS names")
+	return nil
+}
+
+// issueNodeCertificate returns the node keystores issued by the CA of the certificates in secret.
+func (c *Controller) issueNodeCertificate(elasticsearch *api.Elasticsearch, secret *core.Secret) (map[string][]byte, error) {
+	pass := string(secret.Data["key_pass"])
+	caKey, caCerts, err := c.certificateAuthority(elasticsearch, secret, pass)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string][]byte)
+	if err := createNodeCertificate(data, elasticsearch, c.nodeDNSNames(elasticsearch), caKey, caCerts, pass); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// nodeCertificateCoversPods reports whether the node certificate in secret is issued for the
+// DNS names of all pods, so the nodes can verify the hostnames of their transport peers.
+func (c *Controller) nodeCertificateCoversPods(elasticsearch *api.Elasticsearch, secret *core.Secret) (bool, error) {
+	data, ok := secret.Data[nodeKeyStore]
+	if !ok {
+		return false, nil
+	}
+	crt, err := keytool.JKSCertificate(data, string(secret.Data["key_pass"]), nodeAlias)
+	if err != nil {
+		return false, fmt.Errorf("failed to read certificate from %s. Reason: %v", nodeKeyStore, err)
+	}
+	return sets.NewString(crt.DNSNames...).HasAll(c.nodeDNSNames(elasticsearch)...), nil
+}
+
+// ensureClientCertificateSANs re-issues the client certificate, if it does not cover the names
+// the operator connects to, eg, one issued by an older version of the operator, that only
+// covered localhost and the service DNS name. The nodes serve it on the HTTP port and only load
+// it on start, so they are restarted.
+func (c *Controller) ensureClientCertificateSANs(elasticsearch *api.Elasticsearch) error {
+	secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(elasticsearch.Spec.CertificateSecret.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !canIssueCertificates(elasticsearch, secret) {
+		return nil
+	}
+	if _, ok := secret.Data[clientKeyStore]; !ok {
+		return nil
+	}
+	covered, err := clientCertificateCoversService(elasticsearch, secret)
+	if err != nil || covered {
+		return err
+	}
+
+	pass := string(secret.Data["key_pass"])
+	caKey, caCerts, err := c.certificateAuthority(elasticsearch, secret, pass)
+	if err != nil {
+		return err
+	}
+	data := make(map[string][]byte)
+	if err := createClientCertificate(data, elasticsearch, caKey, caCerts, pass); err != nil {
+		return err
+	}
+	if err := patchCertificateSecret(c.Client, secret, data); err != nil {
+		return err
+	}
+	return c.restartForRenewedCertificates(elasticsearch)
+}
+
+// clientCertificateCoversService reports whether the client certificate in secret is issued for
+// the client service and the address of the port-forward tunnel, so the operator can verify the
+// hostname of the server certificate.
+func clientCertificateCoversService(elasticsearch *api.Elasticsearch, secret *core.Secret) (bool, error) {
+	crt, err := keytool.JKSCertificate(secret.Data[clientKeyStore], string(secret.Data["key_pass"]), clientAlias)
+	if err != nil {
+		return false, fmt.Errorf("failed to read certificate from %s. Reason: %v", clientKeyStore, err)
+	}
+	return sets.NewString(crt.DNSNames...).HasAll(clientDNSNames(elasticsearch)...) && crt.VerifyHostname("127.0.0.1") == nil, nil
+}
+
+// transportHostnameVerification reports whether the nodes verify the hostnames of their transport
+// peers. It is only enabled when the node certificate covers all pods, as the image defaults to
+// not verifying them, eg, for certificate secrets provided by users.
+func (c *Controller) transportHostnameVerification(elasticsearch *api.Elasticsearch) (bool, error) {
+	if elasticsearch.Spec.CertificateSecret == nil {
+		return false, nil
+	}
+	secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(elasticsearch.Spec.CertificateSecret.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	return c.nodeCertificateCoversPods(elasticsearch, secret)
+}
+
+// reissueCertificates replaces the node, sgadmin and client keystores in secret with ones
+// issued by the CA in secret.
+func (c *Controller) reissueCertificates(elasticsearch *api.Elasticsearch, secret *core.Secret, pass string) error {
+	caKey, caCerts, err := c.certificateAuthority(elasticsearch, secret, pass)
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string][]byte)
+	if err := createNodeCertificate(data, elasticsearch, c.nodeDNSNames(elasticsearch), caKey, caCerts, pass); err != nil {
+		return err
+	}
+	if err := createAdminCertificate(data, elasticsearch, caKey, caCerts, pass); err != nil {
+		return err
+	}
+	if _, ok := secret.Data[clientKeyStore]; ok {
+		if err := createClientCertificate(data, elasticsearch, caKey, caCerts, pass); err != nil {
+			return err
+		}
+	}
+	return patchCertificateSecret(c.Client, secret, data)
+}
+
+func patchCertificateSecret(client kubernetes.Interface, secret *core.Secret, data map[string][]byte) error {
+	_, _, err := core_util.PatchSecret(client, secret, func(in *core.Secret) *core.Secret {
+		for k, v := range data {
+			in.Data[k] = v
+		}
+		return in
+	})
+	return err
+}
+
+// certificateAuthority returns the key and the certificate chain of the CA that issued the
+// certificates in secret, either from spec.tls.caSecret or from the self-signed CA in secret.
+func (c *Controller) certificateAuthority(elasticsearch *api.Elasticsearch, secret *core.Secret, pass string) (crypto.Signer, []*x509.Certificate, error) {
+	caKey, caCerts, err := c.getIssuingCA(elasticsearch)
+	if err != nil || caKey != nil {
+		return caKey, caCerts, err
+	}
+
+	key, err := cert.ParsePrivateKeyPEM(secret.Data[rootKey])
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to parse %s", rootKey)
+	}
+	caKey, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not a RSA or ECDSA private key", rootKey)
+	}
+	caCert, err := keytool.JKSCertificate(secret.Data[rootKeyStore], pass, rootAlias)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to read CA certificate from %s", rootKeyStore)
+	}
+	return caKey, []*x509.Certificate{caCert}, nil
+}
+
+// certificateRenewBefore returns how long before their expiry the certificates are renewed.
+func certificateRenewBefore(elasticsearch *api.Elasticsearch) time.Duration {
+	if elasticsearch.Spec.TLS != nil && elasticsearch.Spec.TLS.RenewBefore != nil {
+		return elasticsearch.Spec.TLS.RenewBefore.Duration
+	}
+	return validator.DefaultCertificateRenewBefore
+}
+
+// canIssueCertificates reports whether the operator has the key of the CA of the certificates
+// in secret, either from spec.tls.caSecret or from the self-signed CA it created.
+func canIssueCertificates(elasticsearch *api.Elasticsearch, secret *core.Secret) bool {
+	if elasticsearch.Spec.TLS != nil && elasticsearch.Spec.TLS.CASecret != nil {
+		return true
+	}
+	_, ok := secret.Data[rootKey]
+	return ok
+}
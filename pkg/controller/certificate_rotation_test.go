@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/appscode/go/types"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	amc "kubedb.dev/apimachinery/pkg/controller"
+)
+
+func TestCertificatesRenewableCondition(t *testing.T) {
+	withCAKey := &core.Secret{Data: map[string][]byte{rootKey: []byte("key"), rootKeyStore: []byte("ca")}}
+	withoutCAKey := &core.Secret{Data: map[string][]byte{rootKeyStore: []byte("ca")}}
+	withCASecret := &api.Elasticsearch{
+		Spec: api.ElasticsearchSpec{
+			TLS: &api.ElasticsearchTLSConfig{CASecret: &core.LocalObjectReference{Name: "ca"}},
+		},
+	}
+
+	cases := []struct {
+		name          string
+		elasticsearch *api.Elasticsearch
+		secret        *core.Secret
+		renewable     bool
+	}{
+		{"self-signed CA with key", &api.Elasticsearch{}, withCAKey, true},
+		{"self-signed CA without key", &api.Elasticsearch{}, withoutCAKey, false},
+		{"CA secret", withCASecret, withoutCAKey, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			renewable := canIssueCertificates(c.elasticsearch, c.secret)
+			if renewable != c.renewable {
+				t.Errorf("expected renewable to be %v, got %v", c.renewable, renewable)
+			}
+			cond := certificatesRenewableCondition(renewable, c.secret)
+			if (cond.Status == core.ConditionTrue) != c.renewable {
+				t.Errorf("unexpected condition %+v", cond)
+			}
+			if !c.renewable && cond.Reason != "MissingCAKey" {
+				t.Errorf("expected reason MissingCAKey, got %q", cond.Reason)
+			}
+		})
+	}
+}
+
+func TestEnsureNodeCertificateSANs(t *testing.T) {
+	elasticsearch := &api.Elasticsearch{
+		ObjectMeta: metav1.ObjectMeta{Name: "es", Namespace: "demo"},
+		Spec: api.ElasticsearchSpec{
+			Replicas:          types.Int32P(1),
+			EnableSSL:         true,
+			CertificateSecret: &core.SecretVolumeSource{SecretName: "es-cert"},
+		},
+	}
+	c := &Controller{
+		Controller: &amc.Controller{},
+		Config:     amc.Config{GoverningService: "kubedb"},
+		recorder:   record.NewFakeRecorder(10),
+	}
+
+	pass := "secret"
+	data := map[string][]byte{"key_pass": []byte(pass)}
+	caKey, caCert, err := createCaCertificate(data, elasticsearch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCerts := []*x509.Certificate{caCert}
+	if err := createTrustStore(data, caCerts, pass); err != nil {
+		t.Fatal(err)
+	}
+	if err := createNodeCertificate(data, elasticsearch, c.nodeDNSNames(elasticsearch), caKey, caCerts, pass); err != nil {
+		t.Fatal(err)
+	}
+	if err := createAdminCertificate(data, elasticsearch, caKey, caCerts, pass); err != nil {
+		t.Fatal(err)
+	}
+	if err := createClientCertificate(data, elasticsearch, caKey, caCerts, pass); err != nil {
+		t.Fatal(err)
+	}
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "es-cert", Namespace: "demo"},
+		Data:       data,
+	}
+	c.Client = fake.NewSimpleClientset(secret)
+
+	if verify, err := c.transportHostnameVerification(elasticsearch); err != nil || !verify {
+		t.Fatalf("expected hostname verification for a certificate covering all pods, got %v (%v)", verify, err)
+	}
+	if covered, err := clientCertificateCoversService(elasticsearch, secret); err != nil || !covered {
+		t.Fatalf("expected the client certificate to cover the client service, got %v (%v)", covered, err)
+	}
+	renamed := elasticsearch.DeepCopy()
+	renamed.Name = "other"
+	if covered, err := clientCertificateCoversService(renamed, secret); err != nil || covered {
+		t.Fatalf("expected the client certificate not to cover the service of another Elasticsearch, got %v (%v)", covered, err)
+	}
+
+	// scale up
+	elasticsearch.Spec.Replicas = types.Int32P(3)
+	if verify, err := c.transportHostnameVerification(elasticsearch); err != nil || verify {
+		t.Fatalf("expected no hostname verification before the certificate is re-issued, got %v (%v)", verify, err)
+	}
+	issued, err := c.issueNodeCertificate(elasticsearch, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the node keystores are re-issued, the sgadmin and client certificates are kept
+	for name := range issued {
+		if name != nodeFiles.keyStore && name != nodeFiles.pkcs12 && name != nodeFiles.cert && name != nodeFiles.key {
+			t.Errorf("expected only the node keystores to be re-issued, got %s", name)
+		}
+	}
+	if len(issued) != 4 {
+		t.Errorf("expected the 4 node keystores to be re-issued, got %d", len(issued))
+	}
+
+	for k, v := range issued {
+		secret.Data[k] = v
+	}
+	if _, err := c.Client.CoreV1().Secrets("demo").Update(secret); err != nil {
+		t.Fatal(err)
+	}
+	if verify, err := c.transportHostnameVerification(elasticsearch); err != nil || !verify {
+		t.Fatalf("expected hostname verification after the certificate is re-issued, got %v (%v)", verify, err)
+	}
+}
+
+func TestParseClusterDomain(t *testing.T) {
+	resolvConf := "nameserver 10.96.0.10\nsearch demo.svc.example.org svc.example.org example.org\noptions ndots:5\n"
+	if domain := parseClusterDomain(resolvConf); domain != "example.org" {
+		t.Errorf("expected example.org, got %q", domain)
+	}
+	if domain := parseClusterDomain("nameserver 8.8.8.8\n"); domain != "" {
+		t.Errorf("expected no domain outside of a cluster, got %q", domain)
+	}
+}
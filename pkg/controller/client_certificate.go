@@ -0,0 +1,277 @@
+package controller
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gomodules.xyz/cert"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/reference"
+	core_util "kmodules.xyz/client-go/core/v1"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/pkg/eventer"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// LabelClientCertificate is set on the secret, and the cert-manager Certificate, of a client
+	// certificate in spec.tls.clientCertificates to its name.
+	LabelClientCertificate = "elasticsearch.kubedb.com/client-certificate"
+
+	clientCertAuthDomain = "clientcert_auth_domain"
+)
+
+func clientCertificates(elasticsearch *api.Elasticsearch) []api.ElasticsearchClientCertificate {
+	if elasticsearch.Spec.TLS == nil {
+		return nil
+	}
+	return elasticsearch.Spec.TLS.ClientCertificates
+}
+
+// clientCertificateIdentity is the cert-manager Certificate of a client certificate. Its secret
+// is named <database name>-<name>-client-cert, same as the secret of a self-issued one.
+func clientCertificateIdentity(name string) certManagerIdentity {
+	return certManagerIdentity{
+		name:       fmt.Sprintf("%v-client", name),
+		commonName: name,
+		usages:     []string{"client auth"},
+		labels: map[string]string{
+			LabelClientCertificate: name,
+		},
+	}
+}
+
+func clientCertificateSecretName(elasticsearch *api.Elasticsearch, name string) string {
+	return certManagerCertificateName(elasticsearch, clientCertificateIdentity(name))
+}
+
+// clientCertificateDN is the distinguished name Search Guard identifies a client certificate by.
+func clientCertificateDN(elasticsearch *api.Elasticsearch, name string) string {
+	return certificateSubject(elasticsearch, name).String()
+}
+
+// ensureClientCertificates issues a certificate for every entry of spec.tls.clientCertificates,
+// and stores it in its own secret with tls.crt, tls.key and ca.crt. ensureSecurityConfig maps
+// its distinguished name to the roles of the entry. Certificates removed from the spec are
+// unmapped and their secrets deleted, so an application loses its roles as soon as its entry is
+// removed. The certificate itself stays valid until it expires, as there is no revocation list.
+// Self-issued certificates are renewed within spec.tls.renewBefore of their expiry, or when the
+// CA changes. Applications have to reload them from the secret.
+func (c *Controller) ensureClientCertificates(elasticsearch *api.Elasticsearch) error {
+	if elasticsearch.Spec.CertificateSecret == nil || elasticsearch.Spec.DatabaseSecret == nil {
+		return nil
+	}
+
+	if usesCertManager(elasticsearch) {
+		for _, cc := range clientCertificates(elasticsearch) {
+			identity := clientCertificateIdentity(cc.Name)
+			if _, err := c.ensureCertManagerCertificate(elasticsearch, identity); err != nil {
+				return errors.Wrapf(err, "failed to ensure cert-manager Certificate %s", certManagerCertificateName(elasticsearch, identity))
+			}
+		}
+	} else if err := c.issueClientCertificates(elasticsearch); err != nil {
+		return err
+	}
+
+	return c.deleteRemovedClientCertificates(elasticsearch)
+}
+
+func (c *Controller) issueClientCertificates(elasticsearch *api.Elasticsearch) error {
+	if len(clientCertificates(elasticsearch)) == 0 {
+		return nil
+	}
+
+	certSecret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(elasticsearch.Spec.CertificateSecret.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !canIssueCertificates(elasticsearch, certSecret) {
+		return fmt.Errorf("client certificates can't be issued without the key of the CA in secret %s", certSecret.Name)
+	}
+	caKey, caCerts, err := c.certificateAuthority(elasticsearch, certSecret, string(certSecret.Data["key_pass"]))
+	if err != nil {
+		return err
+	}
+	caPEM := encodeCertsPEM(caCerts)
+
+	ref, err := reference.GetReference(clientsetscheme.Scheme, elasticsearch)
+	if err != nil {
+		return err
+	}
+	renewBefore := certificateRenewBefore(elasticsearch)
+
+	for _, cc := range clientCertificates(elasticsearch) {
+		name := clientCertificateSecretName(elasticsearch, cc.Name)
+		secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(name, metav1.GetOptions{})
+		if err != nil && !kerr.IsNotFound(err) {
+			return err
+		}
+		if err == nil && !clientCertificateNeedsRenewal(secret, caPEM, renewBefore) {
+			continue
+		}
+
+		data, err := createUserClientCertificate(elasticsearch, cc.Name, caKey, caCerts)
+		if err != nil {
+			return err
+		}
+		meta := metav1.ObjectMeta{
+			Name:      name,
+			Namespace: elasticsearch.Namespace,
+		}
+		if _, _, err := core_util.CreateOrPatchSecret(c.Client, meta, func(in *core.Secret) *core.Secret {
+			in.Labels = core_util.UpsertMap(in.Labels, elasticsearch.OffshootLabels())
+			in.Labels[LabelClientCertificate] = cc.Name
+			core_util.EnsureOwnerReference(&in.ObjectMeta, ref)
+			in.Type = core.SecretTypeTLS
+			in.Data = data
+			return in
+		}); err != nil {
+			return err
+		}
+		c.recorder.Eventf(
+			elasticsearch,
+			core.EventTypeNormal,
+			eventer.EventReasonSuccessful,
+			"Successfully issued client certificate %s in secret %s",
+			cc.Name,
+			name,
+		)
+	}
+	return nil
+}
+
+// clientCertificateNeedsRenewal reports whether the certificate in secret expires within
+// renewBefore, or was not issued by the current CA.
+func clientCertificateNeedsRenewal(secret *core.Secret, caPEM []byte, renewBefore time.Duration) bool {
+	if string(secret.Data[certManagerCAKey]) != string(caPEM) {
+		return true
+	}
+	crts, err := cert.ParseCertsPEM(secret.Data[core.TLSCertKey])
+	if err != nil {
+		return true
+	}
+	return time.Until(crts[0].NotAfter) <= renewBefore
+}
+
+func createUserClientCertificate(elasticsearch *api.Elasticsearch, name string, caKey crypto.Signer, caCerts []*x509.Certificate) (map[string][]byte, error) {
+	cfg := certConfig{
+		Subject: certificateSubject(elasticsearch, name),
+		Usages: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageClientAuth,
+		},
+		Duration: certificateDuration(elasticsearch),
+	}
+
+	privateKey, err := newPrivateKey(elasticsearch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key for client certificate %s", name)
+	}
+	crt, err := NewSignedCert(cfg, privateKey, caCerts[0], caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign client certificate %s", name)
+	}
+	// PKCS#8, same as the keys issued by cert-manager
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode key of client certificate %s", name)
+	}
+
+	return map[string][]byte{
+		core.TLSCertKey:       append(cert.EncodeCertPEM(crt), encodeCertsPEM(caCerts[1:])...),
+		core.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		certManagerCAKey:      encodeCertsPEM(caCerts),
+	}, nil
+}
+
+// deleteRemovedClientCertificates deletes the secrets, and cert-manager Certificates, of client
+// certificates that are no longer in spec.tls.clientCertificates.
+func (c *Controller) deleteRemovedClientCertificates(elasticsearch *api.Elasticsearch) error {
+	wanted := sets.NewString()
+	for _, cc := range clientCertificates(elasticsearch) {
+		wanted.Insert(cc.Name)
+	}
+	selector := labels.SelectorFromSet(elasticsearch.OffshootSelectors())
+	req, err := labels.NewRequirement(LabelClientCertificate, selection.Exists, nil)
+	if err != nil {
+		return err
+	}
+	selector = selector.Add(*req)
+
+	removed := sets.NewString()
+	crts, err := c.DynamicClient.Resource(certManagerCertificates).Namespace(elasticsearch.Namespace).List(metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		for _, crt := range crts.Items {
+			if name := crt.GetLabels()[LabelClientCertificate]; !wanted.Has(name) {
+				err := c.DynamicClient.Resource(certManagerCertificates).Namespace(elasticsearch.Namespace).Delete(crt.GetName(), &metav1.DeleteOptions{})
+				if err != nil && !kerr.IsNotFound(err) {
+					return err
+				}
+				removed.Insert(name)
+			}
+		}
+	}
+
+	secrets, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).List(metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets.Items {
+		if name := secret.Labels[LabelClientCertificate]; !wanted.Has(name) {
+			removed.Insert(name)
+		}
+	}
+	// secrets issued by cert-manager are not labeled
+	for _, name := range removed.List() {
+		err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Delete(clientCertificateSecretName(elasticsearch, name), &metav1.DeleteOptions{})
+		if err != nil && !kerr.IsNotFound(err) {
+			return err
+		}
+		c.recorder.Eventf(
+			elasticsearch,
+			core.EventTypeNormal,
+			eventer.EventReasonSuccessful,
+			"Removed the roles of client certificate %s and deleted its secret. It can still authenticate until it expires",
+			name,
+		)
+	}
+	return nil
+}
+
+// enableClientCertAuth adds the client certificate authentication domain to config.yml of the
+// security plugin, if the database secret was created before client certificates were supported.
+func enableClientCertAuth(data []byte, root string) ([]byte, error) {
+	if strings.Contains(string(data), clientCertAuthDomain) {
+		return data, nil
+	}
+
+	var configData map[string]interface{}
+	if err := yaml.Unmarshal(data, &configData); err != nil {
+		return nil, err
+	}
+	var domain map[string]interface{}
+	if err := yaml.Unmarshal([]byte(clientCertAuthConfig), &domain); err != nil {
+		return nil, err
+	}
+
+	authc := configSection(configData, root, "authc")
+	authc[clientCertAuthDomain] = domain
+	return yaml.Marshal(configData)
+}
@@ -1,6 +1,8 @@
 package controller
 
 import (
+	"sync"
+
 	"github.com/appscode/go/encoding/json/types"
 	"github.com/appscode/go/log"
 	pcm "github.com/coreos/prometheus-operator/pkg/client/versioned/typed/monitoring/v1"
@@ -51,6 +53,17 @@ type Controller struct {
 	esQueue    *queue.Worker
 	esInformer cache.SharedIndexInformer
 	esLister   api_listers.ElasticsearchLister
+
+	// Snapshots taken through the _snapshot API of Elasticsearch
+	nativeSnapQueue *queue.Worker
+
+	// readinessWait holds the time the operator started waiting for each Elasticsearch cluster to become ready
+	readinessWait sync.Map
+	// rollingRestarts holds the Elasticsearch clusters with a rolling restart in progress, and
+	// whether the operator limited shard allocation to primaries for the restarted node
+	rollingRestarts sync.Map
+	// forceMerges holds the time a force merge was last started for each index on warm nodes
+	forceMerges sync.Map
 }
 
 var _ amc.Snapshotter = &Controller{}
@@ -93,7 +106,7 @@ func New(
 func (c *Controller) EnsureCustomResourceDefinitions() error {
 	log.Infoln("Ensuring CustomResourceDefinition...")
 	crds := []*crd_api.CustomResourceDefinition{
-		api.Elasticsearch{}.CustomResourceDefinition(),
+		elasticsearchCRD(),
 		catalog.ElasticsearchVersion{}.CustomResourceDefinition(),
 		api.DormantDatabase{}.CustomResourceDefinition(),
 		api.Snapshot{}.CustomResourceDefinition(),
@@ -102,11 +115,80 @@ func (c *Controller) EnsureCustomResourceDefinitions() error {
 	return apiext_util.RegisterCRDs(c.ApiExtKubeClient, crds)
 }
 
+// elasticsearchCRD adds cluster health, node and shard columns to the Elasticsearch CRD.
+func elasticsearchCRD() *crd_api.CustomResourceDefinition {
+	crd := api.Elasticsearch{}.CustomResourceDefinition()
+	columns := []crd_api.CustomResourceColumnDefinition{
+		{
+			Name:     "Health",
+			Type:     "string",
+			JSONPath: ".status.health",
+		},
+		{
+			Name:     "Master",
+			Type:     "integer",
+			JSONPath: ".status.nodes.master",
+		},
+		{
+			Name:     "Data",
+			Type:     "integer",
+			JSONPath: ".status.nodes.data",
+		},
+		{
+			Name:     "Client",
+			Type:     "integer",
+			JSONPath: ".status.nodes.client",
+		},
+		{
+			Name:     "Warm",
+			Type:     "integer",
+			JSONPath: ".status.nodes.warm",
+			Priority: 1,
+		},
+		{
+			Name:     "Ingest",
+			Type:     "integer",
+			JSONPath: ".status.nodes.ingest",
+			Priority: 1,
+		},
+		{
+			Name:     "Active-Shards",
+			Type:     "integer",
+			JSONPath: ".status.shards.active",
+			Priority: 1,
+		},
+		{
+			Name:     "Relocating-Shards",
+			Type:     "integer",
+			JSONPath: ".status.shards.relocating",
+			Priority: 1,
+		},
+		{
+			Name:     "Unassigned-Shards",
+			Type:     "integer",
+			JSONPath: ".status.shards.unassigned",
+		},
+	}
+	// keep Age as the last column
+	n := len(crd.Spec.AdditionalPrinterColumns)
+	if n == 0 {
+		crd.Spec.AdditionalPrinterColumns = columns
+		return crd
+	}
+	crd.Spec.AdditionalPrinterColumns = append(
+		append(crd.Spec.AdditionalPrinterColumns[:n-1:n-1], columns...),
+		crd.Spec.AdditionalPrinterColumns[n-1],
+	)
+	return crd
+}
+
 // InitInformer initializes Elasticsearch, DormantDB amd Snapshot watcher
 func (c *Controller) Init() error {
 	c.initWatcher()
+	c.initSecretWatcher()
 	c.DrmnQueue = drmnc.NewController(c.Controller, c, c.Config, nil, c.recorder).AddEventHandlerFunc(c.selector)
-	c.SnapQueue, c.JobQueue = snapc.NewController(c.Controller, c, c.Config, nil, c.recorder).AddEventHandlerFunc(c.selector)
+	c.SnapQueue, c.JobQueue = snapc.NewController(c.Controller, c, c.Config, nil, c.recorder).AddEventHandlerFunc(c.dumpSnapshotSelector())
+	c.initNativeSnapshotWatcher()
 	c.RSQueue = restoresession.NewController(c.Controller, c, c.Config, nil, c.recorder).AddEventHandlerFunc(c.selector)
 
 	return nil
@@ -122,6 +204,15 @@ func (c *Controller) RunControllers(stopCh <-chan struct{}) {
 	c.DrmnQueue.Run(stopCh)
 	c.SnapQueue.Run(stopCh)
 	c.JobQueue.Run(stopCh)
+	c.nativeSnapQueue.Run(stopCh)
+
+	// Refresh cluster health in status
+	c.runHealthChecker(stopCh)
+
+	// Move, merge and delete indices according to spec.lifecyclePolicies
+	c.runLifecycleManager(stopCh)
+	c.runCertificateManager(stopCh)
+	c.runLicenseChecker(stopCh)
 }
 
 // Blocks caller. Intended to be called as a Go routine.
@@ -14,35 +14,83 @@ import (
 	"kubedb.dev/elasticsearch/pkg/util/es"
 )
 
-func (c *Controller) getAllIndices(elasticsearch *api.Elasticsearch) (string, error) {
-	var url string
+// tunneledClient closes the port-forward tunnel along with the client.
+type tunneledClient struct {
+	es.ESClient
+	tunnel *portforward.Tunnel
+}
+
+func (c *tunneledClient) Stop() {
+	c.ESClient.Stop()
+	c.tunnel.Close()
+}
+
+func clientPodName(elasticsearch *api.Elasticsearch) string {
+	clientName := nodeStatefulSetName(elasticsearch, coordinatingNodePool(elasticsearch).Name)
+	return fmt.Sprintf("%v-0", clientName)
+}
+
+// getElasticClient returns an ESClient for the database. When the operator runs outside
+// the cluster, the client connects through a tunnel to the first client pod, which is
+// closed when the client is stopped.
+func (c *Controller) getElasticClient(elasticsearch *api.Elasticsearch) (es.ESClient, error) {
+	tlsOpts, err := c.getTLSOptions(elasticsearch)
+	if err != nil {
+		return nil, err
+	}
+	return c.connectElasticClient(elasticsearch, func(url string) (es.ESClient, error) {
+		return es.GetElasticClient(c.Client, c.ExtClient, elasticsearch, url, tlsOpts)
+	})
+}
+
+// getElasticClientWithAuth is getElasticClient with the given credentials, instead of the
+// admin credentials of the database secret.
+func (c *Controller) getElasticClientWithAuth(elasticsearch *api.Elasticsearch, username, password string) (es.ESClient, error) {
+	tlsOpts, err := c.getTLSOptions(elasticsearch)
+	if err != nil {
+		return nil, err
+	}
+	return c.connectElasticClient(elasticsearch, func(url string) (es.ESClient, error) {
+		return es.NewElasticClient(c.ExtClient, elasticsearch, url, tlsOpts, username, password)
+	})
+}
+
+// getTLSOptions returns how the server certificate of the database is verified.
+func (c *Controller) getTLSOptions(elasticsearch *api.Elasticsearch) (es.TLSOptions, error) {
+	insecureSkipVerify := elasticsearch.Spec.TLS != nil && elasticsearch.Spec.TLS.InsecureSkipVerify
+	return es.NewTLSOptions(c.Client, elasticsearch, insecureSkipVerify)
+}
+
+func (c *Controller) connectElasticClient(elasticsearch *api.Elasticsearch, connect func(url string) (es.ESClient, error)) (es.ESClient, error) {
 	if meta.PossiblyInCluster() {
-		url = elasticsearch.GetConnectionURL()
-	} else {
-		clientName := elasticsearch.OffshootName()
-		if elasticsearch.Spec.Topology != nil {
-			if elasticsearch.Spec.Topology.Client.Prefix != "" {
-				clientName = fmt.Sprintf("%v-%v", elasticsearch.Spec.Topology.Client.Prefix, clientName)
-			}
-		}
-		clientPodName := fmt.Sprintf("%v-0", clientName)
-		tunnel := portforward.NewTunnel(
-			c.Client.CoreV1().RESTClient(),
-			c.ClientConfig,
-			elasticsearch.Namespace,
-			clientPodName,
-			api.ElasticsearchRestPort,
-		)
-		if err := tunnel.ForwardPort(); err != nil {
-			return "", err
-		}
-		url = fmt.Sprintf("%v://127.0.0.1:%d", elasticsearch.GetConnectionScheme(), tunnel.Local)
+		return connect(elasticsearch.GetConnectionURL())
 	}
 
+	tunnel := portforward.NewTunnel(
+		c.Client.CoreV1().RESTClient(),
+		c.ClientConfig,
+		elasticsearch.Namespace,
+		clientPodName(elasticsearch),
+		api.ElasticsearchRestPort,
+	)
+	if err := tunnel.ForwardPort(); err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%v://127.0.0.1:%d", elasticsearch.GetConnectionScheme(), tunnel.Local)
+
+	client, err := connect(url)
+	if err != nil {
+		tunnel.Close()
+		return nil, err
+	}
+	return &tunneledClient{ESClient: client, tunnel: tunnel}, nil
+}
+
+func (c *Controller) getAllIndices(elasticsearch *api.Elasticsearch) (string, error) {
 	var reason error
 	var indices []string
 	err := wait.PollImmediate(time.Second*30, time.Minute*5, func() (bool, error) {
-		client, err := es.GetElasticClient(c.Client, c.ExtClient, elasticsearch, url)
+		client, err := c.getElasticClient(elasticsearch)
 		if err != nil {
 			log.Warningln(err)
 			reason = err
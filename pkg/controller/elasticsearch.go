@@ -2,7 +2,6 @@ package controller
 
 import (
 	"fmt"
-	"time"
 
 	"github.com/appscode/go/encoding/json/types"
 	"github.com/appscode/go/log"
@@ -72,6 +71,11 @@ func (c *Controller) create(elasticsearch *api.Elasticsearch) error {
 		return err
 	}
 
+	// Certificates issued by cert-manager are needed before the nodes can start.
+	if ready, err := c.ensureCertManagerCertificates(elasticsearch); err != nil || !ready {
+		return err
+	}
+
 	// ensure database StatefulSet
 	vt2, err := c.ensureElasticsearchNode(elasticsearch)
 	if err != nil {
@@ -101,12 +105,20 @@ func (c *Controller) create(elasticsearch *api.Elasticsearch) error {
 		return err
 	}
 
+	// Nodes need some time to discover each other and form the cluster.
+	// Wait for it without blocking the worker; the key is requeued until the cluster is ready.
+	if elasticsearch.Status.Phase != api.DatabasePhaseRunning && elasticsearch.Status.Phase != api.DatabasePhaseInitializing {
+		if ready, err := c.ensureClusterReady(elasticsearch); err != nil || !ready {
+			return err
+		}
+	}
+
 	if _, err := meta_util.GetString(elasticsearch.Annotations, api.AnnotationInitialized); err == kutil.ErrNotFound &&
 		elasticsearch.Spec.Init != nil &&
 		(elasticsearch.Spec.Init.SnapshotSource != nil || elasticsearch.Spec.Init.StashRestoreSession != nil) {
 
 		if elasticsearch.Status.Phase == api.DatabasePhaseInitializing {
-			return nil
+			return c.checkNativeRestore(elasticsearch)
 		}
 
 		// add phase that database is being initialized
@@ -134,6 +146,7 @@ func (c *Controller) create(elasticsearch *api.Elasticsearch) error {
 
 	es, err := util.UpdateElasticsearchStatus(c.ExtClient.KubedbV1alpha1(), elasticsearch, func(in *api.ElasticsearchStatus) *api.ElasticsearchStatus {
 		in.Phase = api.DatabasePhaseRunning
+		in.Reason = ""
 		in.ObservedGeneration = types.NewIntHash(elasticsearch.Generation, meta_util.GenerationHash(elasticsearch))
 		return in
 	}, apis.EnableStatusSubresource)
@@ -142,6 +155,58 @@ func (c *Controller) create(elasticsearch *api.Elasticsearch) error {
 	}
 	elasticsearch.Status = es.Status
 
+	// Restart nodes that are not on the latest revision of their StatefulSet
+	if err := c.ensureRollingRestart(elasticsearch); err != nil {
+		c.recorder.Eventf(
+			elasticsearch,
+			core.EventTypeWarning,
+			eventer.EventReasonFailedToUpdate,
+			"Failed to restart nodes. Reason: %v",
+			err,
+		)
+		log.Errorln(err)
+		// Don't return error. Continue processing rest.
+	}
+
+	// Issue client certificates and map them to Search Guard roles
+	if err := c.ensureClientCertificates(elasticsearch); err != nil {
+		c.recorder.Eventf(
+			elasticsearch,
+			core.EventTypeWarning,
+			eventer.EventReasonFailedToUpdate,
+			"Failed to ensure client certificates. Reason: %v",
+			err,
+		)
+		log.Errorln(err)
+		// Don't return error. Continue processing rest.
+	}
+
+	// Render users, roles and role mappings into the config of the security plugin
+	if err := c.ensureSecurityConfig(elasticsearch); err != nil {
+		c.recorder.Eventf(
+			elasticsearch,
+			core.EventTypeWarning,
+			eventer.EventReasonFailedToUpdate,
+			"Failed to update security config. Reason: %v",
+			err,
+		)
+		log.Errorln(err)
+		// Don't return error. Continue processing rest.
+	}
+
+	// Rotate the passwords of the admin and readall users on request
+	if err := c.ensurePasswordRotation(elasticsearch); err != nil {
+		c.recorder.Eventf(
+			elasticsearch,
+			core.EventTypeWarning,
+			eventer.EventReasonFailedToUpdate,
+			"Failed to rotate passwords. Reason: %v",
+			err,
+		)
+		log.Errorln(err)
+		// Don't return error. Continue processing rest.
+	}
+
 	// Ensure Schedule backup
 	if err := c.ensureBackupScheduler(elasticsearch); err != nil {
 		c.recorder.Eventf(
@@ -199,53 +264,23 @@ func (c *Controller) ensureElasticsearchNode(elasticsearch *api.Elasticsearch) (
 		}
 	}
 
-	vt := kutil.VerbUnchanged
-	topology := elasticsearch.Spec.Topology
-	if topology != nil {
-
-		vt1, err := c.ensureMasterNode(elasticsearch)
-		if err != nil {
-			return kutil.VerbUnchanged, err
-		}
-		vt2, err := c.ensureDataNode(elasticsearch)
-		if err != nil {
-			return kutil.VerbUnchanged, err
-		}
-		vt3, err := c.ensureClientNode(elasticsearch)
-		if err != nil {
-			return kutil.VerbUnchanged, err
-		}
-		replicas := topology.Warm.Replicas
-		if replicas != nil {
-			vt4, err := c.ensureWarmNode(elasticsearch)
-			if err != nil {
-				return kutil.VerbUnchanged, err
-			}
-			if vt1 == kutil.VerbCreated && vt2 == kutil.VerbCreated && vt3 == kutil.VerbCreated && vt4 == kutil.VerbCreated {
-				vt = kutil.VerbCreated
-			} else if vt1 == kutil.VerbPatched || vt2 == kutil.VerbPatched || vt3 == kutil.VerbPatched || vt4 == kutil.VerbPatched {
-				vt = kutil.VerbPatched
-			}
-		} else {
-			if vt1 == kutil.VerbCreated && vt2 == kutil.VerbCreated && vt3 == kutil.VerbCreated {
-				vt = kutil.VerbCreated
-			} else if vt1 == kutil.VerbPatched || vt2 == kutil.VerbPatched || vt3 == kutil.VerbPatched {
-				vt = kutil.VerbPatched
-			}
-		}
+	return c.ensureNodePools(elasticsearch)
+}
 
-	} else {
-		vt, err = c.ensureCombinedNode(elasticsearch)
-		if err != nil {
-			return kutil.VerbUnchanged, err
-		}
+// combinedVerb is VerbCreated, if all node StatefulSets were created, or VerbPatched, if any
+// of them was patched.
+func combinedVerb(verbs ...kutil.VerbType) kutil.VerbType {
+	created, patched := true, false
+	for _, v := range verbs {
+		created = created && v == kutil.VerbCreated
+		patched = patched || v == kutil.VerbPatched
 	}
-
-	// Need some time to build elasticsearch cluster. Nodes will communicate with each other
-	// TODO: find better way
-	time.Sleep(time.Second * 30)
-
-	return vt, nil
+	if created {
+		return kutil.VerbCreated
+	} else if patched {
+		return kutil.VerbPatched
+	}
+	return kutil.VerbUnchanged
 }
 
 func (c *Controller) ensureBackupScheduler(elasticsearch *api.Elasticsearch) error {
@@ -293,6 +328,9 @@ func (c *Controller) initializeFromSnapshot(elasticsearch *api.Elasticsearch) er
 	if err != nil {
 		return err
 	}
+	if isNativeSnapshot(snapshot) {
+		return c.restoreNativeSnapshot(elasticsearch, snapshot)
+	}
 
 	secret, err := storage.NewOSMSecret(c.Client, snapshot.OSMSecretName(), snapshot.Namespace, snapshot.Spec.Backend)
 	if err != nil {
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/appscode/go/log"
+	"k8s.io/client-go/tools/cache"
+	"kubedb.dev/apimachinery/apis"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	"kubedb.dev/elasticsearch/pkg/util/es"
+)
+
+const (
+	clusterReadinessCheckInterval = 10 * time.Second
+	clusterReadinessTimeout       = 15 * time.Minute
+	// clusterReadinessRetryInterval is used once the cluster has timed out, so a cluster
+	// that becomes ready later still recovers from the Failed phase.
+	clusterReadinessRetryInterval = 2 * time.Minute
+)
+
+// nodeCount holds the number of nodes per role in an Elasticsearch cluster.
+type nodeCount struct {
+	master int
+	data   int
+	total  int
+}
+
+// expectedNodeCount returns the number of nodes per role the cluster should have according to its spec.
+func expectedNodeCount(elasticsearch *api.Elasticsearch) nodeCount {
+	var count nodeCount
+	for _, pool := range nodePools(elasticsearch) {
+		replicas := int(nodePoolReplicas(pool))
+		if hasRole(pool, api.ElasticsearchNodeRoleMaster) {
+			count.master += replicas
+		}
+		if hasRole(pool, api.ElasticsearchNodeRoleData) {
+			count.data += replicas
+		}
+		count.total += replicas
+	}
+	return count
+}
+
+// observedNodeCount counts the nodes per role that have joined the cluster.
+func observedNodeCount(nodes []es.NodeInfo) nodeCount {
+	var count nodeCount
+	for _, node := range nodes {
+		if node.IsMaster() {
+			count.master++
+		}
+		if node.IsData() {
+			count.data++
+		}
+		count.total++
+	}
+	return count
+}
+
+// checkClusterReady reports whether all nodes of the topology have joined the cluster and
+// it is able to serve requests. If not, the returned string explains what is missing.
+func (c *Controller) checkClusterReady(elasticsearch *api.Elasticsearch) (bool, string) {
+	client, err := c.getElasticClient(elasticsearch)
+	if err != nil {
+		return false, fmt.Sprintf("failed to connect to Elasticsearch. Reason: %v", err)
+	}
+	defer client.Stop()
+
+	health, err := client.GetClusterHealth()
+	if err != nil {
+		return false, fmt.Sprintf("failed to get cluster health. Reason: %v", err)
+	}
+	if health.TimedOut || health.Status == es.HealthStatusRed || health.Status == "" {
+		return false, fmt.Sprintf("cluster health is %q", health.Status)
+	}
+
+	nodes, err := client.GetAllNodesInfo()
+	if err != nil {
+		return false, fmt.Sprintf("failed to get nodes info. Reason: %v", err)
+	}
+	expected := expectedNodeCount(elasticsearch)
+	observed := observedNodeCount(nodes)
+	if observed.master != expected.master {
+		return false, fmt.Sprintf("%d/%d master nodes joined the cluster", observed.master, expected.master)
+	}
+	if observed.data != expected.data {
+		return false, fmt.Sprintf("%d/%d data nodes joined the cluster", observed.data, expected.data)
+	}
+	if observed.total != expected.total {
+		return false, fmt.Sprintf("%d/%d nodes joined the cluster", observed.total, expected.total)
+	}
+	return true, ""
+}
+
+// ensureClusterReady checks the cluster once instead of blocking the worker. If the cluster
+// is not ready yet, the key is requeued and false is returned. If it does not become ready
+// within clusterReadinessTimeout, the database is marked as Failed, and checked again at
+// the slower clusterReadinessRetryInterval.
+func (c *Controller) ensureClusterReady(elasticsearch *api.Elasticsearch) (bool, error) {
+	key, err := cache.MetaNamespaceKeyFunc(elasticsearch)
+	if err != nil {
+		return false, err
+	}
+
+	ready, reason := c.checkClusterReady(elasticsearch)
+	if ready {
+		c.readinessWait.Delete(key)
+		return true, nil
+	}
+
+	started, _ := c.readinessWait.LoadOrStore(key, time.Now())
+	if time.Since(started.(time.Time)) > clusterReadinessTimeout {
+		if elasticsearch.Status.Phase != api.DatabasePhaseFailed {
+			c.pushFailureEvent(elasticsearch, fmt.Sprintf("timed out waiting for cluster to be ready. Reason: %v", reason))
+		}
+		c.esQueue.GetQueue().AddAfter(key, clusterReadinessRetryInterval)
+		return false, nil
+	}
+
+	log.Infof("Elasticsearch %v is not ready yet. Reason: %v", key, reason)
+	db, err := util.UpdateElasticsearchStatus(c.ExtClient.KubedbV1alpha1(), elasticsearch, func(in *api.ElasticsearchStatus) *api.ElasticsearchStatus {
+		in.Reason = reason
+		return in
+	}, apis.EnableStatusSubresource)
+	if err != nil {
+		return false, err
+	}
+	elasticsearch.Status = db.Status
+
+	c.esQueue.GetQueue().AddAfter(key, clusterReadinessCheckInterval)
+	return false, nil
+}
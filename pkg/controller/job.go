@@ -34,11 +34,7 @@ func (c *Controller) createRestoreJob(elasticsearch *api.Elasticsearch, snapshot
 	// Get PersistentVolume object for Backup Util pod.
 	pvcSpec := snapshot.Spec.PodVolumeClaimSpec
 	if pvcSpec == nil {
-		if elasticsearch.Spec.Topology != nil {
-			pvcSpec = elasticsearch.Spec.Topology.Data.Storage
-		} else {
-			pvcSpec = elasticsearch.Spec.Storage
-		}
+		pvcSpec = dataNodePool(elasticsearch).Storage
 	}
 	st := snapshot.Spec.StorageType
 	if st == nil {
@@ -225,11 +221,7 @@ func (c *Controller) GetSnapshotter(snapshot *api.Snapshot) (*batch.Job, error)
 	// Get PersistentVolume object for Backup Util pod.
 	pvcSpec := snapshot.Spec.PodVolumeClaimSpec
 	if pvcSpec == nil {
-		if elasticsearch.Spec.Topology != nil {
-			pvcSpec = elasticsearch.Spec.Topology.Data.Storage
-		} else {
-			pvcSpec = elasticsearch.Spec.Storage
-		}
+		pvcSpec = dataNodePool(elasticsearch).Storage
 	}
 	st := snapshot.Spec.StorageType
 	if st == nil {
@@ -251,6 +243,12 @@ func (c *Controller) GetSnapshotter(snapshot *api.Snapshot) (*batch.Job, error)
 		return nil, err
 	}
 
+	// X-Pack secrets only hold the elastic user, which has the privileges of the readall user
+	userKey, passwordKey := KeyReadAllUserName, KeyReadAllPassword
+	if elasticsearch.Spec.AuthPlugin == api.ElasticsearchAuthPluginXpack {
+		userKey, passwordKey = KeyAdminUserName, KeyAdminPassword
+	}
+
 	job := &batch.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        jobName,
@@ -298,7 +296,7 @@ func (c *Controller) GetSnapshotter(snapshot *api.Snapshot) (*batch.Job, error)
 											LocalObjectReference: core.LocalObjectReference{
 												Name: elasticsearch.Spec.DatabaseSecret.SecretName,
 											},
-											Key: KeyReadAllUserName,
+											Key: userKey,
 										},
 									},
 								},
@@ -309,7 +307,7 @@ func (c *Controller) GetSnapshotter(snapshot *api.Snapshot) (*batch.Job, error)
 											LocalObjectReference: core.LocalObjectReference{
 												Name: elasticsearch.Spec.DatabaseSecret.SecretName,
 											},
-											Key: KeyReadAllPassword,
+											Key: passwordKey,
 										},
 									},
 								},
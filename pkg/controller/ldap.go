@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+)
+
+// Names of the domains in config.yml of the security plugin.
+const (
+	internalAuthDomain = "basic_internal_auth_domain"
+	ldapAuthDomain     = "ldap_auth_domain"
+	ldapRolesDomain    = "ldap_roles"
+)
+
+func ldapSpec(elasticsearch *api.Elasticsearch) *api.ElasticsearchLDAPSpec {
+	if elasticsearch.Spec.Security == nil {
+		return nil
+	}
+	return elasticsearch.Spec.Security.LDAP
+}
+
+// updateLDAPAuthDomain renders spec.security.ldap into an authc domain, that authenticates users
+// by HTTP basic auth against the LDAP server, and an authz domain, that resolves their LDAP roles
+// as backend roles. The LDAP domain goes after the internal users, so the operator and the other
+// internal users don't depend on the LDAP server. Only the last basic auth domain may challenge
+// the client, so the challenge moves to the LDAP domain. Both are removed, along with spec.security.ldap.
+func (c *Controller) updateLDAPAuthDomain(elasticsearch *api.Elasticsearch, data []byte, root string) ([]byte, error) {
+	configData, err := parseSecurityFile(data)
+	if err != nil {
+		return nil, err
+	}
+	authc := configSection(configData, root, "authc")
+	authz := configSection(configData, root, "authz")
+
+	ldap := ldapSpec(elasticsearch)
+	if ldap == nil {
+		if _, ok := authc[ldapAuthDomain]; !ok {
+			return data, nil
+		}
+		delete(authc, ldapAuthDomain)
+		delete(authz, ldapRolesDomain)
+		setChallenge(authc[internalAuthDomain], true)
+		return marshalSecurityFile(data, configData)
+	}
+
+	connection, err := c.ldapConnectionConfig(elasticsearch, ldap)
+	if err != nil {
+		return nil, err
+	}
+
+	authcConfig := ldapUserSearchConfig(ldap)
+	for k, v := range connection {
+		authcConfig[k] = v
+	}
+	authc[ldapAuthDomain] = map[string]interface{}{
+		"enabled": true,
+		"order":   5,
+		"http_authenticator": map[string]interface{}{
+			"type":      "basic",
+			"challenge": true,
+		},
+		"authentication_backend": map[string]interface{}{
+			"type":   "ldap",
+			"config": authcConfig,
+		},
+	}
+	setChallenge(authc[internalAuthDomain], false)
+
+	if ldap.RoleBase == "" {
+		delete(authz, ldapRolesDomain)
+		return marshalSecurityFile(data, configData)
+	}
+	authzConfig := ldapUserSearchConfig(ldap)
+	for k, v := range connection {
+		authzConfig[k] = v
+	}
+	authzConfig["rolebase"] = ldap.RoleBase
+	authzConfig["rolesearch"] = "(member={0})"
+	if ldap.RoleSearch != "" {
+		authzConfig["rolesearch"] = ldap.RoleSearch
+	}
+	authzConfig["rolename"] = "name"
+	if ldap.RoleName != "" {
+		authzConfig["rolename"] = ldap.RoleName
+	}
+	authzConfig["userrolename"] = "disabled"
+	authzConfig["resolve_nested_roles"] = false
+	authz[ldapRolesDomain] = map[string]interface{}{
+		"enabled": true,
+		"authorization_backend": map[string]interface{}{
+			"type":   "ldap",
+			"config": authzConfig,
+		},
+	}
+	return marshalSecurityFile(data, configData)
+}
+
+func setChallenge(domain interface{}, challenge bool) {
+	if d, ok := domain.(map[string]interface{}); ok {
+		if authenticator, ok := d["http_authenticator"].(map[string]interface{}); ok {
+			authenticator["challenge"] = challenge
+		}
+	}
+}
+
+func ldapUserSearchConfig(ldap *api.ElasticsearchLDAPSpec) map[string]interface{} {
+	config := map[string]interface{}{
+		"userbase": ldap.UserBase,
+	}
+	if ldap.UserSearch != "" {
+		config["usersearch"] = ldap.UserSearch
+	}
+	if ldap.UsernameAttribute != "" {
+		config["username_attribute"] = ldap.UsernameAttribute
+	}
+	return config
+}
+
+// ldapConnectionConfig returns the settings to connect and bind to the LDAP server. The bind
+// credentials and the CA are read from their secrets, so changing them updates config.yml.
+func (c *Controller) ldapConnectionConfig(elasticsearch *api.Elasticsearch, ldap *api.ElasticsearchLDAPSpec) (map[string]interface{}, error) {
+	config := map[string]interface{}{
+		"hosts":                  toInterfaceSlice(ldap.Hosts),
+		"enable_ssl":             false,
+		"enable_start_tls":       false,
+		"enable_ssl_client_auth": false,
+		"verify_hostnames":       true,
+	}
+
+	if ldap.BindSecret != nil {
+		secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(ldap.BindSecret.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get bind secret of spec.security.ldap")
+		}
+		bindDN, ok := secret.Data[core.BasicAuthUsernameKey]
+		if !ok {
+			return nil, fmt.Errorf("secret %s has no bind DN in key %s", secret.Name, core.BasicAuthUsernameKey)
+		}
+		password, ok := secret.Data[core.BasicAuthPasswordKey]
+		if !ok {
+			return nil, fmt.Errorf("secret %s has no bind password in key %s", secret.Name, core.BasicAuthPasswordKey)
+		}
+		config["bind_dn"] = string(bindDN)
+		config["password"] = string(password)
+	}
+
+	if tls := ldap.TLS; tls != nil {
+		config["enable_ssl"] = tls.EnableSSL
+		config["enable_start_tls"] = tls.EnableStartTLS
+		config["verify_hostnames"] = !tls.InsecureSkipHostnameVerification
+		if ref := tls.CASecret; ref != nil {
+			secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(ref.Name, metav1.GetOptions{})
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get CA secret of spec.security.ldap")
+			}
+			ca, ok := secret.Data[ref.Key]
+			if !ok {
+				return nil, fmt.Errorf("secret %s has no CA in key %s", secret.Name, ref.Key)
+			}
+			config["pemtrustedcas_content"] = string(ca)
+		}
+	}
+	return config, nil
+}
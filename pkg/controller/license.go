@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/appscode/go/log"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/elasticsearch/pkg/util/es"
+)
+
+const (
+	licenseCheckInterval = time.Hour
+	// licenseExpiryWarning is how long before the expiry of a license the warnings start
+	licenseExpiryWarning = 7 * 24 * time.Hour
+
+	// eventReasonLicenseExpiring is the reason of the events about X-Pack licenses that expire soon
+	eventReasonLicenseExpiring = "LicenseExpiring"
+)
+
+// runLicenseChecker periodically warns about X-Pack clusters, whose license expires soon. The
+// nodes generate a basic license, that doesn't expire, but a trial or paid license installed
+// by users does. Once it expires, the cluster blocks most requests until a license is installed.
+func (c *Controller) runLicenseChecker(stopCh <-chan struct{}) {
+	go wait.Until(c.checkLicenses, licenseCheckInterval, stopCh)
+}
+
+func (c *Controller) checkLicenses() {
+	elasticsearches, err := c.esLister.List(labels.Everything())
+	if err != nil {
+		log.Errorln("failed to list Elasticsearch.", err)
+		return
+	}
+	for _, elasticsearch := range elasticsearches {
+		if elasticsearch.DeletionTimestamp != nil ||
+			elasticsearch.Status.Phase != api.DatabasePhaseRunning ||
+			elasticsearch.Spec.AuthPlugin != api.ElasticsearchAuthPluginXpack {
+			continue
+		}
+		if err := c.checkLicense(elasticsearch); err != nil {
+			log.Errorf("failed to check license of Elasticsearch %s/%s. Reason: %v", elasticsearch.Namespace, elasticsearch.Name, err)
+		}
+	}
+}
+
+func (c *Controller) checkLicense(elasticsearch *api.Elasticsearch) error {
+	client, err := c.getElasticClient(elasticsearch)
+	if err != nil {
+		return err
+	}
+	license, err := client.GetLicense()
+	client.Stop()
+	if err != nil {
+		return err
+	}
+	if message, expiring := licenseWarning(license, time.Now()); expiring {
+		c.recorder.Event(elasticsearch, core.EventTypeWarning, eventReasonLicenseExpiring, message)
+	}
+	return nil
+}
+
+// licenseWarning returns the warning about a license that expired, or expires within
+// licenseExpiryWarning. Licenses without expiry date never expire.
+func licenseWarning(license *es.License, now time.Time) (string, bool) {
+	expiry := license.ExpiryDate()
+	if expiry.IsZero() || expiry.Sub(now) > licenseExpiryWarning {
+		return "", false
+	}
+	if !expiry.After(now) {
+		return fmt.Sprintf("X-Pack %s license expired at %s. Install a license to use the cluster", license.Type, expiry.UTC().Format(time.RFC3339)), true
+	}
+	return fmt.Sprintf("X-Pack %s license expires at %s. Install a license to keep using the cluster", license.Type, expiry.UTC().Format(time.RFC3339)), true
+}
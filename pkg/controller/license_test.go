@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"kubedb.dev/elasticsearch/pkg/util/es"
+)
+
+func TestLicenseWarning(t *testing.T) {
+	now := time.Now()
+	expiringIn := func(d time.Duration) *es.License {
+		return &es.License{Type: "trial", ExpiryDateInMillis: now.Add(d).UnixNano() / int64(time.Millisecond)}
+	}
+
+	cases := []struct {
+		name     string
+		license  *es.License
+		expiring bool
+	}{
+		{"basic", &es.License{Type: "basic"}, false},
+		{"trial, fresh", expiringIn(30 * 24 * time.Hour), false},
+		{"trial, expires in a day", expiringIn(24 * time.Hour), true},
+		{"trial, expired", expiringIn(-time.Hour), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if message, expiring := licenseWarning(c.license, now); expiring != c.expiring {
+				t.Errorf("expected expiring to be %v, got %v (%s)", c.expiring, expiring, message)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appscode/go/log"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/pkg/eventer"
+	"kubedb.dev/elasticsearch/pkg/util/es"
+)
+
+const (
+	lifecycleCheckInterval = 5 * time.Minute
+	// lifecycleConcurrency bounds the number of clusters whose policies are enforced at the same time
+	lifecycleConcurrency = 5
+	// forceMergeRetryInterval is how long a started force merge is given to finish, before
+	// an index that still has too many segments is merged again.
+	forceMergeRetryInterval = time.Hour
+
+	// boxTypeHot and boxTypeWarm are the NODE_TAG of hot and warm data nodes
+	boxTypeHot  = "hot"
+	boxTypeWarm = "warm"
+)
+
+// runLifecycleManager periodically enforces spec.lifecyclePolicies of every Elasticsearch.
+// Policies are applied through index settings, so they also work on versions without ILM.
+func (c *Controller) runLifecycleManager(stopCh <-chan struct{}) {
+	go wait.Until(c.enforceLifecyclePolicies, lifecycleCheckInterval, stopCh)
+}
+
+func (c *Controller) enforceLifecyclePolicies() {
+	elasticsearches, err := c.esLister.List(labels.Everything())
+	if err != nil {
+		log.Errorln("failed to list Elasticsearch.", err)
+		return
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, lifecycleConcurrency)
+	for _, elasticsearch := range elasticsearches {
+		if elasticsearch.DeletionTimestamp != nil ||
+			elasticsearch.Status.Phase != api.DatabasePhaseRunning ||
+			len(elasticsearch.Spec.LifecyclePolicies) == 0 {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(elasticsearch *api.Elasticsearch) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := c.enforceLifecyclePolicy(elasticsearch); err != nil {
+				c.recorder.Eventf(
+					elasticsearch,
+					core.EventTypeWarning,
+					eventer.EventReasonFailedToUpdate,
+					"Failed to enforce lifecycle policies. Reason: %v",
+					err,
+				)
+				log.Errorf("failed to enforce lifecycle policies of Elasticsearch %s/%s. Reason: %v", elasticsearch.Namespace, elasticsearch.Name, err)
+			}
+		}(elasticsearch.DeepCopy())
+	}
+	wg.Wait()
+}
+
+// enforceLifecyclePolicy deletes indices older than deleteAfter. Indices older than warmAfter
+// are required on warm data nodes through their box_type and are force merged once moved.
+// Moving and merging take longer than a check, so they are picked up by the next checks.
+func (c *Controller) enforceLifecyclePolicy(elasticsearch *api.Elasticsearch) error {
+	client, err := c.getElasticClient(elasticsearch)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	hasWarmNodes := hasWarmNodes(elasticsearch)
+	now := time.Now()
+	for _, policy := range elasticsearch.Spec.LifecyclePolicies {
+		indices, err := client.GetIndices(policy.IndexPattern)
+		if err != nil {
+			return err
+		}
+
+		for _, index := range indices {
+			if index.CreationDate.IsZero() {
+				continue
+			}
+			age := now.Sub(index.CreationDate)
+
+			if policy.DeleteAfter != nil && age > policy.DeleteAfter.Duration {
+				if err := client.DeleteIndex(index.Name); err != nil {
+					return err
+				}
+				c.forceMerges.Delete(forceMergeKey(elasticsearch, index.Name))
+				c.recorder.Eventf(elasticsearch, core.EventTypeNormal, eventer.EventReasonSuccessful, "Deleted index %s", index.Name)
+				continue
+			}
+
+			if policy.WarmAfter == nil || !hasWarmNodes || age <= policy.WarmAfter.Duration {
+				continue
+			}
+			if index.BoxType != boxTypeWarm {
+				if err := client.UpdateIndexSettings(index.Name, map[string]interface{}{
+					es.IndexSettingBoxType: boxTypeWarm,
+				}); err != nil {
+					return err
+				}
+				c.recorder.Eventf(elasticsearch, core.EventTypeNormal, eventer.EventReasonSuccessful, "Moving index %s to warm nodes", index.Name)
+				continue
+			}
+			if policy.ForceMergeSegments != nil {
+				if err := c.ensureForceMerged(elasticsearch, client, index.Name, int(*policy.ForceMergeSegments)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func forceMergeKey(elasticsearch *api.Elasticsearch, index string) string {
+	return fmt.Sprintf("%s/%s/%s", elasticsearch.Namespace, elasticsearch.Name, index)
+}
+
+// forgetForceMerges drops the force merges of the indices of the Elasticsearch with the
+// given queue key, once it is deleted.
+func (c *Controller) forgetForceMerges(key string) {
+	c.forceMerges.Range(func(k, _ interface{}) bool {
+		if strings.HasPrefix(k.(string), key+"/") {
+			c.forceMerges.Delete(k)
+		}
+		return true
+	})
+}
+
+// ensureForceMerged merges an index on warm nodes down to maxNumSegments. Merging is deferred
+// until all shards have moved, as relocation copies the segments as they are. A merge that
+// failed, or didn't reduce the segments within forceMergeRetryInterval, is started again.
+func (c *Controller) ensureForceMerged(elasticsearch *api.Elasticsearch, client es.ESClient, index string, maxNumSegments int) error {
+	key := forceMergeKey(elasticsearch, index)
+	if started, ok := c.forceMerges.Load(key); ok && time.Since(started.(time.Time)) < forceMergeRetryInterval {
+		return nil
+	}
+
+	moved, err := client.IsIndexOnBoxType(index, boxTypeWarm)
+	if err != nil || !moved {
+		return err
+	}
+	segments, err := client.GetMaxSegmentCount(index)
+	if err != nil {
+		return err
+	}
+	if segments <= maxNumSegments {
+		c.forceMerges.Delete(key)
+		return nil
+	}
+
+	if err := client.ForceMerge(index, maxNumSegments); err != nil {
+		c.forceMerges.Delete(key)
+		return err
+	}
+	c.forceMerges.Store(key, time.Now())
+	c.recorder.Eventf(elasticsearch, core.EventTypeNormal, eventer.EventReasonSuccessful, "Started force merge of index %s", index)
+	return nil
+}
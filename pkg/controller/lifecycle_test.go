@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/elasticsearch/pkg/util/es"
+)
+
+type fakeMergeClient struct {
+	es.ESClient
+	moved    bool
+	segments int
+	mergeErr error
+	merges   int
+}
+
+func (f *fakeMergeClient) IsIndexOnBoxType(index, boxType string) (bool, error) {
+	return f.moved, nil
+}
+
+func (f *fakeMergeClient) GetMaxSegmentCount(index string) (int, error) {
+	return f.segments, nil
+}
+
+func (f *fakeMergeClient) ForceMerge(index string, maxNumSegments int) error {
+	f.merges++
+	return f.mergeErr
+}
+
+func TestEnsureForceMerged(t *testing.T) {
+	elasticsearch := &api.Elasticsearch{ObjectMeta: metav1.ObjectMeta{Name: "es", Namespace: "demo"}}
+	key := forceMergeKey(elasticsearch, "logs")
+
+	cases := []struct {
+		name       string
+		client     *fakeMergeClient
+		started    *time.Time
+		wantErr    bool
+		wantMerges int
+		wantKept   bool
+	}{
+		{
+			name:   "shards still moving",
+			client: &fakeMergeClient{segments: 10},
+		},
+		{
+			name:   "already merged",
+			client: &fakeMergeClient{moved: true, segments: 1},
+		},
+		{
+			name:       "merge started",
+			client:     &fakeMergeClient{moved: true, segments: 10},
+			wantMerges: 1,
+			wantKept:   true,
+		},
+		{
+			name:       "merge failed",
+			client:     &fakeMergeClient{moved: true, segments: 10, mergeErr: errors.New("timeout")},
+			wantErr:    true,
+			wantMerges: 1,
+		},
+		{
+			name:     "merge in progress",
+			client:   &fakeMergeClient{moved: true, segments: 10},
+			started:  timeP(time.Now().Add(-time.Minute)),
+			wantKept: true,
+		},
+		{
+			name:       "merge didn't finish in time",
+			client:     &fakeMergeClient{moved: true, segments: 10},
+			started:    timeP(time.Now().Add(-2 * forceMergeRetryInterval)),
+			wantMerges: 1,
+			wantKept:   true,
+		},
+		{
+			name:    "merge finished",
+			client:  &fakeMergeClient{moved: true, segments: 1},
+			started: timeP(time.Now().Add(-2 * forceMergeRetryInterval)),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Controller{recorder: record.NewFakeRecorder(10)}
+			if tc.started != nil {
+				c.forceMerges.Store(key, *tc.started)
+			}
+
+			err := c.ensureForceMerged(elasticsearch, tc.client, "logs", 1)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("expected error: %v, got %v", tc.wantErr, err)
+			}
+			if tc.client.merges != tc.wantMerges {
+				t.Errorf("expected %d merges, got %d", tc.wantMerges, tc.client.merges)
+			}
+			if _, kept := c.forceMerges.Load(key); kept != tc.wantKept {
+				t.Errorf("expected the merge to be tracked: %v, got %v", tc.wantKept, kept)
+			}
+		})
+	}
+}
+
+func timeP(t time.Time) *time.Time {
+	return &t
+}
+
+func TestForgetForceMerges(t *testing.T) {
+	deleted := &api.Elasticsearch{ObjectMeta: metav1.ObjectMeta{Name: "es", Namespace: "demo"}}
+	prefixed := &api.Elasticsearch{ObjectMeta: metav1.ObjectMeta{Name: "es-2", Namespace: "demo"}}
+	c := &Controller{}
+	c.forceMerges.Store(forceMergeKey(deleted, "logs"), time.Now())
+	c.forceMerges.Store(forceMergeKey(deleted, "metrics"), time.Now())
+	c.forceMerges.Store(forceMergeKey(prefixed, "logs"), time.Now())
+
+	c.forgetForceMerges("demo/es")
+	var keys []string
+	c.forceMerges.Range(func(k, _ interface{}) bool {
+		keys = append(keys, k.(string))
+		return true
+	})
+	if len(keys) != 1 || keys[0] != forceMergeKey(prefixed, "logs") {
+		t.Errorf("expected only the force merges of demo/es-2 to be kept, got %v", keys)
+	}
+}
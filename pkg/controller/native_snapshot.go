@@ -0,0 +1,451 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"time"
+
+	"github.com/appscode/go/log"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/tools/cache"
+	kutil "kmodules.xyz/client-go"
+	core_util "kmodules.xyz/client-go/core/v1"
+	"kmodules.xyz/client-go/tools/queue"
+	"kubedb.dev/apimachinery/apis"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	"kubedb.dev/apimachinery/pkg/eventer"
+	"kubedb.dev/elasticsearch/pkg/util/es"
+)
+
+const (
+	// LabelSnapshotMode selects how a Snapshot of Elasticsearch is taken. Snapshots
+	// without this label are taken by a Job that dumps every index into the backend.
+	LabelSnapshotMode = "elasticsearch.kubedb.com/snapshot-mode"
+	// SnapshotModeNative takes and restores the Snapshot through the _snapshot API of
+	// Elasticsearch, using a repository built from the backend of the Snapshot.
+	SnapshotModeNative = "native"
+
+	nativeSnapshotCheckInterval = 10 * time.Second
+)
+
+func isNativeSnapshot(snapshot *api.Snapshot) bool {
+	return snapshot.Labels[LabelSnapshotMode] == SnapshotModeNative
+}
+
+// dumpSnapshotSelector selects the Snapshots, Jobs and DormantDatabases handled by the
+// generic snapshot controller. Native Snapshots are left to the native snapshot queue.
+func (c *Controller) dumpSnapshotSelector() labels.Selector {
+	req, err := labels.NewRequirement(LabelSnapshotMode, selection.NotEquals, []string{SnapshotModeNative})
+	if err != nil {
+		panic(err)
+	}
+	return c.selector.Add(*req)
+}
+
+func (c *Controller) nativeSnapshotSelector() labels.Selector {
+	req, err := labels.NewRequirement(LabelSnapshotMode, selection.Equals, []string{SnapshotModeNative})
+	if err != nil {
+		panic(err)
+	}
+	return c.selector.Add(*req)
+}
+
+func (c *Controller) initNativeSnapshotWatcher() {
+	c.nativeSnapQueue = queue.New("NativeSnapshot", c.MaxNumRequeues, c.NumThreads, c.runNativeSnapshot)
+	c.SnapInformer.AddEventHandler(queue.NewFilteredHandler(queue.NewEventHandler(c.nativeSnapQueue.GetQueue(), func(old interface{}, new interface{}) bool {
+		snapshot := new.(*api.Snapshot)
+		return snapshot.DeletionTimestamp != nil
+	}), c.nativeSnapshotSelector()))
+}
+
+func (c *Controller) runNativeSnapshot(key string) error {
+	log.Debugf("started processing, key: %v", key)
+	obj, exists, err := c.SnapInformer.GetIndexer().GetByKey(key)
+	if err != nil {
+		log.Errorf("Fetching object with key %s from store failed with %v", key, err)
+		return err
+	}
+
+	if !exists {
+		log.Debugf("Snapshot %s does not exist anymore", key)
+	} else {
+		snapshot := obj.(*api.Snapshot).DeepCopy()
+		if snapshot.DeletionTimestamp != nil {
+			if core_util.HasFinalizer(snapshot.ObjectMeta, api.GenericKey) {
+				if err := c.deleteNativeSnapshot(snapshot); kutil.IsRequestRetryable(err) {
+					log.Errorln(err)
+					return err
+				}
+				snapshot, _, err = util.PatchSnapshot(c.ExtClient.KubedbV1alpha1(), snapshot, func(in *api.Snapshot) *api.Snapshot {
+					in.ObjectMeta = core_util.RemoveFinalizer(in.ObjectMeta, api.GenericKey)
+					return in
+				})
+				return err
+			}
+		} else {
+			snapshot, _, err = util.PatchSnapshot(c.ExtClient.KubedbV1alpha1(), snapshot, func(in *api.Snapshot) *api.Snapshot {
+				in.ObjectMeta = core_util.AddFinalizer(in.ObjectMeta, api.GenericKey)
+				in.Labels[api.LabelDatabaseName] = in.Spec.DatabaseName
+				return in
+			})
+			if err != nil {
+				return err
+			}
+			if err := c.createNativeSnapshot(snapshot); err != nil {
+				log.Errorln(err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// createNativeSnapshot starts the snapshot in Elasticsearch once, then polls it until
+// it completes. Progress is written into the status of the Snapshot.
+func (c *Controller) createNativeSnapshot(snapshot *api.Snapshot) error {
+	// Do not process "completed", aka "failed" or "succeeded", snapshots.
+	if snapshot.Status.Phase == api.SnapshotPhaseFailed || snapshot.Status.Phase == api.SnapshotPhaseSucceeded {
+		return nil
+	}
+
+	if err := c.ValidateSnapshot(snapshot); err != nil {
+		if kutil.IsRequestRetryable(err) {
+			return err
+		}
+		return c.markNativeSnapshotFailed(snapshot, nil, err.Error())
+	}
+	repoName, repository, err := snapshotRepository(snapshot, false)
+	if err != nil {
+		return c.markNativeSnapshotFailed(snapshot, nil, err.Error())
+	}
+
+	elasticsearch, err := c.esLister.Elasticsearches(snapshot.Namespace).Get(snapshot.Spec.DatabaseName)
+	if err != nil {
+		return err
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(snapshot)
+	if err != nil {
+		return err
+	}
+	if snapshot.Status.Phase == "" {
+		// new credentials or volumes are rolled out to the nodes by the database queue
+		loaded, err := c.nodesLoadedRepository(elasticsearch, snapshot)
+		if err != nil {
+			return err
+		}
+		if !loaded {
+			if _, err := util.UpdateSnapshotStatus(c.ExtClient.KubedbV1alpha1(), snapshot, func(in *api.SnapshotStatus) *api.SnapshotStatus {
+				in.Reason = "waiting for all nodes to be restarted with access to the repository"
+				return in
+			}, apis.EnableStatusSubresource); err != nil {
+				return err
+			}
+			if esKey, err := cache.MetaNamespaceKeyFunc(elasticsearch); err == nil {
+				c.esQueue.GetQueue().Add(esKey)
+			}
+			c.nativeSnapQueue.GetQueue().AddAfter(key, nativeSnapshotCheckInterval)
+			return nil
+		}
+	}
+
+	client, err := c.getElasticClient(elasticsearch)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	if snapshot.Status.Phase == "" {
+		if err := client.CreateSnapshotRepository(repoName, repository); err != nil {
+			return c.markNativeSnapshotFailed(snapshot, elasticsearch, fmt.Sprintf("failed to register snapshot repository %s. Reason: %v", repoName, err))
+		}
+		if err := client.CreateSnapshot(repoName, snapshot.Name); err != nil {
+			return c.markNativeSnapshotFailed(snapshot, elasticsearch, fmt.Sprintf("failed to start snapshot. Reason: %v", err))
+		}
+
+		snap, err := util.UpdateSnapshotStatus(c.ExtClient.KubedbV1alpha1(), snapshot, func(in *api.SnapshotStatus) *api.SnapshotStatus {
+			t := metav1.Now()
+			in.StartTime = &t
+			in.Phase = api.SnapshotPhaseRunning
+			return in
+		}, apis.EnableStatusSubresource)
+		if err != nil {
+			return err
+		}
+		snapshot.Status = snap.Status
+
+		c.recorder.Event(elasticsearch, core.EventTypeNormal, eventer.EventReasonStarting, "Backup running")
+		c.recorder.Event(snapshot, core.EventTypeNormal, eventer.EventReasonStarting, "Backup running")
+	}
+
+	status, err := client.GetSnapshotStatus(repoName, snapshot.Name)
+	if err != nil {
+		return err
+	}
+
+	switch status.State {
+	case es.SnapshotStateSuccess:
+		if _, err := util.UpdateSnapshotStatus(c.ExtClient.KubedbV1alpha1(), snapshot, func(in *api.SnapshotStatus) *api.SnapshotStatus {
+			t := metav1.Now()
+			in.CompletionTime = &t
+			in.Phase = api.SnapshotPhaseSucceeded
+			in.Reason = ""
+			return in
+		}, apis.EnableStatusSubresource); err != nil {
+			return err
+		}
+		c.recorder.Event(elasticsearch, core.EventTypeNormal, eventer.EventReasonSuccessfulSnapshot, "Successfully completed snapshot")
+		c.recorder.Event(snapshot, core.EventTypeNormal, eventer.EventReasonSuccessfulSnapshot, "Successfully completed snapshot")
+		return nil
+	case es.SnapshotStateFailed, es.SnapshotStatePartial, es.SnapshotStateAborted:
+		return c.markNativeSnapshotFailed(snapshot, elasticsearch, fmt.Sprintf("snapshot finished in state %s. %d/%d shards failed",
+			status.State, status.ShardsStats.Failed, status.ShardsStats.Total))
+	}
+
+	if _, err := util.UpdateSnapshotStatus(c.ExtClient.KubedbV1alpha1(), snapshot, func(in *api.SnapshotStatus) *api.SnapshotStatus {
+		in.Reason = fmt.Sprintf("%d/%d shards done", status.ShardsStats.Done, status.ShardsStats.Total)
+		return in
+	}, apis.EnableStatusSubresource); err != nil {
+		return err
+	}
+
+	c.nativeSnapQueue.GetQueue().AddAfter(key, nativeSnapshotCheckInterval)
+	return nil
+}
+
+func (c *Controller) markNativeSnapshotFailed(snapshot *api.Snapshot, elasticsearch *api.Elasticsearch, reason string) error {
+	if _, err := util.UpdateSnapshotStatus(c.ExtClient.KubedbV1alpha1(), snapshot, func(in *api.SnapshotStatus) *api.SnapshotStatus {
+		t := metav1.Now()
+		if in.StartTime == nil {
+			in.StartTime = &t
+		}
+		in.CompletionTime = &t
+		in.Phase = api.SnapshotPhaseFailed
+		in.Reason = reason
+		return in
+	}, apis.EnableStatusSubresource); err != nil {
+		return err
+	}
+	if elasticsearch != nil {
+		c.recorder.Event(elasticsearch, core.EventTypeWarning, eventer.EventReasonSnapshotFailed, reason)
+	}
+	c.recorder.Event(snapshot, core.EventTypeWarning, eventer.EventReasonSnapshotFailed, reason)
+	return nil
+}
+
+// deleteNativeSnapshot removes the snapshot from its repository. It is skipped if the
+// database no longer exists, since the repository can only be reached through it.
+func (c *Controller) deleteNativeSnapshot(snapshot *api.Snapshot) error {
+	elasticsearch, err := c.esLister.Elasticsearches(snapshot.Namespace).Get(snapshot.Spec.DatabaseName)
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	repoName, _, err := snapshotRepository(snapshot, false)
+	if err != nil {
+		return nil
+	}
+
+	c.recorder.Eventf(elasticsearch, core.EventTypeNormal, eventer.EventReasonWipingOut, "Wiping out Snapshot: %v", snapshot.Name)
+
+	client, err := c.getElasticClient(elasticsearch)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	if err := client.DeleteSnapshot(repoName, snapshot.Name); err != nil {
+		c.recorder.Eventf(elasticsearch, core.EventTypeWarning, eventer.EventReasonFailedToWipeOut, "Failed to wipeOut. Reason: %v", err)
+		return err
+	}
+	c.recorder.Eventf(elasticsearch, core.EventTypeNormal, eventer.EventReasonSuccessfulWipeOut, "Successfully wiped out Snapshot: %v", snapshot.Name)
+	return nil
+}
+
+// restoreNativeSnapshot registers the repository of the snapshot as read-only and starts
+// restoring its indices, except the security index. checkNativeRestore tracks the progress
+// afterwards. The repository of the snapshot is part of the nodes from the start, see
+// ensureRepositories.
+func (c *Controller) restoreNativeSnapshot(elasticsearch *api.Elasticsearch, snapshot *api.Snapshot) error {
+	repoName, repository, err := snapshotRepository(snapshot, true)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.getElasticClient(elasticsearch)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	if err := client.CreateSnapshotRepository(repoName, repository); err != nil {
+		return errors.Wrapf(err, "failed to register snapshot repository %s", repoName)
+	}
+	if err := client.RestoreSnapshot(repoName, snapshot.Name); err != nil {
+		return errors.Wrapf(err, "failed to restore snapshot %s", snapshot.Name)
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(elasticsearch)
+	if err != nil {
+		return err
+	}
+	c.esQueue.GetQueue().AddAfter(key, nativeSnapshotCheckInterval)
+	return nil
+}
+
+// checkNativeRestore is called while the database is Initializing. Restore Jobs report
+// back through the Job controller, so only native restores are polled here.
+func (c *Controller) checkNativeRestore(elasticsearch *api.Elasticsearch) error {
+	snapshotSource := elasticsearch.Spec.Init.SnapshotSource
+	if snapshotSource == nil {
+		return nil
+	}
+	namespace := snapshotSource.Namespace
+	if namespace == "" {
+		namespace = elasticsearch.Namespace
+	}
+	snapshot, err := c.ExtClient.KubedbV1alpha1().Snapshots(namespace).Get(snapshotSource.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !isNativeSnapshot(snapshot) {
+		return nil
+	}
+
+	client, err := c.getElasticClient(elasticsearch)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	progress, err := client.GetSnapshotRecovery()
+	if err != nil {
+		return err
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(elasticsearch)
+	if err != nil {
+		return err
+	}
+
+	// Shards are listed as soon as the restore is started. A snapshot without indices,
+	// other than the security index that isn't restored, has no shards to recover.
+	done := progress.Total > 0 && progress.Done == progress.Total
+	if progress.Total == 0 {
+		repoName, _, err := snapshotRepository(snapshot, true)
+		if err != nil {
+			return err
+		}
+		status, err := client.GetSnapshotStatus(repoName, snapshot.Name)
+		if err != nil {
+			return err
+		}
+		done = status.State == es.SnapshotStateSuccess
+	}
+
+	if !done {
+		err = c.SetDatabaseStatus(elasticsearch.ObjectMeta, api.DatabasePhaseInitializing,
+			fmt.Sprintf("restored %d/%d shards from snapshot %s", progress.Done, progress.Total, snapshot.Name))
+		if err != nil {
+			return err
+		}
+		c.esQueue.GetQueue().AddAfter(key, nativeSnapshotCheckInterval)
+		return nil
+	}
+
+	if err := c.UpsertDatabaseAnnotation(elasticsearch.ObjectMeta, map[string]string{
+		api.AnnotationInitialized: "",
+	}); err != nil {
+		return err
+	}
+	if err := c.SetDatabaseStatus(elasticsearch.ObjectMeta, api.DatabasePhaseRunning, ""); err != nil {
+		return err
+	}
+	c.recorder.Eventf(
+		elasticsearch,
+		core.EventTypeNormal,
+		eventer.EventReasonSuccessfulInitialize,
+		`Successfully restored Snapshot: "%v"`,
+		snapshot.Name,
+	)
+	// process once more to schedule backups and set up monitoring
+	c.esQueue.GetQueue().Add(key)
+	return nil
+}
+
+// snapshotRepository builds the repository for the backend of a Snapshot. The repository
+// name is derived from its definition, so all Snapshots stored in the same location share
+// one repository and are incremental. Credentials and the endpoint of s3, gcs and azure
+// repositories are client settings of the repository plugins, named after the storage
+// secret, and are loaded into the keystore of the nodes by ensureRepositories. Locations of
+// fs repositories are mounted on all nodes and listed in path.repo.
+func snapshotRepository(snapshot *api.Snapshot, readonly bool) (string, *es.SnapshotRepository, error) {
+	basePath, err := snapshot.Location()
+	if err != nil {
+		return "", nil, err
+	}
+
+	backend := snapshot.Spec.Backend
+	var repository *es.SnapshotRepository
+	switch {
+	case backend.S3 != nil:
+		repository = &es.SnapshotRepository{
+			Type: "s3",
+			Settings: map[string]interface{}{
+				"bucket":    backend.S3.Bucket,
+				"base_path": basePath,
+			},
+		}
+	case backend.GCS != nil:
+		repository = &es.SnapshotRepository{
+			Type: "gcs",
+			Settings: map[string]interface{}{
+				"bucket":    backend.GCS.Bucket,
+				"base_path": basePath,
+			},
+		}
+	case backend.Azure != nil:
+		repository = &es.SnapshotRepository{
+			Type: "azure",
+			Settings: map[string]interface{}{
+				"container": backend.Azure.Container,
+				"base_path": basePath,
+			},
+		}
+	case backend.Local != nil:
+		repository = &es.SnapshotRepository{
+			Type: "fs",
+			Settings: map[string]interface{}{
+				"location": filepath.Join(backend.Local.MountPath, basePath),
+			},
+		}
+	default:
+		return "", nil, errors.New("native snapshots only support s3, gcs, azure and local backends")
+	}
+	if backend.Local == nil && backend.StorageSecretName != "" {
+		repository.Settings["client"] = repositoryClient(snapshot)
+	}
+
+	data, err := json.Marshal(repository)
+	if err != nil {
+		return "", nil, err
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(data)
+	name := fmt.Sprintf("%s-%s-%x", api.DatabaseNamePrefix, repository.Type, h.Sum32())
+
+	if readonly {
+		repository.Settings["readonly"] = true
+	}
+	return name, repository, nil
+}
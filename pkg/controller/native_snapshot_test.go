@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	store "kmodules.xyz/objectstore-api/api/v1"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+)
+
+func newNativeSnapshot(backend store.Backend) *api.Snapshot {
+	return &api.Snapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "snapshot",
+			Namespace: "demo",
+			Labels:    map[string]string{LabelSnapshotMode: SnapshotModeNative},
+		},
+		Spec: api.SnapshotSpec{
+			DatabaseName: "es",
+			Backend:      backend,
+		},
+	}
+}
+
+func TestSnapshotRepository(t *testing.T) {
+	cases := []struct {
+		name     string
+		backend  store.Backend
+		readonly bool
+		expected map[string]interface{}
+		repoType string
+		wantErr  bool
+	}{
+		{
+			name: "s3",
+			backend: store.Backend{
+				StorageSecretName: "S3-Secret",
+				S3:                &store.S3Spec{Bucket: "bucket", Prefix: "prefix"},
+			},
+			repoType: "s3",
+			expected: map[string]interface{}{
+				"bucket":    "bucket",
+				"base_path": "prefix/kubedb/demo/es",
+				"client":    "s3-secret",
+			},
+		},
+		{
+			name: "gcs without storage secret",
+			backend: store.Backend{
+				GCS: &store.GCSSpec{Bucket: "bucket"},
+			},
+			repoType: "gcs",
+			expected: map[string]interface{}{
+				"bucket":    "bucket",
+				"base_path": "kubedb/demo/es",
+			},
+		},
+		{
+			name: "azure, read-only",
+			backend: store.Backend{
+				StorageSecretName: "azure-secret",
+				Azure:             &store.AzureSpec{Container: "container"},
+			},
+			readonly: true,
+			repoType: "azure",
+			expected: map[string]interface{}{
+				"container": "container",
+				"base_path": "kubedb/demo/es",
+				"client":    "azure-secret",
+				"readonly":  true,
+			},
+		},
+		{
+			name: "local",
+			backend: store.Backend{
+				StorageSecretName: "ignored",
+				Local:             &store.LocalSpec{MountPath: "/repo"},
+			},
+			repoType: "fs",
+			expected: map[string]interface{}{
+				"location": "/repo/kubedb/demo/es",
+			},
+		},
+		{
+			name: "swift",
+			backend: store.Backend{
+				Swift: &store.SwiftSpec{Container: "container"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			name, repository, err := snapshotRepository(newNativeSnapshot(c.backend), c.readonly)
+			if c.wantErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if repository.Type != c.repoType {
+				t.Errorf("expected type %q, got %q", c.repoType, repository.Type)
+			}
+			if !reflect.DeepEqual(repository.Settings, c.expected) {
+				t.Errorf("expected settings %v, got %v", c.expected, repository.Settings)
+			}
+
+			// the name doesn't depend on readonly, so snapshots and restores share the repository
+			other, _, err := snapshotRepository(newNativeSnapshot(c.backend), !c.readonly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != other {
+				t.Errorf("expected the same name for readonly and writable repositories, got %q and %q", name, other)
+			}
+		})
+	}
+
+	s3 := func(bucket string) store.Backend {
+		return store.Backend{S3: &store.S3Spec{Bucket: bucket}}
+	}
+	a, _, _ := snapshotRepository(newNativeSnapshot(s3("a")), false)
+	b, _, _ := snapshotRepository(newNativeSnapshot(s3("b")), false)
+	if a == b {
+		t.Errorf("expected different names for different buckets, got %q", a)
+	}
+}
+
+func TestRepositoryCredentials(t *testing.T) {
+	secret := &core.Secret{
+		Data: map[string][]byte{
+			store.AWS_ACCESS_KEY_ID:     []byte("id"),
+			store.AWS_SECRET_ACCESS_KEY: []byte("key"),
+			"unrelated":                 []byte("value"),
+		},
+	}
+	snapshot := newNativeSnapshot(store.Backend{
+		StorageSecretName: "s3-secret",
+		S3:                &store.S3Spec{Bucket: "bucket", Endpoint: "https://minio:9000"},
+	})
+	expected := map[string][]byte{
+		"s3.client.s3-secret.access_key": []byte("id"),
+		"s3.client.s3-secret.secret_key": []byte("key"),
+		"s3.client.s3-secret.endpoint":   []byte("https://minio:9000"),
+	}
+	if credentials := repositoryCredentials(snapshot, secret); !reflect.DeepEqual(credentials, expected) {
+		t.Errorf("expected %v, got %v", expected, credentials)
+	}
+
+	local := newNativeSnapshot(store.Backend{Local: &store.LocalSpec{MountPath: "/repo"}})
+	if credentials := repositoryCredentials(local, secret); credentials != nil {
+		t.Errorf("expected no credentials for local backends, got %v", credentials)
+	}
+}
+
+func TestUpsertRepositories(t *testing.T) {
+	elasticsearch := &api.Elasticsearch{ObjectMeta: metav1.ObjectMeta{Name: "es", Namespace: "demo"}}
+	newStatefulSet := func() *apps.StatefulSet {
+		sts := &apps.StatefulSet{}
+		sts.Spec.Template.Annotations = map[string]string{"a": "b"}
+		sts.Spec.Template.Spec.Containers = []core.Container{{Name: api.ResourceSingularElasticsearch}}
+		return sts
+	}
+
+	// nothing changes without native snapshots
+	sts := upsertRepositories(newStatefulSet(), elasticsearch, &nodeRepositories{})
+	if !reflect.DeepEqual(sts, newStatefulSet()) {
+		t.Errorf("expected the StatefulSet to be unchanged, got %+v", sts)
+	}
+
+	sts = upsertRepositories(newStatefulSet(), elasticsearch, &nodeRepositories{
+		credentialsHash: "hash",
+		locals: []store.LocalSpec{
+			{MountPath: "/a", VolumeSource: core.VolumeSource{EmptyDir: &core.EmptyDirVolumeSource{}}},
+			{MountPath: "/b", SubPath: "sub", VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/b"}}},
+		},
+	})
+	if sts.Spec.Template.Annotations[AnnotationRepositoryCredentials] != "hash" || sts.Spec.Template.Annotations["a"] != "b" {
+		t.Errorf("unexpected annotations %v", sts.Spec.Template.Annotations)
+	}
+	container := sts.Spec.Template.Spec.Containers[0]
+	expectedMounts := []core.VolumeMount{
+		{Name: repositoryCredentialsVolumeName, MountPath: RepositoryCredentialsDir, ReadOnly: true},
+		{Name: "repository-0", MountPath: "/a"},
+		{Name: "repository-1", MountPath: "/b", SubPath: "sub"},
+	}
+	if !reflect.DeepEqual(container.VolumeMounts, expectedMounts) {
+		t.Errorf("expected volume mounts %+v, got %+v", expectedMounts, container.VolumeMounts)
+	}
+	if len(sts.Spec.Template.Spec.Volumes) != 3 ||
+		sts.Spec.Template.Spec.Volumes[0].Secret == nil ||
+		sts.Spec.Template.Spec.Volumes[0].Secret.SecretName != "es-repository-credentials" {
+		t.Errorf("unexpected volumes %+v", sts.Spec.Template.Spec.Volumes)
+	}
+	expectedEnv := []core.EnvVar{{Name: "REPO_LOCATIONS", Value: "/a,/b"}}
+	if !reflect.DeepEqual(container.Env, expectedEnv) {
+		t.Errorf("expected env %v, got %v", expectedEnv, container.Env)
+	}
+	if !hasVolumeMount(sts, "/b") || hasVolumeMount(sts, "/c") {
+		t.Errorf("unexpected result of hasVolumeMount")
+	}
+}
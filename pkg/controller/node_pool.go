@@ -0,0 +1,370 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/appscode/go/types"
+	core "k8s.io/api/core/v1"
+	kutil "kmodules.xyz/client-go"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+)
+
+// attributeBoxType is the node attribute that tells hot and warm data nodes apart. It is passed
+// to the nodes in NODE_TAG, which the image writes to elasticsearch.yml as node.attr.box_type.
+// The other attributes are passed in NODE_ATTRIBUTES.
+const attributeBoxType = "box_type"
+
+// nodePools returns the node pools of the cluster, in the order their StatefulSets are created.
+// The combined node, and the master, data, client, warm and ingest nodes of the topology, are
+// converted into node pools named after their prefix, with the same labels and environment
+// as before, so their StatefulSets are not changed.
+func nodePools(elasticsearch *api.Elasticsearch) []api.ElasticsearchNodePool {
+	podSpec := elasticsearch.Spec.PodTemplate.Spec
+	topology := elasticsearch.Spec.Topology
+
+	var pools []api.ElasticsearchNodePool
+	if topology == nil {
+		pools = []api.ElasticsearchNodePool{
+			{
+				Roles: []api.ElasticsearchNodeRole{
+					api.ElasticsearchNodeRoleMaster,
+					api.ElasticsearchNodeRoleData,
+					api.ElasticsearchNodeRoleIngest,
+					api.ElasticsearchNodeRoleCoordinating,
+				},
+				Replicas:       elasticsearch.Spec.Replicas,
+				StorageType:    elasticsearch.Spec.StorageType,
+				Storage:        elasticsearch.Spec.Storage,
+				Resources:      podSpec.Resources,
+				HeapSize:       elasticsearch.Spec.HeapSize,
+				NodeSelector:   podSpec.NodeSelector,
+				Affinity:       podSpec.Affinity,
+				Tolerations:    podSpec.Tolerations,
+				MaxUnavailable: elasticsearch.Spec.MaxUnavailable,
+			},
+		}
+	} else if len(topology.NodePools) > 0 {
+		for _, pool := range topology.NodePools {
+			if pool.NodeSelector == nil {
+				pool.NodeSelector = podSpec.NodeSelector
+			}
+			if pool.Affinity == nil {
+				pool.Affinity = podSpec.Affinity
+			}
+			if pool.Tolerations == nil {
+				pool.Tolerations = podSpec.Tolerations
+			}
+			pools = append(pools, pool)
+		}
+	} else {
+		node := func(node api.ElasticsearchNode, attributes map[string]string, roles ...api.ElasticsearchNodeRole) api.ElasticsearchNodePool {
+			return api.ElasticsearchNodePool{
+				Name:           node.Prefix,
+				Roles:          roles,
+				Attributes:     attributes,
+				Replicas:       node.Replicas,
+				StorageType:    node.StorageType,
+				Storage:        node.Storage,
+				Resources:      node.Resources,
+				HeapSize:       node.HeapSize,
+				NodeSelector:   node.NodeSelector,
+				Affinity:       podSpec.Affinity,
+				Tolerations:    podSpec.Tolerations,
+				MaxUnavailable: node.MaxUnavailable,
+			}
+		}
+
+		pools = append(pools,
+			node(topology.Master, nil, api.ElasticsearchNodeRoleMaster),
+			node(topology.Data, map[string]string{attributeBoxType: boxTypeHot}, api.ElasticsearchNodeRoleData),
+		)
+		if topology.Warm.Replicas != nil {
+			pools = append(pools, node(topology.Warm, map[string]string{attributeBoxType: boxTypeWarm}, api.ElasticsearchNodeRoleData))
+		}
+		// pipelines run on the client nodes, unless there are dedicated ingest nodes
+		clientRoles := []api.ElasticsearchNodeRole{api.ElasticsearchNodeRoleCoordinating}
+		if topology.Ingest.Replicas != nil {
+			pools = append(pools, node(topology.Ingest, nil, api.ElasticsearchNodeRoleIngest))
+		} else {
+			clientRoles = append(clientRoles, api.ElasticsearchNodeRoleIngest)
+		}
+		pools = append(pools, node(topology.Client, nil, clientRoles...))
+	}
+
+	// masters go first, so the other nodes can join the cluster, and ingest nodes go before
+	// the coordinating nodes, which stop running pipelines once they exist
+	sort.SliceStable(pools, func(i, j int) bool {
+		return nodePoolRank(pools[i]) < nodePoolRank(pools[j])
+	})
+	return pools
+}
+
+// nodePoolRank orders node pools by their most important role.
+func nodePoolRank(pool api.ElasticsearchNodePool) int {
+	for rank, role := range []api.ElasticsearchNodeRole{
+		api.ElasticsearchNodeRoleMaster,
+		api.ElasticsearchNodeRoleData,
+		api.ElasticsearchNodeRoleIngest,
+	} {
+		if hasRole(pool, role) {
+			return rank
+		}
+	}
+	return 3
+}
+
+func hasRole(pool api.ElasticsearchNodePool, role api.ElasticsearchNodeRole) bool {
+	for _, r := range pool.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// nodePoolReplicas returns the number of nodes of a pool, which defaults to one.
+func nodePoolReplicas(pool api.ElasticsearchNodePool) int32 {
+	if pool.Replicas == nil {
+		return 1
+	}
+	return types.Int32(pool.Replicas)
+}
+
+// nodePoolLabels returns the role labels of the pods of a node pool. The role labels are part of
+// the selector of the StatefulSet, so the roles of a pool can't be changed. Coordinating nodes
+// run pipelines too, but only the other ingest nodes are labeled with NodeRoleIngest, so
+// spec.topology.routeToIngest sends requests past the coordinating nodes.
+func nodePoolLabels(elasticsearch *api.Elasticsearch, pool api.ElasticsearchNodePool) map[string]string {
+	labels := elasticsearch.OffshootLabels()
+	if hasRole(pool, api.ElasticsearchNodeRoleCoordinating) {
+		labels[NodeRoleClient] = "set"
+	} else if hasRole(pool, api.ElasticsearchNodeRoleIngest) {
+		labels[NodeRoleIngest] = "set"
+	}
+	if hasRole(pool, api.ElasticsearchNodeRoleMaster) {
+		labels[NodeRoleMaster] = "set"
+	}
+	if hasRole(pool, api.ElasticsearchNodeRoleData) {
+		labels[NodeRoleData] = "set"
+	}
+	return labels
+}
+
+// nodePoolEnv returns the environment that configures the roles and attributes of the nodes
+// of a pool. Only the roles a node doesn't have are set, as the image enables all of them.
+func nodePoolEnv(elasticsearch *api.Elasticsearch, pools []api.ElasticsearchNodePool, pool api.ElasticsearchNodePool) []core.EnvVar {
+	if topology := elasticsearch.Spec.Topology; topology == nil || len(topology.NodePools) == 0 {
+		return convertedNodePoolEnv(pool)
+	}
+
+	var envList []core.EnvVar
+	for _, role := range []struct {
+		role api.ElasticsearchNodeRole
+		env  string
+	}{
+		{api.ElasticsearchNodeRoleMaster, "NODE_MASTER"},
+		{api.ElasticsearchNodeRoleData, "NODE_DATA"},
+		{api.ElasticsearchNodeRoleIngest, "NODE_INGEST"},
+	} {
+		if !hasRole(pool, role.role) {
+			envList = append(envList, core.EnvVar{
+				Name:  role.env,
+				Value: fmt.Sprintf("%v", false),
+			})
+		}
+	}
+
+	if hasRole(pool, api.ElasticsearchNodeRoleMaster) {
+		var masters int32
+		for _, p := range pools {
+			if hasRole(p, api.ElasticsearchNodeRoleMaster) {
+				masters += nodePoolReplicas(p)
+			}
+		}
+		envList = append(envList, core.EnvVar{
+			Name:  "NUMBER_OF_MASTERS",
+			Value: fmt.Sprintf("%v", (masters/2)+1),
+		})
+	}
+
+	// MODE selects the client-config or ingest-config of the custom config
+	if hasRole(pool, api.ElasticsearchNodeRoleCoordinating) {
+		envList = append(envList, core.EnvVar{
+			Name:  "MODE",
+			Value: "client",
+		})
+	} else if hasRole(pool, api.ElasticsearchNodeRoleIngest) {
+		envList = append(envList, core.EnvVar{
+			Name:  "MODE",
+			Value: "ingest",
+		})
+	}
+
+	heapSize := getHeapSizeForNode(pool.HeapSize, pool.Resources)
+	envList = append(envList, core.EnvVar{
+		Name:  "ES_JAVA_OPTS",
+		Value: fmt.Sprintf("-Xms%v -Xmx%v", heapSize, heapSize),
+	})
+
+	if boxType, ok := pool.Attributes[attributeBoxType]; ok {
+		envList = append(envList, core.EnvVar{
+			Name:  "NODE_TAG",
+			Value: boxType,
+		})
+	}
+	var attributes []string
+	for k, v := range pool.Attributes {
+		if k != attributeBoxType {
+			attributes = append(attributes, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+	if len(attributes) > 0 {
+		sort.Strings(attributes)
+		envList = append(envList, core.EnvVar{
+			Name:  "NODE_ATTRIBUTES",
+			Value: strings.Join(attributes, ","),
+		})
+	}
+	return envList
+}
+
+// convertedNodePoolEnv returns the environment of a pool converted from the combined node or the
+// fixed topology, in the order the nodes had before node pools, so their pod template is unchanged.
+func convertedNodePoolEnv(pool api.ElasticsearchNodePool) []core.EnvVar {
+	env := func(name string, value interface{}) core.EnvVar {
+		return core.EnvVar{Name: name, Value: fmt.Sprintf("%v", value)}
+	}
+	heapSize := getHeapSizeForNode(pool.HeapSize, pool.Resources)
+	javaOpts := env("ES_JAVA_OPTS", fmt.Sprintf("-Xms%v -Xmx%v", heapSize, heapSize))
+	numberOfMasters := env("NUMBER_OF_MASTERS", (nodePoolReplicas(pool)/2)+1)
+
+	switch {
+	case hasRole(pool, api.ElasticsearchNodeRoleMaster) && hasRole(pool, api.ElasticsearchNodeRoleData):
+		return []core.EnvVar{
+			numberOfMasters,
+			env("MODE", "client"),
+			javaOpts,
+		}
+	case hasRole(pool, api.ElasticsearchNodeRoleMaster):
+		return []core.EnvVar{
+			env("NODE_DATA", false),
+			env("NODE_INGEST", false),
+			env("HTTP_ENABLE", true),
+			numberOfMasters,
+			javaOpts,
+		}
+	case hasRole(pool, api.ElasticsearchNodeRoleData):
+		return []core.EnvVar{
+			env("NODE_MASTER", false),
+			env("NODE_INGEST", false),
+			env("HTTP_ENABLE", true),
+			javaOpts,
+			env("NODE_TAG", pool.Attributes[attributeBoxType]),
+		}
+	case hasRole(pool, api.ElasticsearchNodeRoleCoordinating):
+		envList := []core.EnvVar{
+			env("NODE_MASTER", false),
+			env("NODE_DATA", false),
+			env("MODE", "client"),
+			javaOpts,
+		}
+		// pipelines run on the dedicated ingest nodes
+		if !hasRole(pool, api.ElasticsearchNodeRoleIngest) {
+			envList = append(envList, env("NODE_INGEST", false))
+		}
+		return envList
+	default:
+		return []core.EnvVar{
+			env("NODE_MASTER", false),
+			env("NODE_DATA", false),
+			env("NODE_INGEST", true),
+			env("HTTP_ENABLE", true),
+			env("MODE", "ingest"),
+			javaOpts,
+		}
+	}
+}
+
+// ensureNodePools creates or updates the StatefulSets of all node pools.
+func (c *Controller) ensureNodePools(elasticsearch *api.Elasticsearch) (kutil.VerbType, error) {
+	repos, err := c.ensureRepositories(elasticsearch)
+	if err != nil {
+		return kutil.VerbUnchanged, err
+	}
+	verifyHostnames, err := c.transportHostnameVerification(elasticsearch)
+	if err != nil {
+		return kutil.VerbUnchanged, err
+	}
+
+	pools := nodePools(elasticsearch)
+	verbs := make([]kutil.VerbType, 0, len(pools))
+	for _, pool := range pools {
+		envs := nodePoolEnv(elasticsearch, pools, pool)
+		if verifyHostnames {
+			envs = append(envs, core.EnvVar{Name: "TRANSPORT_HOSTNAME_VERIFICATION", Value: "true"})
+		}
+		vt, err := c.ensureStatefulSet(
+			elasticsearch,
+			pool,
+			nodeStatefulSetName(elasticsearch, pool.Name),
+			nodePoolLabels(elasticsearch, pool),
+			envs,
+			repos,
+		)
+		if err != nil {
+			return kutil.VerbUnchanged, err
+		}
+		verbs = append(verbs, vt)
+	}
+	return combinedVerb(verbs...), nil
+}
+
+// hasIngestNodes reports whether the topology has ingest nodes, that are not coordinating nodes.
+func hasIngestNodes(elasticsearch *api.Elasticsearch) bool {
+	for _, pool := range nodePools(elasticsearch) {
+		if nodePoolLabels(elasticsearch, pool)[NodeRoleIngest] == "set" {
+			return true
+		}
+	}
+	return false
+}
+
+// routesToIngest reports whether the primary service routes to the ingest nodes.
+func routesToIngest(elasticsearch *api.Elasticsearch) bool {
+	return elasticsearch.Spec.Topology != nil && elasticsearch.Spec.Topology.RouteToIngest && hasIngestNodes(elasticsearch)
+}
+
+// hasWarmNodes reports whether the topology has warm data nodes.
+func hasWarmNodes(elasticsearch *api.Elasticsearch) bool {
+	for _, pool := range nodePools(elasticsearch) {
+		if hasRole(pool, api.ElasticsearchNodeRoleData) && pool.Attributes[attributeBoxType] == boxTypeWarm {
+			return true
+		}
+	}
+	return false
+}
+
+// dataNodePool returns the first node pool with the data role.
+func dataNodePool(elasticsearch *api.Elasticsearch) api.ElasticsearchNodePool {
+	pools := nodePools(elasticsearch)
+	for _, pool := range pools {
+		if hasRole(pool, api.ElasticsearchNodeRoleData) {
+			return pool
+		}
+	}
+	return pools[0]
+}
+
+// coordinatingNodePool returns the first node pool, that serves the primary service. The
+// operator connects to its first pod.
+func coordinatingNodePool(elasticsearch *api.Elasticsearch) api.ElasticsearchNodePool {
+	pools := nodePools(elasticsearch)
+	for _, pool := range pools {
+		if hasRole(pool, api.ElasticsearchNodeRoleCoordinating) {
+			return pool
+		}
+	}
+	return pools[len(pools)-1]
+}
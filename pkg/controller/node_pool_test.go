@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/appscode/go/types"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+)
+
+// TestConvertedNodePoolEnv checks that the pools converted from the combined node and the fixed
+// topology get the environment the nodes had before node pools, in the same order, so their
+// StatefulSets are not changed.
+func TestConvertedNodePoolEnv(t *testing.T) {
+	heap := resource.MustParse("256Mi")
+	env := func(kv ...string) []core.EnvVar {
+		var envList []core.EnvVar
+		for i := 0; i < len(kv); i += 2 {
+			envList = append(envList, core.EnvVar{Name: kv[i], Value: kv[i+1]})
+		}
+		return envList
+	}
+	const javaOpts = "-Xms268435456 -Xmx268435456"
+	node := func(prefix string, replicas int32) api.ElasticsearchNode {
+		return api.ElasticsearchNode{Prefix: prefix, Replicas: types.Int32P(replicas), HeapSize: &heap}
+	}
+	topology := func(ingest bool) *api.Elasticsearch {
+		elasticsearch := &api.Elasticsearch{
+			ObjectMeta: metav1.ObjectMeta{Name: "es", Namespace: "demo"},
+			Spec: api.ElasticsearchSpec{
+				Topology: &api.ElasticsearchClusterTopology{
+					Master: node("master", 3),
+					Data:   node("data", 2),
+					Warm:   node("warm", 1),
+					Client: node("client", 1),
+				},
+			},
+		}
+		if ingest {
+			elasticsearch.Spec.Topology.Ingest = node("ingest", 1)
+		}
+		return elasticsearch
+	}
+
+	cases := []struct {
+		name          string
+		elasticsearch *api.Elasticsearch
+		expected      map[string][]core.EnvVar
+	}{
+		{
+			name: "combined",
+			elasticsearch: &api.Elasticsearch{
+				ObjectMeta: metav1.ObjectMeta{Name: "es", Namespace: "demo"},
+				Spec:       api.ElasticsearchSpec{Replicas: types.Int32P(3), HeapSize: &heap},
+			},
+			expected: map[string][]core.EnvVar{
+				"": env("NUMBER_OF_MASTERS", "2", "MODE", "client", "ES_JAVA_OPTS", javaOpts),
+			},
+		},
+		{
+			name:          "topology",
+			elasticsearch: topology(false),
+			expected: map[string][]core.EnvVar{
+				"master": env("NODE_DATA", "false", "NODE_INGEST", "false", "HTTP_ENABLE", "true", "NUMBER_OF_MASTERS", "2", "ES_JAVA_OPTS", javaOpts),
+				"data":   env("NODE_MASTER", "false", "NODE_INGEST", "false", "HTTP_ENABLE", "true", "ES_JAVA_OPTS", javaOpts, "NODE_TAG", "hot"),
+				"warm":   env("NODE_MASTER", "false", "NODE_INGEST", "false", "HTTP_ENABLE", "true", "ES_JAVA_OPTS", javaOpts, "NODE_TAG", "warm"),
+				"client": env("NODE_MASTER", "false", "NODE_DATA", "false", "MODE", "client", "ES_JAVA_OPTS", javaOpts),
+			},
+		},
+		{
+			name:          "topology with ingest nodes",
+			elasticsearch: topology(true),
+			expected: map[string][]core.EnvVar{
+				"master": env("NODE_DATA", "false", "NODE_INGEST", "false", "HTTP_ENABLE", "true", "NUMBER_OF_MASTERS", "2", "ES_JAVA_OPTS", javaOpts),
+				"data":   env("NODE_MASTER", "false", "NODE_INGEST", "false", "HTTP_ENABLE", "true", "ES_JAVA_OPTS", javaOpts, "NODE_TAG", "hot"),
+				"warm":   env("NODE_MASTER", "false", "NODE_INGEST", "false", "HTTP_ENABLE", "true", "ES_JAVA_OPTS", javaOpts, "NODE_TAG", "warm"),
+				"ingest": env("NODE_MASTER", "false", "NODE_DATA", "false", "NODE_INGEST", "true", "HTTP_ENABLE", "true", "MODE", "ingest", "ES_JAVA_OPTS", javaOpts),
+				"client": env("NODE_MASTER", "false", "NODE_DATA", "false", "MODE", "client", "ES_JAVA_OPTS", javaOpts, "NODE_INGEST", "false"),
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pools := nodePools(c.elasticsearch)
+			if len(pools) != len(c.expected) {
+				t.Fatalf("expected %d pools, got %d", len(c.expected), len(pools))
+			}
+			for _, pool := range pools {
+				if envList := nodePoolEnv(c.elasticsearch, pools, pool); !reflect.DeepEqual(envList, c.expected[pool.Name]) {
+					t.Errorf("pool %q: expected env %v, got %v", pool.Name, c.expected[pool.Name], envList)
+				}
+			}
+		})
+	}
+}
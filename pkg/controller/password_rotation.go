@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/appscode/go/crypto/rand"
+	"github.com/appscode/go/log"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	core_util "kmodules.xyz/client-go/core/v1"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/pkg/eventer"
+)
+
+const (
+	// AnnotationRotatePasswords requests new passwords for the admin and readall users whenever
+	// its value changes, eg, `kubectl annotate es <name> elasticsearch.kubedb.com/rotate-passwords="$(date)" --overwrite`
+	AnnotationRotatePasswords = "elasticsearch.kubedb.com/rotate-passwords"
+
+	// AnnotationPasswordsRotated is set on the database secret to the value of
+	// AnnotationRotatePasswords, once its passwords are rotated.
+	AnnotationPasswordsRotated = "elasticsearch.kubedb.com/passwords-rotated"
+
+	// rotationUserSuffix is appended to the names of the admin and readall users to get the names
+	// of the temporary users, that hold the pending passwords while they are rotated.
+	rotationUserSuffix = "-rotation"
+
+	passwordRotationCheckInterval = 10 * time.Second
+)
+
+// ensurePasswordRotation rotates the passwords of the admin and readall users in two steps.
+// First, the new passwords are stored in the database secret as pending passwords, and
+// temporary copies of the users with their hashes are added to the internal users file.
+// fsloader loads the file into the cluster with sgadmin, which authenticates by certificate.
+// Then, once the cluster accepts the pending admin password of the temporary admin user, the
+// hashes of the temporary users replace the ones of the users, and the pending passwords replace
+// the current ones, which the AppBinding, the exporter and new snapshot jobs read. So the current
+// passwords keep working, until they are replaced in the secret.
+func (c *Controller) ensurePasswordRotation(elasticsearch *api.Elasticsearch) error {
+	request, requested := elasticsearch.Annotations[AnnotationRotatePasswords]
+	if !usesSecurityPlugin(elasticsearch) {
+		if requested {
+			c.recorder.Eventf(
+				elasticsearch,
+				core.EventTypeWarning,
+				eventer.EventReasonFailedToUpdate,
+				"Ignoring annotation %s, as passwords can't be rotated with authPlugin %s",
+				AnnotationRotatePasswords,
+				elasticsearch.Spec.AuthPlugin,
+			)
+		}
+		return nil
+	}
+	if elasticsearch.Spec.DatabaseSecret == nil {
+		return nil
+	}
+
+	secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(elasticsearch.Spec.DatabaseSecret.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	key, err := cache.MetaNamespaceKeyFunc(elasticsearch)
+	if err != nil {
+		return err
+	}
+
+	if _, rotating := secret.Data[KeyAdminPasswordPending]; rotating {
+		return c.completePasswordRotation(elasticsearch, secret, key)
+	}
+
+	if !requested || request == secret.Annotations[AnnotationPasswordsRotated] {
+		return nil
+	}
+	if err := c.startPasswordRotation(elasticsearch, secret); err != nil {
+		return err
+	}
+	c.esQueue.GetQueue().AddAfter(key, passwordRotationCheckInterval)
+	return nil
+}
+
+// startPasswordRotation stores new pending passwords, and adds temporary users with their hashes
+// to the internal users file.
+func (c *Controller) startPasswordRotation(elasticsearch *api.Elasticsearch, secret *core.Secret) error {
+	plugin := searchGuardPlugin
+	usersFile := plugin.file(securityInternalUsers)
+	users, err := parseSecurityFile(secret.Data[usersFile])
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse %s of secret %s", usersFile, secret.Name)
+	}
+
+	passwords := map[string][]byte{
+		KeyAdminPasswordPending:   []byte(rand.Characters(8)),
+		KeyReadAllPasswordPending: []byte(rand.Characters(8)),
+	}
+	for pendingKey, userKey := range map[string]string{
+		KeyAdminPasswordPending:   KeyAdminUserName,
+		KeyReadAllPasswordPending: KeyReadAllUserName,
+	} {
+		hash, err := bcrypt.GenerateFromPassword(passwords[pendingKey], bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		name := string(secret.Data[userKey])
+		entry := make(map[string]interface{})
+		if current, ok := users[name].(map[string]interface{}); ok {
+			for k, v := range current {
+				entry[k] = v
+			}
+		}
+		entry["hash"] = string(hash)
+		users[name+rotationUserSuffix] = entry
+	}
+	usersData, err := marshalSecurityFile(secret.Data[usersFile], users)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = core_util.PatchSecret(c.Client, secret, func(in *core.Secret) *core.Secret {
+		for k, v := range passwords {
+			in.Data[k] = v
+		}
+		in.Data[usersFile] = usersData
+		setSecurityConfigRevision(plugin, in.Data)
+		return in
+	})
+	if err != nil {
+		return err
+	}
+	c.recorder.Event(elasticsearch, core.EventTypeNormal, eventer.EventReasonStarting, "Starting password rotation")
+	return nil
+}
+
+// completePasswordRotation replaces the current passwords, and the hashes of the users, with the
+// pending ones, once the cluster accepts the pending admin password of the temporary admin user.
+func (c *Controller) completePasswordRotation(elasticsearch *api.Elasticsearch, secret *core.Secret, key string) error {
+	client, err := c.getElasticClientWithAuth(elasticsearch, string(secret.Data[KeyAdminUserName])+rotationUserSuffix, string(secret.Data[KeyAdminPasswordPending]))
+	if err != nil {
+		log.Infof("Elasticsearch %v is waiting for the cluster to load the rotated passwords. Reason: %v", key, err)
+		c.esQueue.GetQueue().AddAfter(key, passwordRotationCheckInterval)
+		return nil
+	}
+	client.Stop()
+
+	plugin := searchGuardPlugin
+	usersFile := plugin.file(securityInternalUsers)
+	users, err := parseSecurityFile(secret.Data[usersFile])
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse %s of secret %s", usersFile, secret.Name)
+	}
+	for _, userKey := range []string{KeyAdminUserName, KeyReadAllUserName} {
+		name := string(secret.Data[userKey])
+		if entry, ok := users[name+rotationUserSuffix]; ok {
+			users[name] = entry
+			delete(users, name+rotationUserSuffix)
+		}
+	}
+	usersData, err := marshalSecurityFile(secret.Data[usersFile], users)
+	if err != nil {
+		return err
+	}
+
+	request := elasticsearch.Annotations[AnnotationRotatePasswords]
+	_, _, err = core_util.PatchSecret(c.Client, secret, func(in *core.Secret) *core.Secret {
+		in.Data[KeyAdminPassword] = in.Data[KeyAdminPasswordPending]
+		in.Data[KeyReadAllPassword] = in.Data[KeyReadAllPasswordPending]
+		delete(in.Data, KeyAdminPasswordPending)
+		delete(in.Data, KeyReadAllPasswordPending)
+		in.Data[usersFile] = usersData
+		setSecurityConfigRevision(plugin, in.Data)
+		in.Annotations = core_util.UpsertMap(in.Annotations, map[string]string{
+			AnnotationPasswordsRotated: request,
+		})
+		return in
+	})
+	if err != nil {
+		return err
+	}
+	c.recorder.Event(elasticsearch, core.EventTypeNormal, eventer.EventReasonSuccessful, "Successfully rotated passwords")
+	return nil
+}
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+)
+
+func TestEnsurePasswordRotationUnsupported(t *testing.T) {
+	for _, plugin := range []api.ElasticsearchAuthPlugin{api.ElasticsearchAuthPluginXpack, api.ElasticsearchAuthPluginNone} {
+		t.Run(string(plugin), func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			c := &Controller{recorder: recorder}
+			elasticsearch := &api.Elasticsearch{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "es",
+					Namespace:   "demo",
+					Annotations: map[string]string{AnnotationRotatePasswords: "now"},
+				},
+				Spec: api.ElasticsearchSpec{AuthPlugin: plugin},
+			}
+			if err := c.ensurePasswordRotation(elasticsearch); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case event := <-recorder.Events:
+				if !strings.HasPrefix(event, "Warning") || !strings.Contains(event, AnnotationRotatePasswords) {
+					t.Errorf("unexpected event %q", event)
+				}
+			default:
+				t.Errorf("expected a warning event")
+			}
+		})
+	}
+}
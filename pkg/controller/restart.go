@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appscode/go/log"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	core_util "kmodules.xyz/client-go/core/v1"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/pkg/eventer"
+	"kubedb.dev/elasticsearch/pkg/util/es"
+)
+
+const (
+	// AnnotationRestart requests a rolling restart of all nodes whenever its value changes,
+	// eg, `kubectl annotate es <name> elasticsearch.kubedb.com/restart="$(date)" --overwrite`
+	AnnotationRestart = "elasticsearch.kubedb.com/restart"
+
+	rollingRestartCheckInterval = 15 * time.Second
+)
+
+// nodeStatefulSetNames returns the names of the node StatefulSets in the order they are
+// restarted, the reverse order of nodePools. Master nodes go last, so the elected master
+// is kept as long as possible.
+func nodeStatefulSetNames(elasticsearch *api.Elasticsearch) []string {
+	pools := nodePools(elasticsearch)
+	names := make([]string, len(pools))
+	for i, pool := range pools {
+		names[len(pools)-1-i] = nodeStatefulSetName(elasticsearch, pool.Name)
+	}
+	return names
+}
+
+// podTemplateAnnotationKeys are the annotations of an Elasticsearch that are copied into the
+// pod template of its node StatefulSets, so changing them rolls out a new revision.
+var podTemplateAnnotationKeys = []string{
+	AnnotationRestart,
+	AnnotationCertificatesRenewed,
+}
+
+// podTemplateAnnotations returns the annotations of the pod template of node StatefulSets.
+func podTemplateAnnotations(elasticsearch *api.Elasticsearch) map[string]string {
+	var annotations map[string]string
+	for _, key := range podTemplateAnnotationKeys {
+		v, ok := elasticsearch.Annotations[key]
+		if !ok {
+			continue
+		}
+		if annotations == nil {
+			annotations = make(map[string]string, len(elasticsearch.Spec.PodTemplate.Annotations)+len(podTemplateAnnotationKeys))
+			for k, v := range elasticsearch.Spec.PodTemplate.Annotations {
+				annotations[k] = v
+			}
+		}
+		annotations[key] = v
+	}
+	if annotations == nil {
+		return elasticsearch.Spec.PodTemplate.Annotations
+	}
+	return annotations
+}
+
+// ensureRollingRestart restarts the pods that are not on the current revision of their
+// StatefulSet, one at a time. This also rolls out version upgrades, as those change the
+// image of the pod template. Before a pod is deleted, shard allocation is limited to
+// primaries and a synced flush is done, so the node recovers quickly once it is back.
+// Allocation is re-enabled after the node rejoins, and the next pod is only restarted
+// once health has recovered. Each call does a single step and requeues the database as
+// long as pods are outdated, so an interrupted restart is resumed after the operator
+// restarts. Allocation is only re-enabled, if it was limited by the operator, so a
+// setting made by hand is kept.
+func (c *Controller) ensureRollingRestart(elasticsearch *api.Elasticsearch) error {
+	if elasticsearch.Spec.UpdateStrategy.Type != apps.RollingUpdateStatefulSetStrategyType {
+		return nil
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(elasticsearch)
+	if err != nil {
+		return err
+	}
+	requeue := func() error {
+		c.esQueue.GetQueue().AddAfter(key, rollingRestartCheckInterval)
+		return nil
+	}
+
+	outdated, ready, err := c.getOutdatedPods(elasticsearch)
+	if err != nil {
+		return err
+	}
+	_, restarting := c.rollingRestarts.Load(key)
+	// nodes that are not ready without a pending restart are reported by the health checker
+	pending := len(outdated) > 0 || restarting
+	if !ready {
+		if !pending {
+			return nil
+		}
+		if err := c.checkUpgradeFailure(elasticsearch); err != nil {
+			return err
+		}
+		return requeue()
+	}
+	if isConditionTrue(elasticsearch.Status.Conditions, api.ElasticsearchConditionUpgradeFailed) {
+		if err := c.setUpgradeFailedCondition(elasticsearch, false, "NodeStarted", "upgraded node is ready"); err != nil {
+			return err
+		}
+	}
+	if !pending {
+		return nil
+	}
+	if clusterReady, reason := c.checkClusterReady(elasticsearch); !clusterReady {
+		log.Infof("Elasticsearch %v is waiting for nodes to rejoin. Reason: %v", key, reason)
+		return requeue()
+	}
+
+	client, err := c.getElasticClient(elasticsearch)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	settings, err := client.GetClusterSettings()
+	if err != nil {
+		return err
+	}
+	allocationLimited := settings.Transient[es.SettingAllocationEnable] == es.AllocationEnablePrimaries
+	if limitedByOperator, _ := c.rollingRestarts.Load(key); limitedByOperator == true && allocationLimited {
+		if err := client.UpdateClusterSettings(map[string]interface{}{
+			es.SettingAllocationEnable: nil,
+		}); err != nil {
+			return err
+		}
+		c.rollingRestarts.Store(key, false)
+		c.recorder.Event(elasticsearch, core.EventTypeNormal, eventer.EventReasonSuccessful, "Re-enabled shard allocation")
+		return requeue()
+	}
+
+	health, err := client.GetClusterHealth()
+	if err != nil {
+		return err
+	}
+	// A cluster that can not allocate all replicas stays yellow, so do not wait for green
+	// once no shard is recovering anymore.
+	recovered := health.Status == es.HealthStatusGreen ||
+		(health.Status == es.HealthStatusYellow && health.InitializingShards == 0 && health.RelocatingShards == 0)
+	if !recovered {
+		return requeue()
+	}
+
+	if len(outdated) == 0 {
+		c.rollingRestarts.Delete(key)
+		c.recorder.Event(elasticsearch, core.EventTypeNormal, eventer.EventReasonSuccessful, "Successfully completed rolling restart")
+		return nil
+	}
+
+	pod := outdated[0]
+	if !allocationLimited {
+		if err := client.UpdateClusterSettings(map[string]interface{}{
+			es.SettingAllocationEnable: es.AllocationEnablePrimaries,
+		}); err != nil {
+			return err
+		}
+	}
+	c.rollingRestarts.Store(key, !allocationLimited)
+	if !restarting {
+		c.recorder.Eventf(elasticsearch, core.EventTypeNormal, eventer.EventReasonStarting, "Starting rolling restart of %d pods", len(outdated))
+	}
+	// synced flush fails for shards with ongoing indexing. Those just recover slower.
+	if err := client.SyncedFlush(); err != nil {
+		log.Warningf("synced flush of Elasticsearch %v failed. Reason: %v", key, err)
+	}
+	if err := c.Client.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{}); err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	c.recorder.Eventf(elasticsearch, core.EventTypeNormal, eventer.EventReasonStarting, "Restarting pod %s", pod.Name)
+	return requeue()
+}
+
+// getOutdatedPods returns the pods that are not on the update revision of their StatefulSet,
+// in the order they should be restarted. The update revision is only known once the StatefulSet
+// controller observed the current generation, so until then all its pods count as outdated.
+// It also reports whether all pods are up and ready.
+func (c *Controller) getOutdatedPods(elasticsearch *api.Elasticsearch) ([]core.Pod, bool, error) {
+	var outdated []core.Pod
+	allReady := true
+	for _, name := range nodeStatefulSetNames(elasticsearch) {
+		sts, err := c.Client.AppsV1().StatefulSets(elasticsearch.Namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, false, err
+		}
+		observed := sts.Status.ObservedGeneration >= sts.Generation
+
+		pods, err := c.getStatefulSetPods(sts)
+		if err != nil {
+			return nil, false, err
+		}
+		if !observed || (sts.Spec.Replicas != nil && len(pods) != int(*sts.Spec.Replicas)) {
+			allReady = false
+		}
+
+		var stsOutdated []core.Pod
+		for _, pod := range pods {
+			if ready, _ := core_util.PodRunningAndReady(pod); !ready || pod.DeletionTimestamp != nil {
+				allReady = false
+			}
+			if !observed || pod.Labels[apps.StatefulSetRevisionLabel] != sts.Status.UpdateRevision {
+				stsOutdated = append(stsOutdated, pod)
+			}
+		}
+		// restart the highest ordinal first, like the StatefulSet controller does
+		sort.Slice(stsOutdated, func(i, j int) bool {
+			return podOrdinal(stsOutdated[i].Name) > podOrdinal(stsOutdated[j].Name)
+		})
+		outdated = append(outdated, stsOutdated...)
+	}
+	return outdated, allReady, nil
+}
+
+func (c *Controller) getStatefulSetPods(sts *apps.StatefulSet) ([]core.Pod, error) {
+	selector, err := metav1.LabelSelectorAsSelector(sts.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := c.Client.CoreV1().Pods(sts.Namespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+func podOrdinal(name string) int {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return -1
+	}
+	ordinal, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return -1
+	}
+	return ordinal
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/appscode/go/types"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	amc "kubedb.dev/apimachinery/pkg/controller"
+)
+
+func TestGetOutdatedPods(t *testing.T) {
+	elasticsearch := &api.Elasticsearch{
+		ObjectMeta: metav1.ObjectMeta{Name: "es", Namespace: "demo"},
+		Spec:       api.ElasticsearchSpec{Replicas: types.Int32P(3)},
+	}
+	labels := map[string]string{"app": "es"}
+	statefulSet := func(observedGeneration int64) *apps.StatefulSet {
+		return &apps.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "es", Namespace: "demo", Generation: 2},
+			Spec: apps.StatefulSetSpec{
+				Replicas: types.Int32P(3),
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+			},
+			Status: apps.StatefulSetStatus{ObservedGeneration: observedGeneration, UpdateRevision: "es-2"},
+		}
+	}
+	pod := func(name, revision string, ready bool) *core.Pod {
+		status := core.ConditionFalse
+		if ready {
+			status = core.ConditionTrue
+		}
+		return &core.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "demo",
+				Labels:    map[string]string{"app": "es", apps.StatefulSetRevisionLabel: revision},
+			},
+			Status: core.PodStatus{
+				Phase:      core.PodRunning,
+				Conditions: []core.PodCondition{{Type: core.PodReady, Status: status}},
+			},
+		}
+	}
+
+	cases := []struct {
+		name     string
+		objects  []runtime.Object
+		outdated []string
+		ready    bool
+	}{
+		{
+			name:    "up to date",
+			objects: []runtime.Object{statefulSet(2), pod("es-0", "es-2", true), pod("es-1", "es-2", true), pod("es-2", "es-2", true)},
+			ready:   true,
+		},
+		{
+			name:    "pod not ready without a pending restart",
+			objects: []runtime.Object{statefulSet(2), pod("es-0", "es-2", true), pod("es-1", "es-2", false), pod("es-2", "es-2", true)},
+		},
+		{
+			name:     "outdated pods",
+			objects:  []runtime.Object{statefulSet(2), pod("es-0", "es-1", true), pod("es-1", "es-2", false), pod("es-2", "es-1", true)},
+			outdated: []string{"es-2", "es-0"},
+		},
+		{
+			name:     "generation not observed",
+			objects:  []runtime.Object{statefulSet(1), pod("es-0", "es-2", true), pod("es-1", "es-2", true), pod("es-2", "es-2", true)},
+			outdated: []string{"es-2", "es-1", "es-0"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Controller{Controller: &amc.Controller{Client: fake.NewSimpleClientset(tc.objects...)}}
+			outdated, ready, err := c.getOutdatedPods(elasticsearch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, pod := range outdated {
+				names = append(names, pod.Name)
+			}
+			if !reflect.DeepEqual(names, tc.outdated) {
+				t.Errorf("expected outdated pods %v, got %v", tc.outdated, names)
+			}
+			if ready != tc.ready {
+				t.Errorf("expected ready to be %v, got %v", tc.ready, ready)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/appscode/go/types"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"kubedb.dev/apimachinery/apis"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	"kubedb.dev/apimachinery/pkg/eventer"
+	"kubedb.dev/elasticsearch/pkg/util/es"
+)
+
+const (
+	drainCheckInterval = 15 * time.Second
+	// drainStallTimeout is how long shards may stay on departing nodes, while no shard
+	// is moving, before the drain is reported as stalled.
+	drainStallTimeout = 5 * time.Minute
+	// drainTimeout is how long a drain may take at most, before it is reported as stalled.
+	drainTimeout = time.Hour
+)
+
+// ensureSafeScaleDown returns the number of replicas a data node StatefulSet can be scaled to.
+// Nodes that are removed by a scale down are excluded from shard allocation first, and the
+// StatefulSet keeps its current replicas until they hold no shards anymore. Once scaled down,
+// the exclusion of those nodes is cleared.
+func (c *Controller) ensureSafeScaleDown(elasticsearch *api.Elasticsearch, statefulSetName string, replicas int32) (int32, error) {
+	// shards can only be drained from a running cluster
+	if elasticsearch.Status.Phase != api.DatabasePhaseRunning {
+		return replicas, nil
+	}
+
+	sts, err := c.Client.AppsV1().StatefulSets(elasticsearch.Namespace).Get(statefulSetName, metav1.GetOptions{})
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			return replicas, nil
+		}
+		return 0, err
+	}
+	current := types.Int32(sts.Spec.Replicas)
+
+	var departing []string
+	for i := replicas; i < current; i++ {
+		departing = append(departing, fmt.Sprintf("%s-%d", statefulSetName, i))
+	}
+
+	client, err := c.getElasticClient(elasticsearch)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Stop()
+
+	settings, err := client.GetClusterSettings()
+	if err != nil {
+		return 0, err
+	}
+	var excluded []string
+	if v, ok := settings.Transient[es.SettingAllocationExcludeName].(string); ok && v != "" {
+		excluded = strings.Split(v, ",")
+	}
+	// keep exclusions of other nodes and replace the ones of this StatefulSet
+	exclude := make([]string, 0, len(excluded)+len(departing))
+	for _, name := range excluded {
+		if !isPodOf(name, statefulSetName) {
+			exclude = append(exclude, name)
+		}
+	}
+	exclude = append(exclude, departing...)
+	if strings.Join(exclude, ",") != strings.Join(excluded, ",") {
+		var value interface{}
+		if len(exclude) > 0 {
+			value = strings.Join(exclude, ",")
+		}
+		if err := client.UpdateClusterSettings(map[string]interface{}{
+			es.SettingAllocationExcludeName: value,
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(departing) == 0 {
+		return replicas, nil
+	}
+
+	shards, err := client.GetShardCountByNode()
+	if err != nil {
+		return 0, err
+	}
+	remaining := 0
+	for _, name := range departing {
+		remaining += shards[name]
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(elasticsearch)
+	if err != nil {
+		return 0, err
+	}
+	// requeue to either wait for the drain or clear the exclusion after scaling down
+	c.esQueue.GetQueue().AddAfter(key, drainCheckInterval)
+
+	if remaining > 0 {
+		health, err := client.GetClusterHealth()
+		if err != nil {
+			return 0, err
+		}
+		if stalled, reason := drainStalled(elasticsearch, health); stalled {
+			msg := fmt.Sprintf("%d shards can't be moved off nodes %v, so StatefulSet %s is not scaled down to %d replicas. Reason: %s. "+
+				"Add data nodes, or lower the number of replicas of the indices", remaining, departing, statefulSetName, replicas, reason)
+			if cond := getCondition(elasticsearch.Status.Conditions, api.ElasticsearchConditionDraining); cond == nil || cond.Reason != "DrainStalled" {
+				c.recorder.Event(elasticsearch, core.EventTypeWarning, eventer.EventReasonFailedToUpdate, msg)
+			}
+			return current, c.setDrainingCondition(elasticsearch, true, "DrainStalled", msg)
+		}
+		msg := fmt.Sprintf("moving %d shards off nodes %v before scaling StatefulSet %s down to %d replicas", remaining, departing, statefulSetName, replicas)
+		return current, c.setDrainingCondition(elasticsearch, true, "ShardsRelocating", msg)
+	}
+
+	msg := fmt.Sprintf("nodes %v hold no shards, scaling StatefulSet %s down to %d replicas", departing, statefulSetName, replicas)
+	c.recorder.Event(elasticsearch, core.EventTypeNormal, eventer.EventReasonSuccessful, msg)
+	return replicas, c.setDrainingCondition(elasticsearch, false, "DrainCompleted", msg)
+}
+
+// drainStalled reports whether shards are not moving off departing nodes anymore. Shards that
+// can't be allocated elsewhere, eg, replicas without a node left to hold them, stay forever.
+func drainStalled(elasticsearch *api.Elasticsearch, health *es.ClusterHealth) (bool, string) {
+	cond := getCondition(elasticsearch.Status.Conditions, api.ElasticsearchConditionDraining)
+	if cond == nil || cond.Status != core.ConditionTrue {
+		return false, ""
+	}
+	draining := time.Since(cond.LastTransitionTime.Time)
+	if draining > drainTimeout {
+		return true, fmt.Sprintf("draining for more than %v", drainTimeout)
+	}
+	if draining > drainStallTimeout && health.RelocatingShards == 0 && health.InitializingShards == 0 {
+		return true, fmt.Sprintf("no shard moved for %v", drainStallTimeout)
+	}
+	return false, ""
+}
+
+func (c *Controller) setDrainingCondition(elasticsearch *api.Elasticsearch, draining bool, reason, message string) error {
+	_, err := util.UpdateElasticsearchStatus(c.ExtClient.KubedbV1alpha1(), elasticsearch, func(in *api.ElasticsearchStatus) *api.ElasticsearchStatus {
+		in.Conditions = setCondition(in.Conditions, newCondition(api.ElasticsearchConditionDraining, draining, reason, message))
+		return in
+	}, apis.EnableStatusSubresource)
+	return err
+}
+
+// isPodOf reports whether the pod or node name belongs to the StatefulSet.
+func isPodOf(name, statefulSetName string) bool {
+	i := strings.LastIndex(name, "-")
+	return i > 0 && name[:i] == statefulSetName && podOrdinal(name) >= 0
+}
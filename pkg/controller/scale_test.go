@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/elasticsearch/pkg/util/es"
+)
+
+func TestDrainStalled(t *testing.T) {
+	drainingFor := func(d time.Duration) *api.Elasticsearch {
+		return &api.Elasticsearch{
+			Status: api.ElasticsearchStatus{
+				Conditions: []api.ElasticsearchCondition{
+					{
+						Type:               api.ElasticsearchConditionDraining,
+						Status:             core.ConditionTrue,
+						LastTransitionTime: metav1.NewTime(time.Now().Add(-d)),
+					},
+				},
+			},
+		}
+	}
+	moving := &es.ClusterHealth{RelocatingShards: 1}
+	idle := &es.ClusterHealth{}
+
+	cases := []struct {
+		name          string
+		elasticsearch *api.Elasticsearch
+		health        *es.ClusterHealth
+		stalled       bool
+	}{
+		{"not draining yet", &api.Elasticsearch{}, idle, false},
+		{"just started", drainingFor(time.Minute), idle, false},
+		{"shards moving", drainingFor(10 * time.Minute), moving, false},
+		{"no shard moving", drainingFor(10 * time.Minute), idle, true},
+		{"deadline passed", drainingFor(2 * time.Hour), moving, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if stalled, reason := drainStalled(c.elasticsearch, c.health); stalled != c.stalled {
+				t.Errorf("expected stalled to be %v, got %v (%s)", c.stalled, stalled, reason)
+			}
+		})
+	}
+}
@@ -1,12 +1,14 @@
 package controller
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/appscode/go/crypto/rand"
+	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
 	"gomodules.xyz/cert"
 	core "k8s.io/api/core/v1"
@@ -15,19 +17,30 @@ import (
 	core_util "kmodules.xyz/client-go/core/v1"
 	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
 	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	"kubedb.dev/elasticsearch/pkg/keytool"
 )
 
 const (
 	AdminUser          = "admin"
+	ElasticUser        = "elastic"
 	KeyAdminUserName   = "ADMIN_USERNAME"
 	KeyAdminPassword   = "ADMIN_PASSWORD"
 	ReadAllUser        = "readall"
 	KeyReadAllUserName = "READALL_USERNAME"
 	KeyReadAllPassword = "READALL_PASSWORD"
 	ExporterSecretPath = "/var/run/secrets/kubedb.com/"
+
+	// KeyAdminPasswordPending and KeyReadAllPasswordPending hold the new passwords while they are rotated
+	KeyAdminPasswordPending   = "ADMIN_PASSWORD_PENDING"
+	KeyReadAllPasswordPending = "READALL_PASSWORD_PENDING"
 )
 
 func (c *Controller) ensureCertSecret(elasticsearch *api.Elasticsearch) error {
+	if usesCertManager(elasticsearch) {
+		// the certificate secret is created from the certificates issued by cert-manager
+		return nil
+	}
+
 	certSecretVolumeSource := elasticsearch.Spec.CertificateSecret
 	if certSecretVolumeSource == nil {
 		var err error
@@ -43,7 +56,59 @@ func (c *Controller) ensureCertSecret(elasticsearch *api.Elasticsearch) error {
 		}
 		elasticsearch.Spec.CertificateSecret = es.Spec.CertificateSecret
 	}
-	return nil
+	if err := c.ensureKeyStoreFormats(elasticsearch); err != nil {
+		return err
+	}
+	if err := c.ensureNodeCertificateSANs(elasticsearch); err != nil {
+		return err
+	}
+	return c.ensureClientCertificateSANs(elasticsearch)
+}
+
+// ensureKeyStoreFormats adds the PKCS#12 and PEM encoded keystores to a certificate secret,
+// that only holds JKS keystores, eg, one created by an older version of the operator.
+func (c *Controller) ensureKeyStoreFormats(elasticsearch *api.Elasticsearch) error {
+	secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(elasticsearch.Spec.CertificateSecret.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	pass := string(secret.Data["key_pass"])
+
+	data := make(map[string][]byte)
+	for _, files := range []identityFiles{nodeFiles, sgAdminFiles, clientFiles} {
+		jksData, ok := secret.Data[files.keyStore]
+		if !ok {
+			continue
+		}
+		if _, ok := secret.Data[files.pkcs12]; ok {
+			continue
+		}
+		pfxData, err := keytool.JKSToPKCS12(jksData, pass, files.alias)
+		if err != nil {
+			return fmt.Errorf("failed to convert %s to %s. Reason: %v", files.keyStore, files.pkcs12, errors.Cause(err))
+		}
+		if err := storeKeyStores(data, files, pfxData, pass); err != nil {
+			return err
+		}
+	}
+	if _, ok := secret.Data[rootCert]; !ok {
+		caCert, err := keytool.JKSCertificate(secret.Data[rootKeyStore], pass, rootAlias)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read CA certificate from %s", rootKeyStore)
+		}
+		data[rootCert] = cert.EncodeCertPEM(caCert)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	_, _, err = core_util.PatchSecret(c.Client, secret, func(in *core.Secret) *core.Secret {
+		for k, v := range data {
+			in.Data[k] = v
+		}
+		return in
+	})
+	return err
 }
 
 func (c *Controller) ensureDatabaseSecret(elasticsearch *api.Elasticsearch) error {
@@ -97,53 +162,40 @@ func (c *Controller) createCertSecret(elasticsearch *api.Elasticsearch) (*core.S
 		}, nil
 	}
 
-	certPath := fmt.Sprintf("%v/%v", certsDir, rand.Characters(3))
-	os.Mkdir(certPath, os.ModePerm)
+	data := make(map[string][]byte)
+	pass := rand.Characters(6)
 
-	caKey, caCert, pass, err := createCaCertificate(certPath)
-	if err != nil {
-		return nil, err
-	}
-	err = createNodeCertificate(certPath, elasticsearch, caKey, caCert, pass)
+	caKey, caCerts, err := c.getIssuingCA(elasticsearch)
 	if err != nil {
 		return nil, err
 	}
-	err = createAdminCertificate(certPath, caKey, caCert, pass)
-	if err != nil {
-		return nil, err
+	if caKey == nil {
+		var caCert *x509.Certificate
+		if caKey, caCert, err = createCaCertificate(data, elasticsearch); err != nil {
+			return nil, err
+		}
+		caCerts = []*x509.Certificate{caCert}
 	}
-	root, err := ioutil.ReadFile(filepath.Join(certPath, rootKeyStore))
+
+	err = createTrustStore(data, caCerts, pass)
 	if err != nil {
 		return nil, err
 	}
-	node, err := ioutil.ReadFile(filepath.Join(certPath, nodeKeyStore))
+	err = createNodeCertificate(data, elasticsearch, c.nodeDNSNames(elasticsearch), caKey, caCerts, pass)
 	if err != nil {
 		return nil, err
 	}
-	sgadmin, err := ioutil.ReadFile(filepath.Join(certPath, sgAdminKeyStore))
+	err = createAdminCertificate(data, elasticsearch, caKey, caCerts, pass)
 	if err != nil {
 		return nil, err
 	}
 
-	data := map[string][]byte{
-		rootKeyStore:    root,
-		nodeKeyStore:    node,
-		sgAdminKeyStore: sgadmin,
-	}
-
 	if elasticsearch.Spec.EnableSSL {
-		if err := createClientCertificate(certPath, elasticsearch, caKey, caCert, pass); err != nil {
+		if err := createClientCertificate(data, elasticsearch, caKey, caCerts, pass); err != nil {
 			return nil, err
 		}
-
-		client, err := ioutil.ReadFile(filepath.Join(certPath, clientKeyStore))
-		if err != nil {
-			return nil, err
-		}
-
-		data[rootCert] = cert.EncodeCertPEM(caCert)
-		data[clientKeyStore] = client
 	}
+	data[rootCert] = encodeCertsPEM(caCerts)
 
 	name := fmt.Sprintf("%v-cert", elasticsearch.OffshootName())
 	secret := &core.Secret{
@@ -214,8 +266,9 @@ INDICES_KUBEDB_SNAPSHOT:
   - "indices:admin/mappings/get"
 `
 
+// config is the config.yml of the security plugin, with the root key of the plugin as argument.
 var config = `
-searchguard:
+%s:
   dynamic:
     authc:
       basic_internal_auth_domain:
@@ -226,6 +279,25 @@ searchguard:
           challenge: true
         authentication_backend:
           type: internal
+      clientcert_auth_domain:
+        enabled: true
+        order: 2
+        http_authenticator:
+          type: clientcert
+          challenge: false
+        authentication_backend:
+          type: noop
+`
+
+// clientCertAuthConfig authenticates requests by the distinguished name of their client certificate.
+var clientCertAuthConfig = `
+enabled: true
+order: 2
+http_authenticator:
+  type: clientcert
+  challenge: false
+authentication_backend:
+  type: noop
 `
 
 var internal_user = `
@@ -236,8 +308,9 @@ readall:
   hash: %s
 `
 
+// roles and roles_mapping take the prefix of the built-in roles of the security plugin.
 var roles = `
-sg_all_access:
+%[1]sall_access:
   cluster:
     - UNLIMITED
   indices:
@@ -248,7 +321,7 @@ sg_all_access:
     adm_tenant: RW
     test_tenant_ro: RW
 
-sg_readall:
+%[1]sreadall:
   cluster:
     - CLUSTER_COMPOSITE_OPS_RO
     - CLUSTER_KUBEDB_SNAPSHOT
@@ -260,11 +333,11 @@ sg_readall:
 `
 
 var roles_mapping = `
-sg_all_access:
+%[1]sall_access:
   users:
     - admin
 
-sg_readall:
+%[1]sreadall:
   users:
     - readall
 `
@@ -280,6 +353,16 @@ func (c *Controller) createDatabaseSecret(elasticsearch *api.Elasticsearch) (*co
 		}, nil
 	}
 
+	name := fmt.Sprintf("%v-auth", elasticsearch.OffshootName())
+	if elasticsearch.Spec.AuthPlugin == api.ElasticsearchAuthPluginXpack {
+		// X-Pack stores its users in the cluster, the password bootstraps the elastic user
+		data := map[string][]byte{
+			KeyAdminUserName: []byte(ElasticUser),
+			KeyAdminPassword: []byte(rand.Characters(8)),
+		}
+		return c.createAuthSecret(elasticsearch, name, data)
+	}
+
 	adminPassword := rand.Characters(8)
 	hashedAdminPassword, err := bcrypt.GenerateFromPassword([]byte(adminPassword), bcrypt.DefaultCost)
 	if err != nil {
@@ -292,19 +375,22 @@ func (c *Controller) createDatabaseSecret(elasticsearch *api.Elasticsearch) (*co
 		return nil, err
 	}
 
+	plugin := searchGuardPlugin
 	data := map[string][]byte{
-		KeyAdminUserName:        []byte(AdminUser),
-		KeyAdminPassword:        []byte(adminPassword),
-		KeyReadAllUserName:      []byte(ReadAllUser),
-		KeyReadAllPassword:      []byte(readallPassword),
-		"sg_action_groups.yml":  []byte(action_group),
-		"sg_config.yml":         []byte(config),
-		"sg_internal_users.yml": []byte(fmt.Sprintf(internal_user, hashedAdminPassword, hashedReadallPassword)),
-		"sg_roles.yml":          []byte(roles),
-		"sg_roles_mapping.yml":  []byte(roles_mapping),
+		KeyAdminUserName:                   []byte(AdminUser),
+		KeyAdminPassword:                   []byte(adminPassword),
+		KeyReadAllUserName:                 []byte(ReadAllUser),
+		KeyReadAllPassword:                 []byte(readallPassword),
+		plugin.file(securityActionGroups):  []byte(action_group),
+		plugin.file(securityConfig):        []byte(fmt.Sprintf(config, plugin.configRoot)),
+		plugin.file(securityInternalUsers): []byte(fmt.Sprintf(internal_user, hashedAdminPassword, hashedReadallPassword)),
+		plugin.file(securityRoles):         []byte(fmt.Sprintf(roles, plugin.rolePrefix)),
+		plugin.file(securityRolesMapping):  []byte(fmt.Sprintf(roles_mapping, plugin.rolePrefix)),
 	}
+	return c.createAuthSecret(elasticsearch, name, data)
+}
 
-	name := fmt.Sprintf("%v-auth", elasticsearch.OffshootName())
+func (c *Controller) createAuthSecret(elasticsearch *api.Elasticsearch, name string, data map[string][]byte) (*core.SecretVolumeSource, error) {
 	secret := &core.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:   name,
@@ -322,6 +408,36 @@ func (c *Controller) createDatabaseSecret(elasticsearch *api.Elasticsearch) (*co
 	}, nil
 }
 
+const sgConfigRevisionPrefix = "# revision: "
+
+// setSecurityConfigRevision records the digest of the other config files of the security plugin
+// in its internal users file. fsloader only watches the internal users file and runs sgadmin,
+// when it changes, so the nodes load the changed config files.
+func setSecurityConfigRevision(plugin securityPlugin, data map[string][]byte) {
+	usersFile := plugin.file(securityInternalUsers)
+	var keys []string
+	for _, name := range []string{securityActionGroups, securityConfig, securityRoles, securityRolesMapping} {
+		if _, ok := data[plugin.file(name)]; ok {
+			keys = append(keys, plugin.file(name))
+		}
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write(data[k])
+	}
+
+	users := string(data[usersFile])
+	if strings.HasPrefix(users, sgConfigRevisionPrefix) {
+		if i := strings.Index(users, "\n"); i >= 0 {
+			users = users[i+1:]
+		} else {
+			users = ""
+		}
+	}
+	data[usersFile] = []byte(fmt.Sprintf("%s%x\n%s", sgConfigRevisionPrefix, h.Sum(nil), users))
+}
+
 // This is done to fix 0.8.0 -> 0.9.0 upgrade due to
 // https://github.com/kubedb/elasticsearch/pull/181/files#diff-10ddaf307bbebafda149db10a28b9c24R23 commit
 func (c *Controller) upgradeDatabaseSecret(elasticsearch *api.Elasticsearch) error {
@@ -332,6 +448,12 @@ func (c *Controller) upgradeDatabaseSecret(elasticsearch *api.Elasticsearch) err
 
 	_, _, err := core_util.CreateOrPatchSecret(c.Client, meta, func(in *core.Secret) *core.Secret {
 		in.StringData = make(map[string]string)
+		if elasticsearch.Spec.AuthPlugin == api.ElasticsearchAuthPluginXpack {
+			if _, ok := in.Data[KeyAdminUserName]; !ok {
+				in.StringData[KeyAdminUserName] = ElasticUser
+			}
+			return in
+		}
 		if _, ok := in.Data[KeyAdminUserName]; !ok {
 			in.StringData[KeyAdminUserName] = AdminUser
 		}
@@ -0,0 +1,353 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	core_util "kmodules.xyz/client-go/core/v1"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+// AnnotationSecurityConfig is set on the database secret to the users, roles and role mappings
+// the operator added to the config files of the security plugin. Only these are removed, when
+// they are removed from the spec, so changes made to the files by hand are kept.
+const AnnotationSecurityConfig = "elasticsearch.kubedb.com/security-config"
+
+// managedSecurityConfig is the content of AnnotationSecurityConfig.
+type managedSecurityConfig struct {
+	Users        []string               `json:"users,omitempty"`
+	Roles        []string               `json:"roles,omitempty"`
+	RoleMappings map[string]roleMapping `json:"roleMappings,omitempty"`
+}
+
+// roleMapping are the users and backend roles mapped to a role.
+type roleMapping struct {
+	Users        []string `json:"users,omitempty"`
+	BackendRoles []string `json:"backendroles,omitempty"`
+}
+
+// ensureSecurityConfig renders the users, roles, role mappings and LDAP domain of spec.security,
+// and the role mappings of the client certificates, into the config files of the security plugin
+// in the database secret. Passwords are stored as bcrypt hashes. The hash of a user is kept while its
+// password is unchanged, so the files only change with the spec or the password secrets.
+// fsloader pushes the changed files to the running cluster with sgadmin, without a restart.
+func (c *Controller) ensureSecurityConfig(elasticsearch *api.Elasticsearch) error {
+	if !usesSecurityPlugin(elasticsearch) || elasticsearch.Spec.DatabaseSecret == nil {
+		return nil
+	}
+	plugin := searchGuardPlugin
+
+	secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(elasticsearch.Spec.DatabaseSecret.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	var managed managedSecurityConfig
+	if v, ok := secret.Annotations[AnnotationSecurityConfig]; ok {
+		if err := json.Unmarshal([]byte(v), &managed); err != nil {
+			return errors.Wrapf(err, "failed to parse annotation %s of secret %s", AnnotationSecurityConfig, secret.Name)
+		}
+	}
+
+	spec := elasticsearch.Spec.Security
+	if spec == nil {
+		spec = &api.ElasticsearchSecuritySpec{}
+	}
+	desired := managedSecurityConfig{
+		RoleMappings: make(map[string]roleMapping),
+	}
+	for _, m := range spec.RoleMappings {
+		desired.RoleMappings[m.Role] = roleMapping{
+			Users:        m.Users,
+			BackendRoles: m.BackendRoles,
+		}
+	}
+	for _, cc := range clientCertificates(elasticsearch) {
+		for _, role := range cc.Roles {
+			m := desired.RoleMappings[role]
+			m.Users = append(m.Users, clientCertificateDN(elasticsearch, cc.Name))
+			desired.RoleMappings[role] = m
+		}
+	}
+
+	data := make(map[string][]byte)
+	for k, v := range secret.Data {
+		data[k] = v
+	}
+
+	usersFile := plugin.file(securityInternalUsers)
+	if len(managed.Users) > 0 || len(spec.Users) > 0 {
+		users, err := parseSecurityFile(data[usersFile])
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse %s of secret %s", usersFile, secret.Name)
+		}
+		for _, name := range managed.Users {
+			delete(users, name)
+		}
+		for _, u := range spec.Users {
+			entry, err := c.internalUser(elasticsearch, u, secret.Data[usersFile])
+			if err != nil {
+				return err
+			}
+			users[u.Name] = entry
+			desired.Users = append(desired.Users, u.Name)
+		}
+		if data[usersFile], err = marshalSecurityFile(data[usersFile], users); err != nil {
+			return err
+		}
+	}
+
+	rolesFile := plugin.file(securityRoles)
+	if len(managed.Roles) > 0 || len(spec.Roles) > 0 {
+		roles, err := parseSecurityFile(data[rolesFile])
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse %s of secret %s", rolesFile, secret.Name)
+		}
+		for _, name := range managed.Roles {
+			delete(roles, name)
+		}
+		for _, r := range spec.Roles {
+			roles[r.Name] = renderRole(r)
+			desired.Roles = append(desired.Roles, r.Name)
+		}
+		if data[rolesFile], err = marshalSecurityFile(data[rolesFile], roles); err != nil {
+			return err
+		}
+	}
+
+	rolesMappingFile := plugin.file(securityRolesMapping)
+	if len(managed.RoleMappings) > 0 || len(desired.RoleMappings) > 0 {
+		if data[rolesMappingFile], err = updateRolesMapping(data[rolesMappingFile], managed.RoleMappings, desired.RoleMappings); err != nil {
+			return errors.Wrapf(err, "failed to update %s of secret %s", rolesMappingFile, secret.Name)
+		}
+	}
+
+	configFile := plugin.file(securityConfig)
+	if len(clientCertificates(elasticsearch)) > 0 {
+		if data[configFile], err = enableClientCertAuth(data[configFile], plugin.configRoot); err != nil {
+			return errors.Wrapf(err, "failed to update %s of secret %s", configFile, secret.Name)
+		}
+	}
+	if data[configFile], err = c.updateLDAPAuthDomain(elasticsearch, data[configFile], plugin.configRoot); err != nil {
+		return errors.Wrapf(err, "failed to update %s of secret %s", configFile, secret.Name)
+	}
+
+	sort.Strings(desired.Users)
+	sort.Strings(desired.Roles)
+	if len(desired.RoleMappings) == 0 {
+		desired.RoleMappings = nil
+	}
+	annotation, err := json.Marshal(desired)
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(data, secret.Data) && string(annotation) == secret.Annotations[AnnotationSecurityConfig] {
+		return nil
+	}
+
+	_, _, err = core_util.PatchSecret(c.Client, secret, func(in *core.Secret) *core.Secret {
+		in.Annotations = core_util.UpsertMap(in.Annotations, map[string]string{
+			AnnotationSecurityConfig: string(annotation),
+		})
+		in.Data = data
+		setSecurityConfigRevision(plugin, in.Data)
+		return in
+	})
+	return err
+}
+
+// securitySecrets returns the names of the secrets, that ensureSecurityConfig renders into
+// the config files of the security plugin.
+func securitySecrets(elasticsearch *api.Elasticsearch) sets.String {
+	names := sets.NewString()
+	if !usesSecurityPlugin(elasticsearch) || elasticsearch.Spec.Security == nil {
+		return names
+	}
+	for _, u := range elasticsearch.Spec.Security.Users {
+		names.Insert(u.PasswordSecret.Name)
+	}
+	if ldap := elasticsearch.Spec.Security.LDAP; ldap != nil {
+		if ldap.BindSecret != nil {
+			names.Insert(ldap.BindSecret.Name)
+		}
+		if ldap.TLS != nil && ldap.TLS.CASecret != nil {
+			names.Insert(ldap.TLS.CASecret.Name)
+		}
+	}
+	return names
+}
+
+// internalUser returns the entry of a user in the internal users file. The hash in current is
+// kept, if it still matches the password of the user.
+func (c *Controller) internalUser(elasticsearch *api.Elasticsearch, user api.ElasticsearchUser, current []byte) (map[string]interface{}, error) {
+	ref := user.PasswordSecret
+	secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get password secret of user %s", user.Name)
+	}
+	password, ok := secret.Data[ref.Key]
+	if !ok || len(password) == 0 {
+		return nil, fmt.Errorf("secret %s has no password for user %s in key %s", ref.Name, user.Name, ref.Key)
+	}
+
+	var hash string
+	if users, err := parseSecurityFile(current); err == nil {
+		if entry, ok := users[user.Name].(map[string]interface{}); ok {
+			hash, _ = entry["hash"].(string)
+		}
+	}
+	if hash == "" || bcrypt.CompareHashAndPassword([]byte(hash), password) != nil {
+		h, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		hash = string(h)
+	}
+
+	entry := map[string]interface{}{
+		"hash": hash,
+	}
+	if len(user.BackendRoles) > 0 {
+		entry["roles"] = toInterfaceSlice(user.BackendRoles)
+	}
+	return entry, nil
+}
+
+// renderRole returns the entry of a role in the roles file. The permissions of an index pattern
+// apply to all document types.
+func renderRole(role api.ElasticsearchRole) map[string]interface{} {
+	entry := make(map[string]interface{})
+	if len(role.Cluster) > 0 {
+		entry["cluster"] = toInterfaceSlice(role.Cluster)
+	}
+	if len(role.Indices) > 0 {
+		indices := make(map[string]interface{})
+		for _, index := range role.Indices {
+			indices[index.Index] = map[string]interface{}{
+				"*": toInterfaceSlice(index.Permissions),
+			}
+		}
+		entry["indices"] = indices
+	}
+	return entry
+}
+
+// updateRolesMapping removes the users and backend roles in unmap from the roles mapping, and
+// adds the ones in mappings.
+func updateRolesMapping(data []byte, unmap, mappings map[string]roleMapping) ([]byte, error) {
+	mapping, err := parseSecurityFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for role, m := range unmap {
+		entry, ok := mapping[role].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		removeValues(entry, "users", m.Users)
+		removeValues(entry, "backendroles", m.BackendRoles)
+		if len(entry) == 0 {
+			delete(mapping, role)
+		}
+	}
+
+	for role, m := range mappings {
+		entry, ok := mapping[role].(map[string]interface{})
+		if !ok {
+			entry = make(map[string]interface{})
+			mapping[role] = entry
+		}
+		addValues(entry, "users", m.Users)
+		addValues(entry, "backendroles", m.BackendRoles)
+	}
+	return marshalSecurityFile(data, mapping)
+}
+
+func removeValues(entry map[string]interface{}, key string, values []string) {
+	existing, ok := entry[key].([]interface{})
+	if !ok || len(values) == 0 {
+		return
+	}
+	remove := sets.NewString(values...)
+	var keep []interface{}
+	for _, v := range existing {
+		if s, ok := v.(string); ok && remove.Has(s) {
+			continue
+		}
+		keep = append(keep, v)
+	}
+	if len(keep) > 0 {
+		entry[key] = keep
+	} else {
+		delete(entry, key)
+	}
+}
+
+func addValues(entry map[string]interface{}, key string, values []string) {
+	existing, _ := entry[key].([]interface{})
+	for _, v := range values {
+		found := false
+		for _, e := range existing {
+			if e == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			existing = append(existing, v)
+		}
+	}
+	if len(existing) > 0 {
+		entry[key] = existing
+	}
+}
+
+func parseSecurityFile(data []byte) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = make(map[string]interface{})
+	}
+	return out, nil
+}
+
+// marshalSecurityFile returns the YAML of value. It returns current, if it already holds the
+// same content, so files written by hand are not reformatted.
+func marshalSecurityFile(current []byte, value map[string]interface{}) ([]byte, error) {
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	// compare parsed values, as numbers are parsed as float64
+	if old, err := parseSecurityFile(current); err == nil {
+		if parsed, err := parseSecurityFile(out); err == nil && reflect.DeepEqual(old, parsed) {
+			return current, nil
+		}
+	}
+	return out, nil
+}
+
+// configSection returns the section of config.yml of the security plugin below dynamic, eg,
+// authc or authz. It is created, if it does not exist.
+func configSection(configData map[string]interface{}, root, section string) map[string]interface{} {
+	m := configData
+	for _, key := range []string{root, "dynamic", section} {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[key] = next
+		}
+		m = next
+	}
+	return m
+}
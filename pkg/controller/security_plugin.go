@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"fmt"
+
+	catalog "kubedb.dev/apimachinery/apis/catalog/v1alpha1"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+)
+
+// Names of the config files of the security plugin in the database secret.
+const (
+	securityActionGroups  = "action_groups"
+	securityConfig        = "config"
+	securityInternalUsers = "internal_users"
+	securityRoles         = "roles"
+	securityRolesMapping  = "roles_mapping"
+)
+
+// securityPlugin describes the names of the config files and keys of the security plugin.
+type securityPlugin struct {
+	// filePrefix is the prefix of the config files
+	filePrefix string
+	// configRoot is the root key of config.yml
+	configRoot string
+	// rolePrefix is the prefix of the built-in roles
+	rolePrefix string
+}
+
+var searchGuardPlugin = securityPlugin{
+	filePrefix: "sg_",
+	configRoot: "searchguard",
+	rolePrefix: "sg_",
+}
+
+// usesSecurityPlugin reports whether the users and roles of the cluster are configured by the
+// config files of the database secret.
+func usesSecurityPlugin(elasticsearch *api.Elasticsearch) bool {
+	return elasticsearch.Spec.AuthPlugin == api.ElasticsearchAuthPluginSearchGuard
+}
+
+func (p securityPlugin) file(name string) string {
+	return p.filePrefix + name + ".yml"
+}
+
+// securityConfigDir returns the directory, that sgadmin loads the config files of the
+// database secret from.
+func securityConfigDir(elasticsearchVersion *catalog.ElasticsearchVersion) string {
+	return fmt.Sprintf("/elasticsearch/plugins/search-guard-%v/sgconfig", string(elasticsearchVersion.Spec.Version[0]))
+}
@@ -22,6 +22,7 @@ var (
 	NodeRoleMaster = "node.role.master"
 	NodeRoleClient = "node.role.client"
 	NodeRoleData   = "node.role.data"
+	NodeRoleIngest = "node.role.ingest"
 
 	defaultClientPort = core.ServicePort{
 		Name:       api.ElasticsearchRestPortName,
@@ -118,7 +119,11 @@ func (c *Controller) createService(elasticsearch *api.Elasticsearch) (kutil.Verb
 		in.Annotations = elasticsearch.Spec.ServiceTemplate.Annotations
 
 		in.Spec.Selector = elasticsearch.OffshootSelectors()
-		in.Spec.Selector[NodeRoleClient] = "set"
+		if routesToIngest(elasticsearch) {
+			in.Spec.Selector[NodeRoleIngest] = "set"
+		} else {
+			in.Spec.Selector[NodeRoleClient] = "set"
+		}
 		in.Spec.Ports = ofst.MergeServicePorts(
 			core_util.MergeServicePorts(in.Spec.Ports, []core.ServicePort{defaultClientPort}),
 			elasticsearch.Spec.ServiceTemplate.Spec.Ports,
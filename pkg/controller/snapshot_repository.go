@@ -0,0 +1,315 @@
+package controller
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/reference"
+	core_util "kmodules.xyz/client-go/core/v1"
+	store "kmodules.xyz/objectstore-api/api/v1"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+)
+
+const (
+	// AnnotationRepositoryCredentials is the hash of the repository credentials loaded into the
+	// keystore of the nodes. It is set on the pod template, so new credentials roll out a new revision.
+	AnnotationRepositoryCredentials = "elasticsearch.kubedb.com/repository-credentials"
+
+	// RepositoryCredentialsDir is where the repository credentials are mounted. The image adds
+	// every file to the keystore, using the name of the file as the name of the secure setting.
+	RepositoryCredentialsDir = "/elasticsearch/config/repository-credentials"
+
+	repositoryCredentialsVolumeName = "repository-credentials"
+	repositoryVolumeNamePrefix      = "repository"
+)
+
+// nodeRepositories are the snapshot repositories the nodes need access to.
+type nodeRepositories struct {
+	// credentialsHash is the hash of the repository credentials secret, if there are any credentials.
+	credentialsHash string
+	// locals are the volumes of the fs repositories, which are listed in path.repo.
+	locals []store.LocalSpec
+}
+
+func repositoryCredentialsSecretName(elasticsearch *api.Elasticsearch) string {
+	return fmt.Sprintf("%v-repository-credentials", elasticsearch.OffshootName())
+}
+
+// repositoryClient returns the name of the client of the repository plugin, that uses the
+// credentials of the storage secret of a Snapshot. The default client is used without one.
+func repositoryClient(snapshot *api.Snapshot) string {
+	return strings.ToLower(snapshot.Spec.Backend.StorageSecretName)
+}
+
+// repositoryCredentials maps the keys of the storage secret of a Snapshot to the client
+// settings of the repository plugin of its backend.
+func repositoryCredentials(snapshot *api.Snapshot, secret *core.Secret) map[string][]byte {
+	backend := snapshot.Spec.Backend
+	var prefix string
+	var keys map[string]string
+	switch {
+	case backend.S3 != nil:
+		prefix = "s3"
+		keys = map[string]string{
+			store.AWS_ACCESS_KEY_ID:     "access_key",
+			store.AWS_SECRET_ACCESS_KEY: "secret_key",
+		}
+	case backend.GCS != nil:
+		prefix = "gcs"
+		keys = map[string]string{
+			store.GOOGLE_SERVICE_ACCOUNT_JSON_KEY: "credentials_file",
+		}
+	case backend.Azure != nil:
+		prefix = "azure"
+		keys = map[string]string{
+			store.AZURE_ACCOUNT_NAME: "account",
+			store.AZURE_ACCOUNT_KEY:  "key",
+		}
+	default:
+		return nil
+	}
+
+	client := repositoryClient(snapshot)
+	credentials := make(map[string][]byte)
+	for key, setting := range keys {
+		if v, ok := secret.Data[key]; ok {
+			credentials[fmt.Sprintf("%s.client.%s.%s", prefix, client, setting)] = v
+		}
+	}
+	// the endpoint is not a secure setting, so the image writes it into elasticsearch.yml
+	if backend.S3 != nil && backend.S3.Endpoint != "" {
+		credentials[fmt.Sprintf("s3.client.%s.endpoint", client)] = []byte(backend.S3.Endpoint)
+	}
+	return credentials
+}
+
+// nativeSnapshots returns the native Snapshots of the database, and the Snapshot it is
+// initialized from, if that is a native Snapshot.
+func (c *Controller) nativeSnapshots(elasticsearch *api.Elasticsearch) ([]*api.Snapshot, error) {
+	var snapshots []*api.Snapshot
+	for _, obj := range c.SnapInformer.GetIndexer().List() {
+		snapshot := obj.(*api.Snapshot)
+		if snapshot.Namespace == elasticsearch.Namespace &&
+			snapshot.Spec.DatabaseName == elasticsearch.Name &&
+			isNativeSnapshot(snapshot) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	if init := elasticsearch.Spec.Init; init != nil && init.SnapshotSource != nil {
+		namespace := init.SnapshotSource.Namespace
+		if namespace == "" {
+			namespace = elasticsearch.Namespace
+		}
+		snapshot, err := c.ExtClient.KubedbV1alpha1().Snapshots(namespace).Get(init.SnapshotSource.Name, metav1.GetOptions{})
+		if err != nil && !kerr.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil && isNativeSnapshot(snapshot) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+// ensureRepositories collects the repositories of the native Snapshots of the database. The
+// credentials of their storage secrets are copied into the repository credentials secret,
+// which is loaded into the keystore of every node.
+func (c *Controller) ensureRepositories(elasticsearch *api.Elasticsearch) (*nodeRepositories, error) {
+	snapshots, err := c.nativeSnapshots(elasticsearch)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := &nodeRepositories{}
+	credentials := make(map[string][]byte)
+	mountPaths := make(map[string]bool)
+	for _, snapshot := range snapshots {
+		backend := snapshot.Spec.Backend
+		if backend.Local != nil {
+			if !mountPaths[backend.Local.MountPath] {
+				mountPaths[backend.Local.MountPath] = true
+				repos.locals = append(repos.locals, *backend.Local)
+			}
+			continue
+		}
+		if backend.StorageSecretName == "" {
+			continue
+		}
+		secret, err := c.Client.CoreV1().Secrets(snapshot.Namespace).Get(backend.StorageSecretName, metav1.GetOptions{})
+		if err != nil {
+			if kerr.IsNotFound(err) {
+				// ValidateSnapshot reports the missing secret on the Snapshot
+				continue
+			}
+			return nil, err
+		}
+		for k, v := range repositoryCredentials(snapshot, secret) {
+			credentials[k] = v
+		}
+	}
+	sort.Slice(repos.locals, func(i, j int) bool {
+		return repos.locals[i].MountPath < repos.locals[j].MountPath
+	})
+
+	if len(credentials) == 0 {
+		return repos, nil
+	}
+
+	ref, err := reference.GetReference(clientsetscheme.Scheme, elasticsearch)
+	if err != nil {
+		return nil, err
+	}
+	meta := metav1.ObjectMeta{
+		Name:      repositoryCredentialsSecretName(elasticsearch),
+		Namespace: elasticsearch.Namespace,
+	}
+	if _, _, err := core_util.CreateOrPatchSecret(c.Client, meta, func(in *core.Secret) *core.Secret {
+		in.Labels = core_util.UpsertMap(in.Labels, elasticsearch.OffshootLabels())
+		core_util.EnsureOwnerReference(&in.ObjectMeta, ref)
+		in.Data = credentials
+		return in
+	}); err != nil {
+		return nil, err
+	}
+	repos.credentialsHash = hashSecretData(credentials)
+	return repos, nil
+}
+
+func hashSecretData(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := fnv.New64a()
+	for _, k := range keys {
+		_, _ = h.Write([]byte(k))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write(data[k])
+		_, _ = h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+// upsertRepositories mounts the repository credentials and the volumes of fs repositories.
+// Nothing is added for databases without native Snapshots, so their pods are not restarted.
+func upsertRepositories(statefulSet *apps.StatefulSet, elasticsearch *api.Elasticsearch, repos *nodeRepositories) *apps.StatefulSet {
+	if repos.credentialsHash != "" {
+		annotations := make(map[string]string, len(statefulSet.Spec.Template.Annotations)+1)
+		for k, v := range statefulSet.Spec.Template.Annotations {
+			annotations[k] = v
+		}
+		annotations[AnnotationRepositoryCredentials] = repos.credentialsHash
+		statefulSet.Spec.Template.Annotations = annotations
+	}
+
+	for i, container := range statefulSet.Spec.Template.Spec.Containers {
+		if container.Name != api.ResourceSingularElasticsearch {
+			continue
+		}
+		volumeMounts := container.VolumeMounts
+		volumes := statefulSet.Spec.Template.Spec.Volumes
+		if repos.credentialsHash != "" {
+			volumeMounts = core_util.UpsertVolumeMount(volumeMounts, core.VolumeMount{
+				Name:      repositoryCredentialsVolumeName,
+				MountPath: RepositoryCredentialsDir,
+				ReadOnly:  true,
+			})
+			volumes = core_util.UpsertVolume(volumes, core.Volume{
+				Name: repositoryCredentialsVolumeName,
+				VolumeSource: core.VolumeSource{
+					Secret: &core.SecretVolumeSource{
+						SecretName: repositoryCredentialsSecretName(elasticsearch),
+					},
+				},
+			})
+		}
+
+		locations := make([]string, 0, len(repos.locals))
+		for j, local := range repos.locals {
+			name := fmt.Sprintf("%s-%d", repositoryVolumeNamePrefix, j)
+			volumeMounts = core_util.UpsertVolumeMount(volumeMounts, core.VolumeMount{
+				Name:      name,
+				MountPath: local.MountPath,
+				SubPath:   local.SubPath,
+			})
+			volumes = core_util.UpsertVolume(volumes, core.Volume{
+				Name:         name,
+				VolumeSource: local.VolumeSource,
+			})
+			locations = append(locations, local.MountPath)
+		}
+		if len(locations) > 0 {
+			statefulSet.Spec.Template.Spec.Containers[i].Env = core_util.UpsertEnvVars(container.Env, core.EnvVar{
+				Name:  "REPO_LOCATIONS",
+				Value: strings.Join(locations, ","),
+			})
+		}
+
+		statefulSet.Spec.Template.Spec.Containers[i].VolumeMounts = volumeMounts
+		statefulSet.Spec.Template.Spec.Volumes = volumes
+		break
+	}
+	return statefulSet
+}
+
+// nodesLoadedRepository reports whether all nodes run with access to the repository of the
+// Snapshot, ie, with its volume mounted, or with the current repository credentials loaded.
+func (c *Controller) nodesLoadedRepository(elasticsearch *api.Elasticsearch, snapshot *api.Snapshot) (bool, error) {
+	backend := snapshot.Spec.Backend
+	var credentialsHash string
+	if backend.Local == nil && backend.StorageSecretName != "" {
+		secret, err := c.Client.CoreV1().Secrets(elasticsearch.Namespace).Get(repositoryCredentialsSecretName(elasticsearch), metav1.GetOptions{})
+		if err != nil {
+			if kerr.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		credentialsHash = hashSecretData(secret.Data)
+	}
+
+	for _, name := range nodeStatefulSetNames(elasticsearch) {
+		sts, err := c.Client.AppsV1().StatefulSets(elasticsearch.Namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			if kerr.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		if credentialsHash != "" && sts.Spec.Template.Annotations[AnnotationRepositoryCredentials] != credentialsHash {
+			return false, nil
+		}
+		if backend.Local != nil && !hasVolumeMount(sts, backend.Local.MountPath) {
+			return false, nil
+		}
+	}
+
+	outdated, ready, err := c.getOutdatedPods(elasticsearch)
+	if err != nil {
+		return false, err
+	}
+	return ready && len(outdated) == 0, nil
+}
+
+func hasVolumeMount(statefulSet *apps.StatefulSet, mountPath string) bool {
+	for _, container := range statefulSet.Spec.Template.Spec.Containers {
+		if container.Name != api.ResourceSingularElasticsearch {
+			continue
+		}
+		for _, vm := range container.VolumeMounts {
+			if vm.MountPath == mountPath {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -9,8 +9,8 @@ import (
 	apps "k8s.io/api/apps/v1"
 	core "k8s.io/api/core/v1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
 	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/reference"
 	kutil "kmodules.xyz/client-go"
@@ -29,17 +29,15 @@ const (
 
 func (c *Controller) ensureStatefulSet(
 	elasticsearch *api.Elasticsearch,
-	storageType api.StorageType,
-	pvcSpec *core.PersistentVolumeClaimSpec,
-	resources core.ResourceRequirements,
+	pool api.ElasticsearchNodePool,
 	statefulSetName string,
 	labels map[string]string,
-	replicas int32,
 	envList []core.EnvVar,
-	nodeSelector map[string]string,
-	isClient bool,
-	maxUnavailable *intstr.IntOrString,
+	repos *nodeRepositories,
 ) (kutil.VerbType, error) {
+	replicas := nodePoolReplicas(pool)
+	resources := pool.Resources
+	isClient := hasRole(pool, api.ElasticsearchNodeRoleCoordinating)
 
 	elasticsearchVersion, err := c.ExtClient.CatalogV1alpha1().ElasticsearchVersions().Get(string(elasticsearch.Spec.Version), metav1.GetOptions{})
 	if err != nil {
@@ -50,6 +48,13 @@ func (c *Controller) ensureStatefulSet(
 		return kutil.VerbUnchanged, err
 	}
 
+	// move shards off data nodes before they are removed
+	if labels[NodeRoleData] == "set" {
+		if replicas, err = c.ensureSafeScaleDown(elasticsearch, statefulSetName, replicas); err != nil {
+			return kutil.VerbUnchanged, err
+		}
+	}
+
 	statefulSetMeta := metav1.ObjectMeta{
 		Name:      statefulSetName,
 		Namespace: elasticsearch.Namespace,
@@ -60,8 +65,6 @@ func (c *Controller) ensureStatefulSet(
 		return kutil.VerbUnchanged, rerr
 	}
 
-	//searchGuard := string(elasticsearchVersion.Spec.Version[0])
-
 	statefulSet, vt, err := app_util.CreateOrPatchStatefulSet(c.Client, statefulSetMeta, func(in *apps.StatefulSet) *apps.StatefulSet {
 		in.Labels = core_util.UpsertMap(labels, elasticsearch.OffshootLabels())
 		in.Annotations = elasticsearch.Spec.PodTemplate.Controller.Annotations
@@ -74,7 +77,7 @@ func (c *Controller) ensureStatefulSet(
 			MatchLabels: core_util.UpsertMap(labels, elasticsearch.OffshootSelectors()),
 		}
 		in.Spec.Template.Labels = core_util.UpsertMap(labels, elasticsearch.OffshootSelectors())
-		in.Spec.Template.Annotations = elasticsearch.Spec.PodTemplate.Annotations
+		in.Spec.Template.Annotations = podTemplateAnnotations(elasticsearch)
 		in.Spec.Template.Spec.InitContainers = core_util.UpsertContainers(
 			in.Spec.Template.Spec.InitContainers,
 			append(
@@ -112,15 +115,16 @@ func (c *Controller) ensureStatefulSet(
 			})
 		in = upsertEnv(in, elasticsearch, envList)
 		in = upsertUserEnv(in, elasticsearch)
-		in = upsertPort(in, isClient)
+		// ingest nodes serve the primary service, if spec.topology.routeToIngest is set
+		in = upsertPort(in, isClient || labels[NodeRoleIngest] == "set")
 		in = upsertCustomConfig(in, elasticsearch)
 
-		in.Spec.Template.Spec.NodeSelector = nodeSelector
-		in.Spec.Template.Spec.Affinity = elasticsearch.Spec.PodTemplate.Spec.Affinity
+		in.Spec.Template.Spec.NodeSelector = pool.NodeSelector
+		in.Spec.Template.Spec.Affinity = pool.Affinity
 		if elasticsearch.Spec.PodTemplate.Spec.SchedulerName != "" {
 			in.Spec.Template.Spec.SchedulerName = elasticsearch.Spec.PodTemplate.Spec.SchedulerName
 		}
-		in.Spec.Template.Spec.Tolerations = elasticsearch.Spec.PodTemplate.Spec.Tolerations
+		in.Spec.Template.Spec.Tolerations = pool.Tolerations
 		in.Spec.Template.Spec.ImagePullSecrets = elasticsearch.Spec.PodTemplate.Spec.ImagePullSecrets
 		in.Spec.Template.Spec.PriorityClassName = elasticsearch.Spec.PodTemplate.Spec.PriorityClassName
 		in.Spec.Template.Spec.Priority = elasticsearch.Spec.PodTemplate.Spec.Priority
@@ -128,19 +132,27 @@ func (c *Controller) ensureStatefulSet(
 
 		if isClient {
 			in = c.upsertMonitoringContainer(in, elasticsearch, elasticsearchVersion)
-			//in = upsertDatabaseSecret(in, elasticsearch.Spec.DatabaseSecret.SecretName, searchGuard)
+			// sgadmin loads the config of the security plugin in the database secret from the client node
+			in = upsertDatabaseSecret(in, elasticsearch.Spec.DatabaseSecret.SecretName, securityConfigDir(elasticsearchVersion))
 		}
 
-		in = upsertCertificate(in, elasticsearch.Spec.CertificateSecret.SecretName, isClient, elasticsearch.Spec.EnableSSL)
+		in = upsertCertificate(in, elasticsearch.Spec.CertificateSecret.SecretName, keyStoreFormat(elasticsearch), isClient, elasticsearch.Spec.EnableSSL)
 		//in = upsertDataVolume(in, elasticsearch.Spec.StorageType, pvcSpec)
-		in = upsertDataVolume(in, storageType, pvcSpec)
+		in = upsertDataVolume(in, pool.StorageType, pool.Storage)
 		in = upsertTemporaryVolume(in)
+		in = upsertRepositories(in, elasticsearch, repos)
 
 		if c.EnableRBAC {
 			in.Spec.Template.Spec.ServiceAccountName = elasticsearch.Spec.PodTemplate.Spec.ServiceAccountName
 		}
 
 		in.Spec.UpdateStrategy = elasticsearch.Spec.UpdateStrategy
+		if in.Spec.UpdateStrategy.Type == apps.RollingUpdateStatefulSetStrategyType {
+			// pods are restarted one at a time by the operator, see ensureRollingRestart
+			in.Spec.UpdateStrategy = apps.StatefulSetUpdateStrategy{
+				Type: apps.OnDeleteStatefulSetStrategyType,
+			}
+		}
 
 		return in
 	})
@@ -164,8 +176,8 @@ func (c *Controller) ensureStatefulSet(
 	}
 
 	// ensure pdb
-	if maxUnavailable != nil {
-		if err := c.createPodDisruptionBudget(statefulSet, maxUnavailable); err != nil {
+	if pool.MaxUnavailable != nil {
+		if err := c.createPodDisruptionBudget(statefulSet, pool.MaxUnavailable); err != nil {
 			return vt, err
 		}
 	}
@@ -186,265 +198,32 @@ func (c *Controller) CheckStatefulSetPodStatus(statefulSet *apps.StatefulSet) er
 	return nil
 }
 
-func getHeapSizeForNode(val int64) int64 {
-	ret := val / 100
-	return ret * 50
-}
-
-func (c *Controller) ensureClientNode(elasticsearch *api.Elasticsearch) (kutil.VerbType, error) {
-	statefulSetName := elasticsearch.OffshootName()
-	clientNode := elasticsearch.Spec.Topology.Client
-
-	if clientNode.Prefix != "" {
-		statefulSetName = fmt.Sprintf("%v-%v", clientNode.Prefix, statefulSetName)
-	}
-
-	labels := elasticsearch.OffshootLabels()
-	labels[NodeRoleClient] = "set"
-
-	heapSize := int64(134217728) // 128mb
-	if request, found := clientNode.Resources.Requests[core.ResourceMemory]; found && request.Value() > 0 {
-		heapSize = getHeapSizeForNode(request.Value())
-	}
-
-	envList := []core.EnvVar{
-		{
-			Name:  "NODE_MASTER",
-			Value: fmt.Sprintf("%v", false),
-		},
-		{
-			Name:  "NODE_DATA",
-			Value: fmt.Sprintf("%v", false),
-		},
-		{
-			Name:  "MODE",
-			Value: "client",
-		},
-		{
-			Name:  "ES_JAVA_OPTS",
-			Value: fmt.Sprintf("-Xms%v -Xmx%v", heapSize, heapSize),
-		},
-	}
-
-	replicas := int32(1)
-	if clientNode.Replicas != nil {
-		replicas = types.Int32(clientNode.Replicas)
-	}
-	maxUnavailable := elasticsearch.Spec.Topology.Client.MaxUnavailable
-
-<<<<<<< HEAD
-	return c.ensureStatefulSet(elasticsearch, clientNode.StorageType, clientNode.Storage, clientNode.Resources, statefulSetName, labels, replicas, envList, clientNode.NodeSelector, true)
-=======
-	return c.ensureStatefulSet(elasticsearch, clientNode.Storage, clientNode.Resources, statefulSetName, labels, replicas, envList, true, maxUnavailable)
->>>>>>> 3dd46b3f441eeb582c0b34a80cfca5afc161975f
-}
-
-func (c *Controller) ensureMasterNode(elasticsearch *api.Elasticsearch) (kutil.VerbType, error) {
-	statefulSetName := elasticsearch.OffshootName()
-	masterNode := elasticsearch.Spec.Topology.Master
-
-	if masterNode.Prefix != "" {
-		statefulSetName = fmt.Sprintf("%v-%v", masterNode.Prefix, statefulSetName)
-	}
-
-	labels := elasticsearch.OffshootLabels()
-	labels[NodeRoleMaster] = "set"
-
-	heapSize := int64(134217728) // 128mb
-	if request, found := masterNode.Resources.Requests[core.ResourceMemory]; found && request.Value() > 0 {
-		heapSize = getHeapSizeForNode(request.Value())
-	}
-
-	replicas := int32(1)
-	if masterNode.Replicas != nil {
-		replicas = types.Int32(masterNode.Replicas)
-	}
-
-	envList := []core.EnvVar{
-		{
-			Name:  "NODE_DATA",
-			Value: fmt.Sprintf("%v", false),
-		},
-		{
-			Name:  "NODE_INGEST",
-			Value: fmt.Sprintf("%v", false),
-		},
-		{
-			Name:  "HTTP_ENABLE",
-			Value: fmt.Sprintf("%v", true),
-		},
-		{
-			Name:  "NUMBER_OF_MASTERS",
-			Value: fmt.Sprintf("%v", (replicas/2)+1),
-		},
-		{
-			Name:  "ES_JAVA_OPTS",
-			Value: fmt.Sprintf("-Xms%v -Xmx%v", heapSize, heapSize),
-		},
-	}
-
-<<<<<<< HEAD
-	return c.ensureStatefulSet(elasticsearch, masterNode.StorageType, masterNode.Storage, masterNode.Resources, statefulSetName, labels, replicas, envList, masterNode.NodeSelector, false)
-=======
-	maxUnavailable := elasticsearch.Spec.Topology.Master.MaxUnavailable
-
-	return c.ensureStatefulSet(elasticsearch, masterNode.Storage, masterNode.Resources, statefulSetName, labels, replicas, envList, false, maxUnavailable)
->>>>>>> 3dd46b3f441eeb582c0b34a80cfca5afc161975f
-}
-
-//Hot DataNode
-func (c *Controller) ensureDataNode(elasticsearch *api.Elasticsearch) (kutil.VerbType, error) {
-	statefulSetName := elasticsearch.OffshootName()
-	dataNode := elasticsearch.Spec.Topology.Data
-
-	if dataNode.Prefix != "" {
-		statefulSetName = fmt.Sprintf("%v-%v", dataNode.Prefix, statefulSetName)
-	}
-
-	labels := elasticsearch.OffshootLabels()
-	labels[NodeRoleData] = "set"
-
-	heapSize := int64(134217728) // 128mb
-	if request, found := dataNode.Resources.Requests[core.ResourceMemory]; found && request.Value() > 0 {
-		heapSize = getHeapSizeForNode(request.Value())
-	}
-
-	envList := []core.EnvVar{
-		{
-			Name:  "NODE_MASTER",
-			Value: fmt.Sprintf("%v", false),
-		},
-		{
-			Name:  "NODE_INGEST",
-			Value: fmt.Sprintf("%v", false),
-		},
-		{
-			Name:  "HTTP_ENABLE",
-			Value: fmt.Sprintf("%v", true),
-		},
-		{
-			Name:  "ES_JAVA_OPTS",
-			Value: fmt.Sprintf("-Xms%v -Xmx%v", heapSize, heapSize),
-		},
-		{
-			Name:  "NODE_TAG",
-			Value: fmt.Sprintf("%s", "hot"),
-		},
-	}
-
-	replicas := int32(1)
-	if dataNode.Replicas != nil {
-		replicas = types.Int32(dataNode.Replicas)
-	}
-
-	return c.ensureStatefulSet(elasticsearch, dataNode.StorageType, dataNode.Storage, dataNode.Resources, statefulSetName, labels, replicas, envList, dataNode.NodeSelector, false)
-}
-
-// Warm DataNode
-func (c *Controller) ensureWarmNode(elasticsearch *api.Elasticsearch) (kutil.VerbType, error) {
-	statefulSetName := elasticsearch.OffshootName()
-	dataNode := elasticsearch.Spec.Topology.Warm
-
-	if dataNode.Prefix != "" {
-		statefulSetName = fmt.Sprintf("%v-%v", dataNode.Prefix, statefulSetName)
-	}
-
-	labels := elasticsearch.OffshootLabels()
-	labels[NodeRoleData] = "set"
-
-	heapSize := int64(134217728) // 128mb
-	if request, found := dataNode.Resources.Requests[core.ResourceMemory]; found && request.Value() > 0 {
-		heapSize = getHeapSizeForNode(request.Value())
-	}
-
-	envList := []core.EnvVar{
-		{
-			Name:  "NODE_MASTER",
-			Value: fmt.Sprintf("%v", false),
-		},
-		{
-			Name:  "NODE_INGEST",
-			Value: fmt.Sprintf("%v", false),
-		},
-		{
-			Name:  "HTTP_ENABLE",
-			Value: fmt.Sprintf("%v", true),
-		},
-		{
-			Name:  "ES_JAVA_OPTS",
-			Value: fmt.Sprintf("-Xms%v -Xmx%v", heapSize, heapSize),
-		},
-		{
-			Name:  "NODE_TAG",
-			Value: fmt.Sprintf("%s", "warm"),
-		},
-	}
-
-	replicas := int32(1)
-	if dataNode.Replicas != nil {
-		replicas = types.Int32(dataNode.Replicas)
-	}
-
-<<<<<<< HEAD
-	return c.ensureStatefulSet(elasticsearch, dataNode.StorageType, dataNode.Storage, dataNode.Resources, statefulSetName, labels, replicas, envList, dataNode.NodeSelector, false)
-=======
-	maxUnavailable := elasticsearch.Spec.Topology.Data.MaxUnavailable
-
-	return c.ensureStatefulSet(elasticsearch, dataNode.Storage, dataNode.Resources, statefulSetName, labels, replicas, envList, false, maxUnavailable)
->>>>>>> 3dd46b3f441eeb582c0b34a80cfca5afc161975f
-}
-
-func (c *Controller) ensureCombinedNode(elasticsearch *api.Elasticsearch) (kutil.VerbType, error) {
-	statefulSetName := elasticsearch.OffshootName()
-	labels := elasticsearch.OffshootLabels()
-	labels[NodeRoleClient] = "set"
-	labels[NodeRoleMaster] = "set"
-	labels[NodeRoleData] = "set"
+const (
+	defaultHeapSize = int64(134217728) // 128mb
+	// maxHeapSize keeps the heap below the threshold where the JVM stops using compressed oops
+	maxHeapSize = int64(31 * 1024 * 1024 * 1024) // 31gb
+)
 
-	replicas := int32(1)
-	if elasticsearch.Spec.Replicas != nil {
-		replicas = types.Int32(elasticsearch.Spec.Replicas)
+// getHeapSizeForNode returns the heap size of a node in bytes. If heapSize is not set, half of
+// the memory limit, or request if no limit is set, is used, up to maxHeapSize.
+func getHeapSizeForNode(heapSize *resource.Quantity, resources core.ResourceRequirements) int64 {
+	if heapSize != nil && heapSize.Value() > 0 {
+		return heapSize.Value()
 	}
 
-	heapSize := int64(134217728) // 128mb
-	if elasticsearch.Spec.PodTemplate.Spec.Resources.Size() != 0 {
-		if request, found := elasticsearch.Spec.PodTemplate.Spec.Resources.Requests[core.ResourceMemory]; found && request.Value() > 0 {
-			heapSize = getHeapSizeForNode(request.Value())
-		}
+	memory, found := resources.Limits[core.ResourceMemory]
+	if !found || memory.Value() <= 0 {
+		memory, found = resources.Requests[core.ResourceMemory]
 	}
-
-	envList := []core.EnvVar{
-		{
-			Name:  "NUMBER_OF_MASTERS",
-			Value: fmt.Sprintf("%v", (replicas/2)+1),
-		},
-		{
-			Name:  "MODE",
-			Value: "client",
-		},
-		{
-			Name:  "ES_JAVA_OPTS",
-			Value: fmt.Sprintf("-Xms%v -Xmx%v", heapSize, heapSize),
-		},
+	if !found || memory.Value() <= 0 {
+		return defaultHeapSize
 	}
 
-	var pvcSpec core.PersistentVolumeClaimSpec
-	var resources core.ResourceRequirements
-	if elasticsearch.Spec.Storage != nil {
-		pvcSpec = *elasticsearch.Spec.Storage
-	}
-	if elasticsearch.Spec.PodTemplate.Spec.Resources.Size() != 0 {
-		resources = elasticsearch.Spec.PodTemplate.Spec.Resources
+	ret := memory.Value() / 100 * 50
+	if ret > maxHeapSize {
+		return maxHeapSize
 	}
-<<<<<<< HEAD
-	nodeSelector := elasticsearch.Spec.PodTemplate.Spec.NodeSelector
-	return c.ensureStatefulSet(elasticsearch, elasticsearch.Spec.StorageType, &pvcSpec, resources, statefulSetName, labels, replicas, envList, nodeSelector, true)
-=======
-
-	maxUnavailable := elasticsearch.Spec.MaxUnavailable
-
-	return c.ensureStatefulSet(elasticsearch, &pvcSpec, resources, statefulSetName, labels, replicas, envList, true, maxUnavailable)
->>>>>>> 3dd46b3f441eeb582c0b34a80cfca5afc161975f
+	return ret
 }
 
 func (c *Controller) checkStatefulSet(elasticsearch *api.Elasticsearch, name string) error {
@@ -488,6 +267,18 @@ func upsertEnv(statefulSet *apps.StatefulSet, elasticsearch *api.Elasticsearch,
 			Name:  "SSL_ENABLE",
 			Value: fmt.Sprintf("%v", elasticsearch.Spec.EnableSSL),
 		},
+		{
+			Name:  "KEYSTORE_FORMAT",
+			Value: string(keyStoreFormat(elasticsearch)),
+		},
+		{
+			Name:  "NODE_DN",
+			Value: nodeDN(elasticsearch),
+		},
+		{
+			Name:  "SGADMIN_DN",
+			Value: sgAdminDN(elasticsearch),
+		},
 		{
 			Name: "KEY_PASS",
 			ValueFrom: &core.EnvVarSource{
@@ -512,6 +303,29 @@ func upsertEnv(statefulSet *apps.StatefulSet, elasticsearch *api.Elasticsearch,
 			Name:  "SEARCHGUARD_DISABLED",
 			Value: "true",
 		})
+	} else if elasticsearch.Spec.AuthPlugin == api.ElasticsearchAuthPluginXpack {
+		envList = append(envList, []core.EnvVar{
+			{
+				Name:  "SEARCHGUARD_DISABLED",
+				Value: "true",
+			},
+			{
+				Name:  "XPACK_SECURITY_ENABLED",
+				Value: "true",
+			},
+			{
+				// bootstrap password of the elastic user
+				Name: "ELASTIC_PASSWORD",
+				ValueFrom: &core.EnvVarSource{
+					SecretKeyRef: &core.SecretKeySelector{
+						LocalObjectReference: core.LocalObjectReference{
+							Name: elasticsearch.Spec.DatabaseSecret.SecretName,
+						},
+						Key: KeyAdminPassword,
+					},
+				},
+			},
+		}...)
 	}
 
 	// To do this, Upsert Container first
@@ -613,6 +427,40 @@ func (c *Controller) upsertMonitoringContainer(statefulSet *apps.StatefulSet, el
 		}
 		container.Env = core_util.UpsertEnvVars(container.Env, envList...)
 
+		// the exporter reads the admin password from its environment. Once the password is
+		// rotated, it no longer matches the mounted secret, and only the exporter is restarted.
+		container.VolumeMounts = core_util.UpsertVolumeMount(container.VolumeMounts, core.VolumeMount{
+			Name:      "exporter-secret",
+			MountPath: ExporterSecretPath,
+			ReadOnly:  true,
+		})
+		statefulSet.Spec.Template.Spec.Volumes = core_util.UpsertVolume(statefulSet.Spec.Template.Spec.Volumes, core.Volume{
+			Name: "exporter-secret",
+			VolumeSource: core.VolumeSource{
+				Secret: &core.SecretVolumeSource{
+					SecretName: elasticsearch.Spec.DatabaseSecret.SecretName,
+					Items: []core.KeyToPath{
+						{
+							Key:  KeyAdminPassword,
+							Path: KeyAdminPassword,
+						},
+					},
+				},
+			},
+		})
+		container.LivenessProbe = &core.Probe{
+			Handler: core.Handler{
+				Exec: &core.ExecAction{
+					Command: []string{
+						"sh",
+						"-c",
+						fmt.Sprintf(`[ "$DB_PASSWORD" = "$(cat %s)" ]`, filepath.Join(ExporterSecretPath, KeyAdminPassword)),
+					},
+				},
+			},
+			PeriodSeconds: 30,
+		}
+
 		if elasticsearch.Spec.EnableSSL {
 			certVolumeMount := core.VolumeMount{
 				Name:      "exporter-certs",
@@ -647,33 +495,30 @@ func (c *Controller) upsertMonitoringContainer(statefulSet *apps.StatefulSet, el
 	return statefulSet
 }
 
-func upsertCertificate(statefulSet *apps.StatefulSet, secretName string, isClientNode, isEnalbeSSL bool) *apps.StatefulSet {
+func upsertCertificate(statefulSet *apps.StatefulSet, secretName string, format api.ElasticsearchKeyStoreFormat, isClientNode, isEnalbeSSL bool) *apps.StatefulSet {
 	addCertVolume := func() *core.SecretVolumeSource {
-		svs := &core.SecretVolumeSource{
-			SecretName: secretName,
-			Items: []core.KeyToPath{
-				{
-					Key:  rootKeyStore,
-					Path: rootKeyStore,
-				},
-				{
-					Key:  nodeKeyStore,
-					Path: nodeKeyStore,
-				},
-			},
+		// PEM encoded certificates are trusted through root.pem, the others through root.jks
+		keys := []string{rootKeyStore}
+		if format == api.ElasticsearchKeyStoreFormatPEM {
+			keys = []string{rootCert}
 		}
+		keys = append(keys, nodeFiles.forFormat(format)...)
 
 		if isEnalbeSSL {
-			svs.Items = append(svs.Items, core.KeyToPath{
-				Key:  clientKeyStore,
-				Path: clientKeyStore,
-			})
+			keys = append(keys, clientFiles.forFormat(format)...)
 		}
 
 		if isClientNode {
+			keys = append(keys, sgAdminFiles.forFormat(format)...)
+		}
+
+		svs := &core.SecretVolumeSource{
+			SecretName: secretName,
+		}
+		for _, key := range keys {
 			svs.Items = append(svs.Items, core.KeyToPath{
-				Key:  sgAdminKeyStore,
-				Path: sgAdminKeyStore,
+				Key:  key,
+				Path: key,
 			})
 		}
 		return svs
@@ -702,12 +547,12 @@ func upsertCertificate(statefulSet *apps.StatefulSet, secretName string, isClien
 	return statefulSet
 }
 
-func upsertDatabaseSecret(statefulSet *apps.StatefulSet, secretName string, searchGuard string) *apps.StatefulSet {
+func upsertDatabaseSecret(statefulSet *apps.StatefulSet, secretName string, mountPath string) *apps.StatefulSet {
 	for i, container := range statefulSet.Spec.Template.Spec.Containers {
 		if container.Name == api.ResourceSingularElasticsearch {
 			volumeMount := core.VolumeMount{
 				Name:      "sgconfig",
-				MountPath: fmt.Sprintf("/elasticsearch/plugins/search-guard-%v/sgconfig", searchGuard),
+				MountPath: mountPath,
 			}
 			statefulSet.Spec.Template.Spec.Containers[i].VolumeMounts = core_util.UpsertVolumeMount(container.VolumeMounts, volumeMount)
 
@@ -825,7 +670,7 @@ func upsertCustomConfig(statefulSet *apps.StatefulSet, elasticsearch *api.Elasti
 func getURI(e *api.Elasticsearch) string {
 	if e.Spec.AuthPlugin == api.ElasticsearchAuthPluginNone {
 		return fmt.Sprintf("%s://localhost:%d", e.GetConnectionScheme(), api.ElasticsearchRestPort)
-	} else if e.Spec.AuthPlugin == api.ElasticsearchAuthPluginSearchGuard {
+	} else if usesSecurityPlugin(e) || e.Spec.AuthPlugin == api.ElasticsearchAuthPluginXpack {
 		return fmt.Sprintf("%s://$(DB_USER):$(DB_PASSWORD)@localhost:%d", e.GetConnectionScheme(), api.ElasticsearchRestPort)
 	} else {
 		log.Infoln("Invalid Auth Plugin")
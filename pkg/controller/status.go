@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/appscode/go/log"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"kubedb.dev/apimachinery/apis"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	"kubedb.dev/elasticsearch/pkg/util/es"
+)
+
+const (
+	healthCheckInterval = 30 * time.Second
+	// healthCheckConcurrency bounds the number of clusters checked at the same time,
+	// as every check opens a connection to the cluster.
+	healthCheckConcurrency = 5
+)
+
+// runHealthChecker periodically refreshes health, node and shard information
+// in the status of every Elasticsearch object managed by this operator.
+func (c *Controller) runHealthChecker(stopCh <-chan struct{}) {
+	go wait.Until(c.syncHealthStatus, healthCheckInterval, stopCh)
+}
+
+func (c *Controller) syncHealthStatus() {
+	elasticsearches, err := c.esLister.List(labels.Everything())
+	if err != nil {
+		log.Errorln("failed to list Elasticsearch.", err)
+		return
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, healthCheckConcurrency)
+	for _, elasticsearch := range elasticsearches {
+		if elasticsearch.DeletionTimestamp != nil ||
+			elasticsearch.Status.Phase == "" ||
+			elasticsearch.Status.Phase == api.DatabasePhaseCreating {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(elasticsearch *api.Elasticsearch) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := c.updateHealthStatus(elasticsearch); err != nil {
+				log.Errorf("failed to update health status of Elasticsearch %s/%s. Reason: %v", elasticsearch.Namespace, elasticsearch.Name, err)
+			}
+		}(elasticsearch.DeepCopy())
+	}
+	wg.Wait()
+}
+
+func (c *Controller) updateHealthStatus(elasticsearch *api.Elasticsearch) error {
+	nodes, provisioned, provisionMessage, err := c.getReadyNodes(elasticsearch)
+	if err != nil {
+		return err
+	}
+
+	var health *es.ClusterHealth
+	client, healthErr := c.getElasticClient(elasticsearch)
+	if healthErr == nil {
+		health, healthErr = client.GetClusterHealth()
+		client.Stop()
+	}
+
+	_, err = util.UpdateElasticsearchStatus(c.ExtClient.KubedbV1alpha1(), elasticsearch, func(in *api.ElasticsearchStatus) *api.ElasticsearchStatus {
+		in.Nodes = nodes
+		in.Heap = getHeapStatus(elasticsearch)
+		return setHealthStatus(in, provisioned, provisionMessage, health, healthErr)
+	}, apis.EnableStatusSubresource)
+	return err
+}
+
+// setHealthStatus derives the health, shards and conditions of the status from the readiness of
+// the StatefulSets and the cluster health, or the error that prevented getting it.
+func setHealthStatus(in *api.ElasticsearchStatus, provisioned bool, provisionMessage string, health *es.ClusterHealth, healthErr error) *api.ElasticsearchStatus {
+	in.Conditions = setCondition(in.Conditions, newCondition(api.ElasticsearchConditionProvisioned, provisioned, "StatefulSetsReady", provisionMessage))
+
+	if healthErr != nil {
+		in.Health = ""
+		in.Conditions = setCondition(in.Conditions, newCondition(api.ElasticsearchConditionReady, false, "HealthCheckFailed", healthErr.Error()))
+		return in
+	}
+
+	in.Health = api.ElasticsearchHealth(health.Status)
+	in.Shards = &api.ElasticsearchShardsStatus{
+		ActivePrimary: int32(health.ActivePrimaryShards),
+		Active:        int32(health.ActiveShards),
+		Relocating:    int32(health.RelocatingShards),
+		Initializing:  int32(health.InitializingShards),
+		Unassigned:    int32(health.UnassignedShards),
+	}
+
+	healthMessage := fmt.Sprintf("cluster health is %s", health.Status)
+	ready := provisioned && health.Status != es.HealthStatusRed
+	in.Conditions = setCondition(in.Conditions, newCondition(api.ElasticsearchConditionReady, ready, "ClusterHealth", healthMessage))
+	in.Conditions = setCondition(in.Conditions, newCondition(api.ElasticsearchConditionDegradedHealth, health.Status != es.HealthStatusGreen, "ClusterHealth", healthMessage))
+	return in
+}
+
+// getHeapStatus returns the heap size used by each type of node. If several node pools have
+// the same role, the heap size of the first one is reported.
+func getHeapStatus(elasticsearch *api.Elasticsearch) *api.ElasticsearchHeapStatus {
+	status := &api.ElasticsearchHeapStatus{}
+	for _, pool := range nodePools(elasticsearch) {
+		size := resource.NewQuantity(getHeapSizeForNode(pool.HeapSize, pool.Resources), resource.BinarySI)
+		roles := getNodeStatusRoles(elasticsearch, pool)
+		for _, heap := range []struct {
+			size **resource.Quantity
+			ok   bool
+		}{
+			{&status.Master, roles.master},
+			{&status.Data, roles.data},
+			{&status.Client, roles.client},
+			{&status.Warm, roles.warm},
+			{&status.Ingest, roles.ingest},
+		} {
+			if heap.ok && *heap.size == nil {
+				*heap.size = size
+			}
+		}
+	}
+	return status
+}
+
+// getReadyNodes counts the ready pods of every node StatefulSet. It also reports
+// whether all StatefulSets have all of their replicas ready.
+func (c *Controller) getReadyNodes(elasticsearch *api.Elasticsearch) (*api.ElasticsearchNodesStatus, bool, string, error) {
+	nodes := &api.ElasticsearchNodesStatus{}
+	var notReady []string
+
+	for _, pool := range nodePools(elasticsearch) {
+		name := nodeStatefulSetName(elasticsearch, pool.Name)
+		var ready int32
+		sts, err := c.Client.AppsV1().StatefulSets(elasticsearch.Namespace).Get(name, metav1.GetOptions{})
+		if err == nil {
+			ready = sts.Status.ReadyReplicas
+		} else if !kerr.IsNotFound(err) {
+			return nil, false, "", err
+		}
+		if ready != nodePoolReplicas(pool) {
+			notReady = append(notReady, name)
+		}
+		roles := getNodeStatusRoles(elasticsearch, pool)
+		for _, count := range []struct {
+			count *int32
+			ok    bool
+		}{
+			{&nodes.Master, roles.master},
+			{&nodes.Data, roles.data},
+			{&nodes.Client, roles.client},
+			{&nodes.Warm, roles.warm},
+			{&nodes.Ingest, roles.ingest},
+		} {
+			if count.ok {
+				*count.count += ready
+			}
+		}
+	}
+
+	if len(notReady) > 0 {
+		return nodes, false, fmt.Sprintf("StatefulSets %v are not ready", notReady), nil
+	}
+	return nodes, true, "all StatefulSets are ready", nil
+}
+
+// nodeStatusRoles are the types of node in the status, that a node pool counts towards.
+type nodeStatusRoles struct {
+	master bool
+	data   bool
+	client bool
+	warm   bool
+	ingest bool
+}
+
+// getNodeStatusRoles returns the types of node in the status of a node pool. Warm data nodes
+// only count as warm nodes, and ingest nodes, that are also coordinating nodes, only as client nodes.
+func getNodeStatusRoles(elasticsearch *api.Elasticsearch, pool api.ElasticsearchNodePool) nodeStatusRoles {
+	labels := nodePoolLabels(elasticsearch, pool)
+	warm := pool.Attributes[attributeBoxType] == boxTypeWarm
+	return nodeStatusRoles{
+		master: labels[NodeRoleMaster] == "set",
+		data:   labels[NodeRoleData] == "set" && !warm,
+		client: labels[NodeRoleClient] == "set",
+		warm:   labels[NodeRoleData] == "set" && warm,
+		ingest: labels[NodeRoleIngest] == "set",
+	}
+}
+
+// nodeStatefulSetName returns the name of the StatefulSet for a node of the topology with the given prefix.
+func nodeStatefulSetName(elasticsearch *api.Elasticsearch, prefix string) string {
+	if prefix != "" {
+		return fmt.Sprintf("%v-%v", prefix, elasticsearch.OffshootName())
+	}
+	return elasticsearch.OffshootName()
+}
+
+func newCondition(conditionType api.ElasticsearchConditionType, status bool, reason, message string) api.ElasticsearchCondition {
+	cond := api.ElasticsearchCondition{
+		Type:    conditionType,
+		Status:  core.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	}
+	if status {
+		cond.Status = core.ConditionTrue
+	}
+	return cond
+}
+
+// setCondition adds or replaces the condition of the same type. LastTransitionTime
+// only changes when the status of the condition changes.
+func setCondition(conditions []api.ElasticsearchCondition, cond api.ElasticsearchCondition) []api.ElasticsearchCondition {
+	for i := range conditions {
+		if conditions[i].Type == cond.Type {
+			if conditions[i].Status == cond.Status {
+				cond.LastTransitionTime = conditions[i].LastTransitionTime
+			} else {
+				cond.LastTransitionTime = metav1.Now()
+			}
+			conditions[i] = cond
+			return conditions
+		}
+	}
+	cond.LastTransitionTime = metav1.Now()
+	return append(conditions, cond)
+}
+
+func getCondition(conditions []api.ElasticsearchCondition, conditionType api.ElasticsearchConditionType) *api.ElasticsearchCondition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+func isConditionTrue(conditions []api.ElasticsearchCondition, conditionType api.ElasticsearchConditionType) bool {
+	for _, cond := range conditions {
+		if cond.Type == conditionType {
+			return cond.Status == core.ConditionTrue
+		}
+	}
+	return false
+}
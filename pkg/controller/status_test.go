@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/appscode/go/types"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	amc "kubedb.dev/apimachinery/pkg/controller"
+	"kubedb.dev/elasticsearch/pkg/util/es"
+)
+
+func TestSetCondition(t *testing.T) {
+	then := metav1.NewTime(time.Now().Add(-time.Hour))
+	conditions := []api.ElasticsearchCondition{
+		{Type: api.ElasticsearchConditionReady, Status: core.ConditionTrue, LastTransitionTime: then},
+	}
+
+	// same status keeps the transition time
+	conditions = setCondition(conditions, newCondition(api.ElasticsearchConditionReady, true, "ClusterHealth", "cluster health is green"))
+	if len(conditions) != 1 || !conditions[0].LastTransitionTime.Equal(&then) || conditions[0].Reason != "ClusterHealth" {
+		t.Errorf("unexpected conditions %+v", conditions)
+	}
+
+	// changed status moves the transition time
+	conditions = setCondition(conditions, newCondition(api.ElasticsearchConditionReady, false, "ClusterHealth", "cluster health is red"))
+	if len(conditions) != 1 || conditions[0].LastTransitionTime.Equal(&then) || conditions[0].Status != core.ConditionFalse {
+		t.Errorf("unexpected conditions %+v", conditions)
+	}
+
+	// new types are appended
+	conditions = setCondition(conditions, newCondition(api.ElasticsearchConditionProvisioned, true, "StatefulSetsReady", ""))
+	if len(conditions) != 2 || conditions[1].LastTransitionTime.IsZero() {
+		t.Errorf("unexpected conditions %+v", conditions)
+	}
+
+	if isConditionTrue(conditions, api.ElasticsearchConditionReady) {
+		t.Errorf("expected Ready to be false")
+	}
+	if !isConditionTrue(conditions, api.ElasticsearchConditionProvisioned) {
+		t.Errorf("expected Provisioned to be true")
+	}
+	if isConditionTrue(conditions, api.ElasticsearchConditionDegradedHealth) {
+		t.Errorf("expected missing DegradedHealth to be false")
+	}
+}
+
+func TestSetHealthStatus(t *testing.T) {
+	cases := []struct {
+		name        string
+		provisioned bool
+		health      *es.ClusterHealth
+		healthErr   error
+		ready       bool
+		degraded    *bool
+	}{
+		{
+			name:        "green",
+			provisioned: true,
+			health:      &es.ClusterHealth{Status: es.HealthStatusGreen},
+			ready:       true,
+			degraded:    types.BoolP(false),
+		},
+		{
+			name:        "yellow",
+			provisioned: true,
+			health:      &es.ClusterHealth{Status: es.HealthStatusYellow, UnassignedShards: 2},
+			ready:       true,
+			degraded:    types.BoolP(true),
+		},
+		{
+			name:        "red",
+			provisioned: true,
+			health:      &es.ClusterHealth{Status: es.HealthStatusRed},
+			ready:       false,
+			degraded:    types.BoolP(true),
+		},
+		{
+			name:        "green, but StatefulSets are not ready",
+			provisioned: false,
+			health:      &es.ClusterHealth{Status: es.HealthStatusGreen},
+			ready:       false,
+			degraded:    types.BoolP(false),
+		},
+		{
+			name:        "health check failed",
+			provisioned: true,
+			healthErr:   errors.New("connection refused"),
+			ready:       false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			status := setHealthStatus(&api.ElasticsearchStatus{Health: api.ElasticsearchHealth("green")}, c.provisioned, "", c.health, c.healthErr)
+
+			if isConditionTrue(status.Conditions, api.ElasticsearchConditionReady) != c.ready {
+				t.Errorf("expected Ready to be %v, got %+v", c.ready, status.Conditions)
+			}
+			if isConditionTrue(status.Conditions, api.ElasticsearchConditionProvisioned) != c.provisioned {
+				t.Errorf("expected Provisioned to be %v, got %+v", c.provisioned, status.Conditions)
+			}
+			degraded := getCondition(status.Conditions, api.ElasticsearchConditionDegradedHealth)
+			if c.degraded == nil {
+				if degraded != nil {
+					t.Errorf("expected no DegradedHealth condition, got %+v", degraded)
+				}
+			} else if degraded == nil || (degraded.Status == core.ConditionTrue) != *c.degraded {
+				t.Errorf("expected DegradedHealth to be %v, got %+v", *c.degraded, degraded)
+			}
+
+			if c.healthErr != nil {
+				if status.Health != "" {
+					t.Errorf("expected health to be cleared, got %q", status.Health)
+				}
+				return
+			}
+			if string(status.Health) != c.health.Status {
+				t.Errorf("expected health %q, got %q", c.health.Status, status.Health)
+			}
+			if status.Shards == nil || status.Shards.Unassigned != int32(c.health.UnassignedShards) {
+				t.Errorf("unexpected shards %+v", status.Shards)
+			}
+		})
+	}
+}
+
+func TestGetReadyNodes(t *testing.T) {
+	elasticsearch := &api.Elasticsearch{
+		ObjectMeta: metav1.ObjectMeta{Name: "es", Namespace: "demo"},
+		Spec: api.ElasticsearchSpec{
+			Topology: &api.ElasticsearchClusterTopology{
+				Master: api.ElasticsearchNode{Prefix: "master", Replicas: types.Int32P(3)},
+				Data:   api.ElasticsearchNode{Prefix: "data", Replicas: types.Int32P(2)},
+				Warm:   api.ElasticsearchNode{Prefix: "warm", Replicas: types.Int32P(1)},
+				Client: api.ElasticsearchNode{Prefix: "client", Replicas: types.Int32P(1)},
+			},
+		},
+	}
+	statefulSet := func(name string, ready int32) *apps.StatefulSet {
+		return &apps.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "demo"},
+			Status:     apps.StatefulSetStatus{ReadyReplicas: ready},
+		}
+	}
+	c := &Controller{Controller: &amc.Controller{
+		Client: fake.NewSimpleClientset(
+			statefulSet("master-es", 3),
+			statefulSet("data-es", 1),
+			statefulSet("warm-es", 1),
+		),
+	}}
+
+	nodes, provisioned, message, err := c.getReadyNodes(elasticsearch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes.Master != 3 || nodes.Data != 1 || nodes.Warm != 1 || nodes.Client != 0 || nodes.Ingest != 0 {
+		t.Errorf("unexpected nodes %+v", nodes)
+	}
+	if provisioned {
+		t.Errorf("expected not to be provisioned")
+	}
+	if expected := "StatefulSets [data-es client-es] are not ready"; message != expected {
+		t.Errorf("expected message %q, got %q", expected, message)
+	}
+}
+
+func TestElasticsearchCRD(t *testing.T) {
+	crd := elasticsearchCRD()
+	columns := crd.Spec.AdditionalPrinterColumns
+	if len(columns) == 0 || columns[len(columns)-1].Name != "Age" {
+		t.Errorf("expected Age to be the last column, got %+v", columns)
+	}
+	for _, column := range columns {
+		if column.Name == "Health" {
+			return
+		}
+	}
+	t.Errorf("expected a Health column, got %+v", columns)
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	core_util "kmodules.xyz/client-go/core/v1"
+	"kubedb.dev/apimachinery/apis"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	"kubedb.dev/apimachinery/pkg/eventer"
+)
+
+const (
+	// upgradeStartTimeout is the time the first upgraded node gets to become ready
+	upgradeStartTimeout = 10 * time.Minute
+)
+
+// checkUpgradeFailure reports a failed upgrade in status, if the first node restarted with the
+// image of spec.version does not become ready. The rolling restart only moves on to the next
+// node once all nodes are ready, so the upgrade stops there and the rest of the cluster keeps
+// running the old version until the node is fixed or spec.version is reverted.
+func (c *Controller) checkUpgradeFailure(elasticsearch *api.Elasticsearch) error {
+	if isConditionTrue(elasticsearch.Status.Conditions, api.ElasticsearchConditionUpgradeFailed) {
+		return nil
+	}
+
+	elasticsearchVersion, err := c.ExtClient.CatalogV1alpha1().ElasticsearchVersions().Get(string(elasticsearch.Spec.Version), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	var upgraded []core.Pod
+	outdated := 0
+	for _, name := range nodeStatefulSetNames(elasticsearch) {
+		sts, err := c.Client.AppsV1().StatefulSets(elasticsearch.Namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		pods, err := c.getStatefulSetPods(sts)
+		if err != nil {
+			return err
+		}
+		for _, pod := range pods {
+			if databaseImage(pod) == elasticsearchVersion.Spec.DB.Image {
+				upgraded = append(upgraded, pod)
+			} else {
+				outdated++
+			}
+		}
+	}
+	// Not upgrading, or the first node has started before
+	if outdated == 0 || len(upgraded) != 1 {
+		return nil
+	}
+
+	pod := upgraded[0]
+	failed, reason := nodeFailedToStart(pod)
+	if !failed {
+		return nil
+	}
+	msg := fmt.Sprintf("Upgrade to version %v stopped, pod %s failed to start. Reason: %s", elasticsearch.Spec.Version, pod.Name, reason)
+	c.recorder.Event(elasticsearch, core.EventTypeWarning, eventer.EventReasonFailedToUpdate, msg)
+	return c.setUpgradeFailedCondition(elasticsearch, true, "NodeFailedToStart", msg)
+}
+
+func (c *Controller) setUpgradeFailedCondition(elasticsearch *api.Elasticsearch, failed bool, reason, message string) error {
+	_, err := util.UpdateElasticsearchStatus(c.ExtClient.KubedbV1alpha1(), elasticsearch, func(in *api.ElasticsearchStatus) *api.ElasticsearchStatus {
+		in.Conditions = setCondition(in.Conditions, newCondition(api.ElasticsearchConditionUpgradeFailed, failed, reason, message))
+		return in
+	}, apis.EnableStatusSubresource)
+	return err
+}
+
+func databaseImage(pod core.Pod) string {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == api.ResourceSingularElasticsearch {
+			return container.Image
+		}
+	}
+	return ""
+}
+
+// nodeFailedToStart reports whether the database container of a pod is crashing or can not
+// be pulled, or the pod has not become ready within upgradeStartTimeout.
+func nodeFailedToStart(pod core.Pod) (bool, string) {
+	if ready, _ := core_util.PodRunningAndReady(pod); ready {
+		return false, ""
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != api.ResourceSingularElasticsearch || status.Ready {
+			continue
+		}
+		if waiting := status.State.Waiting; waiting != nil {
+			switch waiting.Reason {
+			case "CrashLoopBackOff", "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+				return true, fmt.Sprintf("%s: %s", waiting.Reason, waiting.Message)
+			}
+		}
+	}
+	if time.Since(pod.CreationTimestamp.Time) > upgradeStartTimeout {
+		return true, fmt.Sprintf("not ready after %v", upgradeStartTimeout)
+	}
+	return false, ""
+}
@@ -1,7 +1,12 @@
 package controller
 
 import (
+	"reflect"
+
 	"github.com/appscode/go/log"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
 	core_util "kmodules.xyz/client-go/core/v1"
 	"kmodules.xyz/client-go/tools/queue"
 	"kubedb.dev/apimachinery/apis"
@@ -16,6 +21,37 @@ func (c *Controller) initWatcher() {
 	c.esInformer.AddEventHandler(queue.NewObservableUpdateHandler(c.esQueue.GetQueue(), apis.EnableStatusSubresource))
 }
 
+// initSecretWatcher enqueues the Elasticsearches, whose security config is rendered from a
+// secret, when the secret is created or its data changes, so changed passwords of users, and
+// changed LDAP bind credentials and CAs, reach the cluster.
+func (c *Controller) initSecretWatcher() {
+	c.KubeInformerFactory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueSecretReferrers,
+		UpdateFunc: func(old, new interface{}) {
+			if !reflect.DeepEqual(old.(*core.Secret).Data, new.(*core.Secret).Data) {
+				c.enqueueSecretReferrers(new)
+			}
+		},
+	})
+}
+
+func (c *Controller) enqueueSecretReferrers(obj interface{}) {
+	secret, ok := obj.(*core.Secret)
+	if !ok {
+		return
+	}
+	elasticsearches, err := c.esLister.Elasticsearches(secret.Namespace).List(labels.Everything())
+	if err != nil {
+		log.Errorln("failed to list Elasticsearch.", err)
+		return
+	}
+	for _, elasticsearch := range elasticsearches {
+		if elasticsearch.DeletionTimestamp == nil && securitySecrets(elasticsearch).Has(secret.Name) {
+			queue.Enqueue(c.esQueue.GetQueue(), elasticsearch)
+		}
+	}
+}
+
 func (c *Controller) runElasticsearch(key string) error {
 	log.Debugf("started processing, key: %v", key)
 	obj, exists, err := c.esInformer.GetIndexer().GetByKey(key)
@@ -26,11 +62,17 @@ func (c *Controller) runElasticsearch(key string) error {
 
 	if !exists {
 		log.Debugf("Elasticsearch %s does not exist anymore", key)
+		c.readinessWait.Delete(key)
+		c.rollingRestarts.Delete(key)
+		c.forgetForceMerges(key)
 	} else {
 		// Note that you also have to check the uid if you have a local controlled resource, which
 		// is dependent on the actual instance, to detect that a Elasticsearch was recreated with the same name
 		elasticsearch := obj.(*api.Elasticsearch).DeepCopy()
 		if elasticsearch.DeletionTimestamp != nil {
+			c.readinessWait.Delete(key)
+			c.rollingRestarts.Delete(key)
+			c.forgetForceMerges(key)
 			if core_util.HasFinalizer(elasticsearch.ObjectMeta, "kubedb.com") {
 				if err := c.terminate(elasticsearch); err != nil {
 					log.Errorln(err)
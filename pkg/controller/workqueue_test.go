@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"kmodules.xyz/client-go/tools/queue"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	api_listers "kubedb.dev/apimachinery/client/listers/kubedb/v1alpha1"
+)
+
+func TestEnqueueSecretReferrers(t *testing.T) {
+	withUser := func(name, namespace, secretName string) *api.Elasticsearch {
+		return &api.Elasticsearch{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: api.ElasticsearchSpec{
+				AuthPlugin: api.ElasticsearchAuthPluginSearchGuard,
+				Security: &api.ElasticsearchSecuritySpec{
+					Users: []api.ElasticsearchUser{
+						{
+							Name: "app",
+							PasswordSecret: core.SecretKeySelector{
+								LocalObjectReference: core.LocalObjectReference{Name: secretName},
+								Key:                  "password",
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	withLDAP := withUser("ldap", "demo", "other-password")
+	withLDAP.Spec.Security.LDAP = &api.ElasticsearchLDAPSpec{
+		BindSecret: &core.LocalObjectReference{Name: "ldap-bind"},
+		TLS: &api.ElasticsearchLDAPTLSConfig{
+			CASecret: &core.SecretKeySelector{
+				LocalObjectReference: core.LocalObjectReference{Name: "ldap-ca"},
+				Key:                  "ca.crt",
+			},
+		},
+	}
+
+	for _, elasticsearch := range []*api.Elasticsearch{
+		withLDAP,
+		withUser("referrer", "demo", "app-password"),
+		withUser("other-secret", "demo", "other-password"),
+		withUser("other-namespace", "prod", "app-password"),
+	} {
+		if err := indexer.Add(elasticsearch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &Controller{
+		esLister: api_listers.NewElasticsearchLister(indexer),
+		esQueue:  queue.New("Elasticsearch", 5, 1, nil),
+	}
+	defer c.esQueue.GetQueue().ShutDown()
+
+	cases := []struct {
+		secret   string
+		expected string
+	}{
+		{"app-password", "demo/referrer"},
+		{"ldap-bind", "demo/ldap"},
+		{"ldap-ca", "demo/ldap"},
+	}
+	for _, tc := range cases {
+		c.enqueueSecretReferrers(&core.Secret{ObjectMeta: metav1.ObjectMeta{Name: tc.secret, Namespace: "demo"}})
+		if n := c.esQueue.GetQueue().Len(); n != 1 {
+			t.Fatalf("expected 1 Elasticsearch to be enqueued for secret %s, got %d", tc.secret, n)
+		}
+		key, _ := c.esQueue.GetQueue().Get()
+		if key != tc.expected {
+			t.Errorf("expected %s to be enqueued for secret %s, got %v", tc.expected, tc.secret, key)
+		}
+		c.esQueue.GetQueue().Done(key)
+	}
+}
@@ -1,13 +1,12 @@
 package keytool
 
 import (
+	"bytes"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
-	"os"
 	"time"
 
-	"github.com/appscode/go/ioutil"
 	keystore "github.com/pavel-v-chernykh/keystore-go"
 	"github.com/pkg/errors"
 	"kubedb.dev/elasticsearch/third_party/golang/crypto/pkcs12"
@@ -15,30 +14,28 @@ import (
 
 const (
 	contentTypePrivateKey  = "PRIVATE KEY"
+	contentTypeRSAKey      = "RSA PRIVATE KEY"
+	contentTypeECKey       = "EC PRIVATE KEY"
 	contentTypeCertificate = "CERTIFICATE"
 	defaultCertificateType = "X509"
 )
 
-func PKCS12ToJKS(sourceFile, destinationFile, pass, alias string) error {
+// PKCS12ToJKS converts a PKCS#12 keystore to a JKS keystore with a private key entry
+// named alias. Both keystores are protected by pass.
+func PKCS12ToJKS(pfxData []byte, pass, alias string) ([]byte, error) {
 
-	// read source pkcs12 encoded file
-	srcContent, err := ioutil.ReadFile(sourceFile)
+	// decode pkcs12 encoded content
+	pvtKeys, certs, err := pkcs12.DecodeAll(pfxData, pass)
 	if err != nil {
-		return errors.Wrapf(err, "failed to read source PKCS12 encoded file %s", sourceFile)
-	}
-
-	// decode pkcs12 encoded source file's content
-	pvtKeys, certs, err := pkcs12.DecodeAll([]byte(srcContent), pass)
-	if err != nil {
-		return errors.Wrapf(err, "failed to decode pkcs12 encoded %s", sourceFile)
+		return nil, errors.Wrap(err, "failed to decode pkcs12 encoded keystore")
 	}
 
 	if len(pvtKeys) == 0 {
-		return errors.Errorf("missing privates keys in pkcs12 encoded %s", sourceFile)
+		return nil, errors.New("missing privates keys in pkcs12 encoded keystore")
 	}
 	keyBytes, err := x509.MarshalPKCS8PrivateKey(pvtKeys[0])
 	if err != nil {
-		return errors.Wrapf(err, "failed to marshal pvtKeys to PKCS8PrivateKey.")
+		return nil, errors.Wrapf(err, "failed to marshal pvtKeys to PKCS8PrivateKey.")
 	}
 
 	var certChain []keystore.Certificate
@@ -60,36 +57,34 @@ func PKCS12ToJKS(sourceFile, destinationFile, pass, alias string) error {
 		},
 	}
 
-	// write keystore(.jks) file
-	err = writeKeyStoreFile(ks, destinationFile, pass)
-	if err != nil {
-		return errors.Wrapf(err, "failed to create keystore: %s", destinationFile)
-	}
-	return nil
+	return encodeKeyStore(ks, pass)
 }
 
-func PEMToJKS(sourceFile, destinationFile, pass, alias string) error {
+// PEMToJKS converts a PEM encoded private key or certificate to a JKS keystore with
+// an entry named alias.
+func PEMToJKS(pemData []byte, pass, alias string) ([]byte, error) {
 
-	// read the source pem encoded file
-	srcContent, err := ioutil.ReadFile(sourceFile)
-	if err != nil {
-		return errors.Wrapf(err, "failed to read cert file: %s", sourceFile)
+	// decode pem content
+	decodedContent, _ := pem.Decode(pemData)
+	if decodedContent == nil {
+		return nil, errors.New("failed to create keystore: content is not pem encoded")
 	}
 
-	// decode .pem file content
-	decodedContent, _ := pem.Decode([]byte(srcContent))
-
 	var ks keystore.KeyStore
 
 	// create keystore based on content type
 	switch decodedContent.Type {
-	case contentTypePrivateKey:
+	case contentTypePrivateKey, contentTypeRSAKey, contentTypeECKey:
+		keyBytes, err := toPKCS8(decodedContent)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create keystore")
+		}
 		ks = keystore.KeyStore{
 			alias: &keystore.PrivateKeyEntry{
 				Entry: keystore.Entry{
 					CreationDate: time.Now(),
 				},
-				PrivKey: decodedContent.Bytes,
+				PrivKey: keyBytes,
 			},
 		}
 	case contentTypeCertificate:
@@ -105,25 +100,113 @@ func PEMToJKS(sourceFile, destinationFile, pass, alias string) error {
 			},
 		}
 	default:
-		return errors.Wrap(fmt.Errorf("unknown %s file content type", sourceFile), "failed to create keystore")
+		return nil, errors.Wrap(fmt.Errorf("unknown pem content type %s", decodedContent.Type), "failed to create keystore")
+
+	}
 
+	return encodeKeyStore(ks, pass)
+}
+
+// NewTrustStore creates a JKS keystore with a trusted certificate entry for each certificate.
+// The first entry is named alias, the others alias-1, alias-2 and so on.
+func NewTrustStore(certs []*x509.Certificate, pass, alias string) ([]byte, error) {
+	ks := make(keystore.KeyStore, len(certs))
+	for i, cert := range certs {
+		name := alias
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d", alias, i)
+		}
+		ks[name] = &keystore.TrustedCertificateEntry{
+			Entry: keystore.Entry{
+				CreationDate: time.Now(),
+			},
+			Certificate: keystore.Certificate{
+				Type:    defaultCertificateType,
+				Content: cert.Raw,
+			},
+		}
 	}
 
-	// write keystore(.jks) file
-	err = writeKeyStoreFile(ks, destinationFile, pass)
+	return encodeKeyStore(ks, pass)
+}
+
+// toPKCS8 returns the PKCS#8 encoding of a PEM encoded RSA or ECDSA private key, as
+// JKS keystores store private keys in PKCS#8.
+func toPKCS8(block *pem.Block) ([]byte, error) {
+	var key interface{}
+	var err error
+	switch block.Type {
+	case contentTypeRSAKey:
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case contentTypeECKey:
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return block.Bytes, nil
+	}
 	if err != nil {
-		return errors.Wrapf(err, "failed to create keystore: %s", destinationFile)
+		return nil, errors.Wrapf(err, "failed to parse %s", block.Type)
 	}
+	return x509.MarshalPKCS8PrivateKey(key)
+}
 
-	return nil
+func encodeKeyStore(keyStore keystore.KeyStore, password string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := keystore.Encode(&buf, keyStore, []byte(password)); err != nil {
+		return nil, errors.Wrap(err, "failed to encode keystore")
+	}
+	return buf.Bytes(), nil
 }
 
-func writeKeyStoreFile(keyStore keystore.KeyStore, filename string, password string) error {
-	o, err := os.Create(filename)
+// JKSCertificate returns the certificate of the entry named alias in a JKS keystore.
+// For private key entries, this is the first certificate of the chain.
+func JKSCertificate(jksData []byte, pass, alias string) (*x509.Certificate, error) {
+	ks, err := keystore.Decode(bytes.NewReader(jksData), []byte(pass))
 	if err != nil {
-		return err
+		return nil, errors.Wrap(err, "failed to decode keystore")
+	}
+
+	var content []byte
+	switch entry := ks[alias].(type) {
+	case *keystore.PrivateKeyEntry:
+		if len(entry.CertChain) == 0 {
+			return nil, errors.Errorf("missing certificate chain of %s in keystore", alias)
+		}
+		content = entry.CertChain[0].Content
+	case *keystore.TrustedCertificateEntry:
+		content = entry.Certificate.Content
+	default:
+		return nil, errors.Errorf("missing %s in keystore", alias)
 	}
-	defer o.Close()
+	return x509.ParseCertificate(content)
+}
 
-	return keystore.Encode(o, keyStore, []byte(password))
+// JKSToPKCS12 converts the private key entry named alias in a JKS keystore to a PKCS#12
+// keystore. Both keystores are protected by pass.
+func JKSToPKCS12(jksData []byte, pass, alias string) ([]byte, error) {
+	ks, err := keystore.Decode(bytes.NewReader(jksData), []byte(pass))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode keystore")
+	}
+
+	entry, ok := ks[alias].(*keystore.PrivateKeyEntry)
+	if !ok {
+		return nil, errors.Errorf("missing private key entry %s in keystore", alias)
+	}
+	if len(entry.CertChain) == 0 {
+		return nil, errors.Errorf("missing certificate chain of %s in keystore", alias)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(entry.PrivKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse private key of %s", alias)
+	}
+
+	var certs []*x509.Certificate
+	for _, c := range entry.CertChain {
+		cert, err := x509.ParseCertificate(c.Content)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse certificate chain of %s", alias)
+		}
+		certs = append(certs, cert)
+	}
+	return NewPKCS12(key, certs[0], certs[1:], pass)
 }
@@ -0,0 +1,115 @@
+package keytool
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestPKCS12ToJKS(t *testing.T) {
+	for _, algorithm := range []string{"rsa", "ecdsa"} {
+		t.Run(algorithm, func(t *testing.T) {
+			chain := newTestChain(t, algorithm)
+			pfxData, err := NewPKCS12(chain.key, chain.cert, []*x509.Certificate{chain.intermediate, chain.root}, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jksData, err := PKCS12ToJKS(pfxData, "secret", "node")
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert, err := JKSCertificate(jksData, "secret", "node")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cert.Equal(chain.cert) {
+				t.Errorf("expected the certificate of the key, got %s", cert.Subject.CommonName)
+			}
+			if _, err := JKSCertificate(jksData, "secret", "missing"); err == nil {
+				t.Errorf("expected an error for a missing alias")
+			}
+
+			// and back, keeping the chain with the intermediate CA
+			pfxData, err = JKSToPKCS12(jksData, "secret", "node")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, certPEM, err := PKCS12ToPEM(pfxData, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			certs := parsePEMCerts(t, certPEM)
+			if len(certs) != 3 {
+				t.Fatalf("expected 3 certificates, got %d", len(certs))
+			}
+			for i, cert := range chain.certs() {
+				if !certs[i].Equal(cert) {
+					t.Errorf("expected certificate %d to be %s, got %s", i, cert.Subject.CommonName, certs[i].Subject.CommonName)
+				}
+			}
+		})
+	}
+}
+
+func TestPEMToJKS(t *testing.T) {
+	chain := newTestChain(t, "ecdsa")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: contentTypeCertificate, Bytes: chain.root.Raw})
+	jksData, err := PEMToJKS(certPEM, "secret", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := JKSCertificate(jksData, "secret", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Equal(chain.root) {
+		t.Errorf("expected the root certificate, got %s", cert.Subject.CommonName)
+	}
+
+	rsaKey := newTestKey(t, "rsa").(*rsa.PrivateKey)
+	ecKey := newTestKey(t, "ecdsa").(*ecdsa.PrivateKey)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8DER, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range []*pem.Block{
+		{Type: contentTypeRSAKey, Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		{Type: contentTypeECKey, Bytes: ecDER},
+		{Type: contentTypePrivateKey, Bytes: pkcs8DER},
+	} {
+		if _, err := PEMToJKS(pem.EncodeToMemory(block), "secret", "key"); err != nil {
+			t.Errorf("failed to convert %s: %v", block.Type, err)
+		}
+	}
+
+	if _, err := PEMToJKS([]byte("not pem"), "secret", "key"); err == nil {
+		t.Errorf("expected an error for content that is not PEM encoded")
+	}
+	if _, err := PEMToJKS(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY"}), "secret", "key"); err == nil {
+		t.Errorf("expected an error for an unknown PEM type")
+	}
+}
+
+func TestNewTrustStore(t *testing.T) {
+	chain := newTestChain(t, "ecdsa")
+	jksData, err := NewTrustStore([]*x509.Certificate{chain.root, chain.intermediate}, "secret", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for alias, expected := range map[string]*x509.Certificate{"ca": chain.root, "ca-1": chain.intermediate} {
+		cert, err := JKSCertificate(jksData, "secret", alias)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cert.Equal(expected) {
+			t.Errorf("expected %s to be %s, got %s", alias, expected.Subject.CommonName, cert.Subject.CommonName)
+		}
+	}
+}
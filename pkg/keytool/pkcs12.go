@@ -0,0 +1,50 @@
+package keytool
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+	"kubedb.dev/elasticsearch/third_party/golang/crypto/pkcs12"
+)
+
+// NewPKCS12 creates a PKCS#12 keystore with the private key, its certificate and the
+// certificates of the CA chain, protected by pass.
+func NewPKCS12(key interface{}, cert *x509.Certificate, caCerts []*x509.Certificate, pass string) ([]byte, error) {
+	pfxData, err := pkcs12.Encode(rand.Reader, key, cert, caCerts, pass)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode pkcs12 keystore")
+	}
+	return pfxData, nil
+}
+
+// PKCS12ToPEM converts a PKCS#12 keystore to the PEM encoded private key and the PEM
+// encoded certificate chain, starting with the certificate of the key.
+func PKCS12ToPEM(pfxData []byte, pass string) ([]byte, []byte, error) {
+	pvtKeys, certs, err := pkcs12.DecodeAll(pfxData, pass)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decode pkcs12 encoded keystore")
+	}
+	if len(pvtKeys) == 0 {
+		return nil, nil, errors.New("missing privates keys in pkcs12 encoded keystore")
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(pvtKeys[0])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal private key to PKCS8")
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  contentTypePrivateKey,
+		Bytes: keyBytes,
+	})
+
+	var certPEM []byte
+	for _, cert := range certs {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{
+			Type:  contentTypeCertificate,
+			Bytes: cert.Raw,
+		})...)
+	}
+	return keyPEM, certPEM, nil
+}
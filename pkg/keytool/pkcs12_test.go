@@ -0,0 +1,141 @@
+package keytool
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testChain is a certificate signed by an intermediate CA, which is signed by a root CA.
+type testChain struct {
+	key          crypto.Signer
+	cert         *x509.Certificate
+	intermediate *x509.Certificate
+	root         *x509.Certificate
+}
+
+func newTestKey(t *testing.T, algorithm string) crypto.Signer {
+	var key crypto.Signer
+	var err error
+	switch algorithm {
+	case "rsa":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ecdsa":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newTestCert(t *testing.T, serial int64, cn string, isCA bool, pub crypto.PublicKey, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newTestChain(t *testing.T, algorithm string) *testChain {
+	rootKey := newTestKey(t, algorithm)
+	root := newTestCert(t, 1, "root", true, rootKey.Public(), nil, rootKey)
+	intermediateKey := newTestKey(t, algorithm)
+	intermediate := newTestCert(t, 2, "intermediate", true, intermediateKey.Public(), root, rootKey)
+	key := newTestKey(t, algorithm)
+	return &testChain{
+		key:          key,
+		cert:         newTestCert(t, 3, "node", false, key.Public(), intermediate, intermediateKey),
+		intermediate: intermediate,
+		root:         root,
+	}
+}
+
+func (c *testChain) certs() []*x509.Certificate {
+	return []*x509.Certificate{c.cert, c.intermediate, c.root}
+}
+
+func parsePEMCerts(t *testing.T, data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+}
+
+func TestPKCS12RoundTrip(t *testing.T) {
+	for _, algorithm := range []string{"rsa", "ecdsa"} {
+		t.Run(algorithm, func(t *testing.T) {
+			chain := newTestChain(t, algorithm)
+			pfxData, err := NewPKCS12(chain.key, chain.cert, []*x509.Certificate{chain.intermediate, chain.root}, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, _, err := PKCS12ToPEM(pfxData, "wrong"); err == nil {
+				t.Errorf("expected the wrong password to be rejected")
+			}
+			keyPEM, certPEM, err := PKCS12ToPEM(pfxData, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			block, _ := pem.Decode(keyPEM)
+			if block == nil || block.Type != contentTypePrivateKey {
+				t.Fatalf("expected a PEM encoded %s, got %q", contentTypePrivateKey, keyPEM)
+			}
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(key.(crypto.Signer).Public(), chain.key.Public()) {
+				t.Errorf("expected the decoded key to match the encoded key")
+			}
+
+			certs := parsePEMCerts(t, certPEM)
+			if len(certs) != 3 {
+				t.Fatalf("expected 3 certificates, got %d", len(certs))
+			}
+			for i, cert := range chain.certs() {
+				if !certs[i].Equal(cert) {
+					t.Errorf("expected certificate %d to be %s, got %s", i, cert.Subject.CommonName, certs[i].Subject.CommonName)
+				}
+			}
+		})
+	}
+}
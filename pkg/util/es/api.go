@@ -3,8 +3,10 @@ package es
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 
 	esv5 "gopkg.in/olivere/elastic.v5"
@@ -18,6 +20,7 @@ import (
 const (
 	KeyAdminUserName = "ADMIN_USERNAME"
 	KeyAdminPassword = "ADMIN_PASSWORD"
+	KeyRootCert      = "root.pem"
 )
 
 type ESClient interface {
@@ -26,9 +29,46 @@ type ESClient interface {
 	GetIndexNames() ([]string, error)
 	GetAllNodesInfo() ([]NodeInfo, error)
 	GetElasticsearchSummary(indexName string) (*api.ElasticsearchSummary, error)
+	GetClusterHealth() (*ClusterHealth, error)
+	CreateSnapshotRepository(name string, repository *SnapshotRepository) error
+	CreateSnapshot(repository, name string) error
+	GetSnapshotStatus(repository, name string) (*SnapshotStatus, error)
+	DeleteSnapshot(repository, name string) error
+	RestoreSnapshot(repository, name string) error
+	GetSnapshotRecovery() (*RecoveryProgress, error)
+	GetClusterSettings() (*ClusterSettings, error)
+	UpdateClusterSettings(transient map[string]interface{}) error
+	SyncedFlush() error
+	GetShardCountByNode() (map[string]int, error)
+	GetIndices(pattern string) ([]IndexInfo, error)
+	UpdateIndexSettings(index string, settings map[string]interface{}) error
+	IsIndexOnBoxType(index, boxType string) (bool, error)
+	GetMaxSegmentCount(index string) (int, error)
+	ForceMerge(index string, maxNumSegments int) error
+	DeleteIndex(index string) error
+	GetLicense() (*License, error)
 	Stop()
 }
 
+type ClusterHealth struct {
+	ClusterName         string `json:"cluster_name,omitempty"`
+	Status              string `json:"status,omitempty"`
+	TimedOut            bool   `json:"timed_out,omitempty"`
+	NumberOfNodes       int    `json:"number_of_nodes,omitempty"`
+	NumberOfDataNodes   int    `json:"number_of_data_nodes,omitempty"`
+	ActivePrimaryShards int    `json:"active_primary_shards,omitempty"`
+	ActiveShards        int    `json:"active_shards,omitempty"`
+	RelocatingShards    int    `json:"relocating_shards,omitempty"`
+	InitializingShards  int    `json:"initializing_shards,omitempty"`
+	UnassignedShards    int    `json:"unassigned_shards,omitempty"`
+}
+
+const (
+	HealthStatusGreen  = "green"
+	HealthStatusYellow = "yellow"
+	HealthStatusRed    = "red"
+)
+
 type NodeSetting struct {
 	Name   string `json:"name,omitempty"`
 	Data   string `json:"data,omitempty"`
@@ -53,29 +93,73 @@ type NodeInfo struct {
 	Settings *Setting `json:"settings,omitempty"`
 }
 
-func GetElasticClient(kc kubernetes.Interface, extClient cs.Interface, db *api.Elasticsearch, url string) (ESClient, error) {
+// IsMaster reports whether the node is master-eligible. Roles are only reported since 6.x,
+// so older nodes fall back to the node.master setting, which defaults to true.
+func (n NodeInfo) IsMaster() bool {
+	return n.hasRole("master", func(s *NodeSetting) string { return s.Master })
+}
+
+// IsData reports whether the node holds data.
+func (n NodeInfo) IsData() bool {
+	return n.hasRole("data", func(s *NodeSetting) string { return s.Data })
+}
+
+// IsIngest reports whether the node runs ingest pipelines.
+func (n NodeInfo) IsIngest() bool {
+	return n.hasRole("ingest", func(s *NodeSetting) string { return s.Ingest })
+}
+
+func (n NodeInfo) hasRole(role string, setting func(*NodeSetting) string) bool {
+	if len(n.Roles) > 0 {
+		return hasRole(n.Roles, role)
+	}
+	if n.Settings == nil || n.Settings.Node == nil {
+		return true
+	}
+	return setting(n.Settings.Node) != "false"
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// GetElasticClient returns a client, that authenticates as the admin user of the database secret.
+func GetElasticClient(kc kubernetes.Interface, extClient cs.Interface, db *api.Elasticsearch, url string, tlsOpts TLSOptions) (ESClient, error) {
 	secret, err := kc.CoreV1().Secrets(db.Namespace).Get(db.Spec.DatabaseSecret.SecretName, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
+	return NewElasticClient(extClient, db, url, tlsOpts, string(secret.Data[KeyAdminUserName]), string(secret.Data[KeyAdminPassword]))
+}
 
+// NewElasticClient returns a client, that authenticates with the given credentials.
+func NewElasticClient(extClient cs.Interface, db *api.Elasticsearch, url string, tlsOpts TLSOptions, username, password string) (ESClient, error) {
 	elasicsearchversion, err := extClient.CatalogV1alpha1().ElasticsearchVersions().Get(string(db.Spec.Version), metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
 
+	tlsConfig, err := getTLSConfig(url, tlsOpts)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := &http.Client{
+		Timeout: 0,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}
+
 	switch {
 	case strings.HasPrefix(elasicsearchversion.Spec.Version, "5."):
 		client, err := esv5.NewClient(
-			esv5.SetHttpClient(&http.Client{
-				Timeout: 0,
-				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: true,
-					},
-				},
-			}),
-			esv5.SetBasicAuth(string(secret.Data[KeyAdminUserName]), string(secret.Data[KeyAdminPassword])),
+			esv5.SetHttpClient(httpClient),
+			esv5.SetBasicAuth(username, password),
 			esv5.SetURL(url),
 			esv5.SetHealthcheck(false), // don't check health here. otherwise error message can be misleading for invalid credentials
 			esv5.SetSniff(false),
@@ -93,15 +177,8 @@ func GetElasticClient(kc kubernetes.Interface, extClient cs.Interface, db *api.E
 		return &ESClientV5{client: client}, nil
 	case strings.HasPrefix(string(elasicsearchversion.Spec.Version), "6."):
 		client, err := esv6.NewClient(
-			esv6.SetHttpClient(&http.Client{
-				Timeout: 0,
-				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: true,
-					},
-				},
-			}),
-			esv6.SetBasicAuth(string(secret.Data[KeyAdminUserName]), string(secret.Data[KeyAdminPassword])),
+			esv6.SetHttpClient(httpClient),
+			esv6.SetBasicAuth(username, password),
 			esv6.SetURL(url),
 			esv6.SetHealthcheck(false), // don't check health here. otherwise error message can be misleading for invalid credentials
 			esv6.SetSniff(false),
@@ -117,7 +194,105 @@ func GetElasticClient(kc kubernetes.Interface, extClient cs.Interface, db *api.E
 		}
 
 		return &ESClientV6{client: client}, nil
+	case strings.HasPrefix(string(elasicsearchversion.Spec.Version), "7."):
+		client := &ESClientV7{
+			client:   httpClient,
+			url:      url,
+			username: username,
+			password: password,
+		}
+
+		// do a manual health check to test client
+		if err := client.perform(context.Background(), http.MethodGet, "/_cluster/health", nil, nil); err != nil {
+			return nil, err
+		}
+
+		return client, nil
 	}
 
 	return nil, fmt.Errorf("unknown database verserion: %s", db.Spec.Version)
 }
+
+// TLSOptions tells the client how to verify the server certificate of the database.
+type TLSOptions struct {
+	// CACert is the PEM encoded CA, that issued the server certificate.
+	CACert []byte
+	// InsecureSkipVerify disables verification of the server certificate.
+	InsecureSkipVerify bool
+}
+
+// NewTLSOptions reads the CA from the certificate secret of the database. Verification
+// can only be skipped by the caller, from spec.tls.insecureSkipVerify.
+func NewTLSOptions(kc kubernetes.Interface, db *api.Elasticsearch, insecureSkipVerify bool) (TLSOptions, error) {
+	opts := TLSOptions{InsecureSkipVerify: insecureSkipVerify}
+	if !db.Spec.EnableSSL || insecureSkipVerify {
+		return opts, nil
+	}
+
+	if db.Spec.CertificateSecret == nil {
+		return opts, fmt.Errorf("spec.certificateSecret of Elasticsearch %s/%s is missing", db.Namespace, db.Name)
+	}
+	secret, err := kc.CoreV1().Secrets(db.Namespace).Get(db.Spec.CertificateSecret.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return opts, err
+	}
+	ca, ok := secret.Data[KeyRootCert]
+	if !ok {
+		return opts, fmt.Errorf("%s is missing in certificate secret %s/%s", KeyRootCert, secret.Namespace, secret.Name)
+	}
+	opts.CACert = ca
+	return opts, nil
+}
+
+// getTLSConfig trusts the CA of opts, so that the server certificate is verified, and requires it
+// to cover the host of rawurl. Certificates issued by older versions of the operator, that don't
+// cover it, are re-issued by the operator.
+func getTLSConfig(rawurl string, opts TLSOptions) (*tls.Config, error) {
+	if opts.InsecureSkipVerify {
+		return &tls.Config{
+			InsecureSkipVerify: true,
+		}, nil
+	}
+	if len(opts.CACert) == 0 {
+		return &tls.Config{}, nil
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(opts.CACert) {
+		return nil, fmt.Errorf("failed to parse %s", KeyRootCert)
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Hostname()
+
+	return &tls.Config{
+		// the chain and hostname are verified in VerifyPeerCertificate instead
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("server presented no certificate")
+			}
+			certs := make([]*x509.Certificate, 0, len(rawCerts))
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs = append(certs, cert)
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range certs[1:] {
+				intermediates.AddCert(cert)
+			}
+			if _, err := certs[0].Verify(x509.VerifyOptions{
+				Roots:         pool,
+				Intermediates: intermediates,
+			}); err != nil {
+				return err
+			}
+			return certs[0].VerifyHostname(host)
+		},
+	}, nil
+}
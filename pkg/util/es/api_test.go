@@ -0,0 +1,99 @@
+package es
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func pemCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func newTestCA(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pemCert(der)
+}
+
+func TestGetTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	ca := pemCert(server.Certificate().Raw)
+	// the certificate of the test server covers 127.0.0.1, but not localhost
+	localhostURL := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	cases := []struct {
+		name    string
+		url     string
+		opts    TLSOptions
+		wantErr bool
+	}{
+		{
+			name: "host covered by the certificate",
+			url:  server.URL,
+			opts: TLSOptions{CACert: ca},
+		},
+		{
+			name:    "host not covered by the certificate of the CA",
+			url:     localhostURL,
+			opts:    TLSOptions{CACert: ca},
+			wantErr: true,
+		},
+		{
+			name:    "certificate of another CA",
+			url:     server.URL,
+			opts:    TLSOptions{CACert: newTestCA(t)},
+			wantErr: true,
+		},
+		{
+			name: "insecure skip verify",
+			url:  server.URL,
+			opts: TLSOptions{CACert: newTestCA(t), InsecureSkipVerify: true},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tlsConfig, err := getTLSConfig(c.url, c.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			resp, err := client.Get(c.url)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != c.wantErr {
+				t.Errorf("expected error: %v, got %v", c.wantErr, err)
+			}
+		})
+	}
+
+	if _, err := getTLSConfig(server.URL, TLSOptions{CACert: []byte("not a certificate")}); err == nil {
+		t.Errorf("expected an invalid CA to be rejected")
+	}
+}
@@ -0,0 +1,88 @@
+package es
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ClusterSettings holds the persistent and transient cluster settings in flat format.
+type ClusterSettings struct {
+	Persistent map[string]interface{} `json:"persistent,omitempty"`
+	Transient  map[string]interface{} `json:"transient,omitempty"`
+}
+
+const (
+	SettingAllocationEnable      = "cluster.routing.allocation.enable"
+	SettingAllocationExcludeName = "cluster.routing.allocation.exclude._name"
+
+	AllocationEnablePrimaries = "primaries"
+)
+
+func getClusterSettings(perform performFunc) (*ClusterSettings, error) {
+	settings := new(ClusterSettings)
+	if err := perform(context.Background(), http.MethodGet, "/_cluster/settings?flat_settings=true", nil, settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// updateClusterSettings updates transient cluster settings. A nil value resets the setting to its default.
+func updateClusterSettings(perform performFunc, transient map[string]interface{}) error {
+	body := map[string]interface{}{
+		"transient": transient,
+	}
+	return perform(context.Background(), http.MethodPut, "/_cluster/settings", body, nil)
+}
+
+func syncedFlush(perform performFunc) error {
+	return perform(context.Background(), http.MethodPost, "/_flush/synced", nil, nil)
+}
+
+// getShardCountByNode counts the shards assigned to each node. A relocating shard is
+// counted for the node it is moving away from.
+func getShardCountByNode(perform performFunc) (map[string]int, error) {
+	var shards []struct {
+		Node string `json:"node"`
+	}
+	if err := perform(context.Background(), http.MethodGet, "/_cat/shards?format=json&h=node", nil, &shards); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, shard := range shards {
+		// unassigned shards have no node, relocating ones are reported as "<from> -> <to>"
+		fields := strings.Fields(shard.Node)
+		if len(fields) == 0 {
+			continue
+		}
+		counts[fields[0]]++
+	}
+	return counts, nil
+}
+
+// License is the X-Pack license of the cluster.
+type License struct {
+	Type               string `json:"type,omitempty"`
+	Status             string `json:"status,omitempty"`
+	ExpiryDateInMillis int64  `json:"expiry_date_in_millis,omitempty"`
+}
+
+// ExpiryDate returns when the license expires. Basic licenses don't expire.
+func (l *License) ExpiryDate() time.Time {
+	if l.ExpiryDateInMillis == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, l.ExpiryDateInMillis*int64(time.Millisecond))
+}
+
+func getLicense(perform performFunc) (*License, error) {
+	var resp struct {
+		License License `json:"license"`
+	}
+	if err := perform(context.Background(), http.MethodGet, "/_xpack/license", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.License, nil
+}
@@ -109,6 +109,111 @@ func (c *ESClientV6) GetElasticsearchSummary(indexName string) (*api.Elasticsear
 	return esSummary, nil
 }
 
+func (c *ESClientV6) GetClusterHealth() (*ClusterHealth, error) {
+	health, err := c.client.ClusterHealth().Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &ClusterHealth{
+		ClusterName:         health.ClusterName,
+		Status:              health.Status,
+		TimedOut:            health.TimedOut,
+		NumberOfNodes:       health.NumberOfNodes,
+		NumberOfDataNodes:   health.NumberOfDataNodes,
+		ActivePrimaryShards: health.ActivePrimaryShards,
+		ActiveShards:        health.ActiveShards,
+		RelocatingShards:    health.RelocatingShards,
+		InitializingShards:  health.InitializingShards,
+		UnassignedShards:    health.UnassignedShards,
+	}, nil
+}
+
+func (c *ESClientV6) CreateSnapshotRepository(name string, repository *SnapshotRepository) error {
+	return createSnapshotRepository(c.perform, name, repository)
+}
+
+func (c *ESClientV6) CreateSnapshot(repository, name string) error {
+	return createSnapshot(c.perform, repository, name)
+}
+
+func (c *ESClientV6) GetSnapshotStatus(repository, name string) (*SnapshotStatus, error) {
+	return getSnapshotStatus(c.perform, repository, name)
+}
+
+func (c *ESClientV6) DeleteSnapshot(repository, name string) error {
+	if err := deleteSnapshot(c.perform, repository, name); err != nil && !esv6.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *ESClientV6) RestoreSnapshot(repository, name string) error {
+	return restoreSnapshot(c.perform, repository, name)
+}
+
+func (c *ESClientV6) GetSnapshotRecovery() (*RecoveryProgress, error) {
+	return getSnapshotRecovery(c.perform)
+}
+
+func (c *ESClientV6) GetClusterSettings() (*ClusterSettings, error) {
+	return getClusterSettings(c.perform)
+}
+
+func (c *ESClientV6) UpdateClusterSettings(transient map[string]interface{}) error {
+	return updateClusterSettings(c.perform, transient)
+}
+
+func (c *ESClientV6) SyncedFlush() error {
+	return syncedFlush(c.perform)
+}
+
+func (c *ESClientV6) GetShardCountByNode() (map[string]int, error) {
+	return getShardCountByNode(c.perform)
+}
+
+func (c *ESClientV6) GetIndices(pattern string) ([]IndexInfo, error) {
+	return getIndices(c.perform, pattern)
+}
+
+func (c *ESClientV6) UpdateIndexSettings(index string, settings map[string]interface{}) error {
+	return updateIndexSettings(c.perform, index, settings)
+}
+
+func (c *ESClientV6) IsIndexOnBoxType(index, boxType string) (bool, error) {
+	return isIndexOnBoxType(c.perform, index, boxType)
+}
+
+func (c *ESClientV6) GetMaxSegmentCount(index string) (int, error) {
+	return getMaxSegmentCount(c.perform, index)
+}
+
+func (c *ESClientV6) ForceMerge(index string, maxNumSegments int) error {
+	return forceMerge(c.perform, index, maxNumSegments)
+}
+
+func (c *ESClientV6) DeleteIndex(index string) error {
+	return deleteIndex(c.perform, index)
+}
+
+func (c *ESClientV6) GetLicense() (*License, error) {
+	return getLicense(c.perform)
+}
+
+func (c *ESClientV6) perform(ctx context.Context, method, path string, body, result interface{}) error {
+	resp, err := c.client.PerformRequest(ctx, esv6.PerformRequestOptions{
+		Method: method,
+		Path:   path,
+		Body:   body,
+	})
+	if err != nil {
+		return err
+	}
+	if result == nil || len(resp.Body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Body, result)
+}
+
 func (c *ESClientV6) Stop() {
 	c.client.Stop()
 }
@@ -0,0 +1,289 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/appscode/go/crypto/rand"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+// ESClientV7 talks to Elasticsearch 7.x over its REST API. 7.x removed mapping types,
+// so requests are made typeless and document counts are reported under "_doc".
+type ESClientV7 struct {
+	client   *http.Client
+	url      string
+	username string
+	password string
+}
+
+var _ ESClient = &ESClientV7{}
+
+const docType = "_doc"
+
+// Error is returned when Elasticsearch responds with a non 2xx status code.
+type Error struct {
+	Status  int
+	Details string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("elastic: Error %d (%s): %s", e.Status, http.StatusText(e.Status), e.Details)
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Status == http.StatusNotFound
+}
+
+// perform sends a request to path and decodes the JSON response into result, if result is not nil.
+func (c *ESClientV7) perform(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.url, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &Error{Status: resp.StatusCode, Details: string(data)}
+	}
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+func (c *ESClientV7) CreateIndex(count int) error {
+	for i := 0; i < count; i++ {
+		err := c.perform(context.Background(), http.MethodPut, "/"+rand.Characters(5), nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ESClientV7) CountIndex() (int, error) {
+	indices, err := c.GetIndexNames()
+	if err != nil {
+		return 0, err
+	}
+	return len(indices), nil
+}
+
+func (c *ESClientV7) GetIndexNames() ([]string, error) {
+	var settings map[string]interface{}
+	if err := c.perform(context.Background(), http.MethodGet, "/_all/_settings", nil, &settings); err != nil {
+		return nil, err
+	}
+	indices := make([]string, 0, len(settings))
+	for name := range settings {
+		indices = append(indices, name)
+	}
+	return indices, nil
+}
+
+func (c *ESClientV7) GetAllNodesInfo() ([]NodeInfo, error) {
+	var data struct {
+		Nodes map[string]struct {
+			Name     string                 `json:"name"`
+			Roles    []string               `json:"roles"`
+			Settings map[string]interface{} `json:"settings"`
+		} `json:"nodes"`
+	}
+	if err := c.perform(context.Background(), http.MethodGet, "/_nodes/settings", nil, &data); err != nil {
+		return nil, err
+	}
+
+	nodesInfo := make([]NodeInfo, 0)
+	for _, v := range data.Nodes {
+		var info NodeInfo
+		info.Name = v.Name
+		info.Roles = v.Roles
+
+		js, err := json.Marshal(v.Settings)
+		if err != nil {
+			return nil, err
+		}
+		err = yaml.Unmarshal(js, &info.Settings)
+		if err != nil {
+			return nil, err
+		}
+
+		// 7.x no longer echoes node.{master,data,ingest} back in settings unless
+		// they were set explicitly, so fill them from the reported roles.
+		if info.Settings == nil {
+			info.Settings = &Setting{}
+		}
+		if info.Settings.Node == nil {
+			info.Settings.Node = &NodeSetting{Name: v.Name}
+		}
+		if info.Settings.Node.Master == "" {
+			info.Settings.Node.Master = fmt.Sprintf("%v", hasRole(v.Roles, "master"))
+		}
+		if info.Settings.Node.Data == "" {
+			info.Settings.Node.Data = fmt.Sprintf("%v", hasRole(v.Roles, "data"))
+		}
+		if info.Settings.Node.Ingest == "" {
+			info.Settings.Node.Ingest = fmt.Sprintf("%v", hasRole(v.Roles, "ingest"))
+		}
+		nodesInfo = append(nodesInfo, info)
+	}
+	return nodesInfo, nil
+}
+
+func (c *ESClientV7) GetElasticsearchSummary(indexName string) (*api.ElasticsearchSummary, error) {
+	esSummary := &api.ElasticsearchSummary{
+		IdCount: make(map[string]int64),
+	}
+
+	// Get analyzer
+	var analyzerData map[string]struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if err := c.perform(context.Background(), http.MethodGet, "/"+indexName+"/_settings", nil, &analyzerData); err != nil {
+		return nil, err
+	}
+
+	dataByte, err := json.Marshal(analyzerData[indexName].Settings["index"])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(dataByte, &esSummary.Setting); err != nil {
+		return nil, err
+	}
+
+	// get mappings
+	var mappingData map[string]interface{}
+	if err := c.perform(context.Background(), http.MethodGet, "/"+indexName+"/_mapping", nil, &mappingData); err != nil {
+		return nil, err
+	}
+	esSummary.Mapping = mappingData
+
+	// Count documents. Indices are typeless since 7.x, so there is a single "_doc" type.
+	var counts struct {
+		Count int64 `json:"count"`
+	}
+	if err := c.perform(context.Background(), http.MethodGet, "/"+indexName+"/_count", nil, &counts); err != nil {
+		return nil, err
+	}
+	esSummary.IdCount[docType] = counts.Count
+	return esSummary, nil
+}
+
+func (c *ESClientV7) GetClusterHealth() (*ClusterHealth, error) {
+	health := new(ClusterHealth)
+	if err := c.perform(context.Background(), http.MethodGet, "/_cluster/health", nil, health); err != nil {
+		return nil, err
+	}
+	return health, nil
+}
+
+func (c *ESClientV7) CreateSnapshotRepository(name string, repository *SnapshotRepository) error {
+	return createSnapshotRepository(c.perform, name, repository)
+}
+
+func (c *ESClientV7) CreateSnapshot(repository, name string) error {
+	return createSnapshot(c.perform, repository, name)
+}
+
+func (c *ESClientV7) GetSnapshotStatus(repository, name string) (*SnapshotStatus, error) {
+	return getSnapshotStatus(c.perform, repository, name)
+}
+
+func (c *ESClientV7) DeleteSnapshot(repository, name string) error {
+	if err := deleteSnapshot(c.perform, repository, name); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *ESClientV7) RestoreSnapshot(repository, name string) error {
+	return restoreSnapshot(c.perform, repository, name)
+}
+
+func (c *ESClientV7) GetSnapshotRecovery() (*RecoveryProgress, error) {
+	return getSnapshotRecovery(c.perform)
+}
+
+func (c *ESClientV7) GetClusterSettings() (*ClusterSettings, error) {
+	return getClusterSettings(c.perform)
+}
+
+func (c *ESClientV7) UpdateClusterSettings(transient map[string]interface{}) error {
+	return updateClusterSettings(c.perform, transient)
+}
+
+func (c *ESClientV7) SyncedFlush() error {
+	return syncedFlush(c.perform)
+}
+
+func (c *ESClientV7) GetShardCountByNode() (map[string]int, error) {
+	return getShardCountByNode(c.perform)
+}
+
+func (c *ESClientV7) GetIndices(pattern string) ([]IndexInfo, error) {
+	return getIndices(c.perform, pattern)
+}
+
+func (c *ESClientV7) UpdateIndexSettings(index string, settings map[string]interface{}) error {
+	return updateIndexSettings(c.perform, index, settings)
+}
+
+func (c *ESClientV7) IsIndexOnBoxType(index, boxType string) (bool, error) {
+	return isIndexOnBoxType(c.perform, index, boxType)
+}
+
+func (c *ESClientV7) GetMaxSegmentCount(index string) (int, error) {
+	return getMaxSegmentCount(c.perform, index)
+}
+
+func (c *ESClientV7) ForceMerge(index string, maxNumSegments int) error {
+	return forceMerge(c.perform, index, maxNumSegments)
+}
+
+func (c *ESClientV7) DeleteIndex(index string) error {
+	return deleteIndex(c.perform, index)
+}
+
+func (c *ESClientV7) GetLicense() (*License, error) {
+	return getLicense(c.perform)
+}
+
+func (c *ESClientV7) Stop() {
+	if t, ok := c.client.Transport.(*http.Transport); ok {
+		t.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,136 @@
+package es
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestClientV7(t *testing.T, handler http.HandlerFunc) (*ESClientV7, func()) {
+	server := httptest.NewServer(handler)
+	client := &ESClientV7{
+		client:   server.Client(),
+		url:      server.URL + "/",
+		username: "admin",
+		password: "secret",
+	}
+	return client, server.Close
+}
+
+func TestESClientV7_perform(t *testing.T) {
+	client, closeFn := newTestClientV7(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_cluster/settings" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected Content-Type %q", r.Header.Get("Content-Type"))
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			t.Errorf("unexpected basic auth %q:%q", user, pass)
+		}
+		w.Write([]byte(`{"acknowledged":true}`))
+	})
+	defer closeFn()
+
+	var result struct {
+		Acknowledged bool `json:"acknowledged"`
+	}
+	err := client.perform(context.Background(), http.MethodPut, "/_cluster/settings", map[string]interface{}{"transient": nil}, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Acknowledged {
+		t.Errorf("expected response to be decoded")
+	}
+}
+
+func TestESClientV7_performError(t *testing.T) {
+	client, closeFn := newTestClientV7(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"index_not_found_exception"}`))
+	})
+	defer closeFn()
+
+	err := client.perform(context.Background(), http.MethodGet, "/missing", nil, nil)
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	if e.Status != http.StatusNotFound || e.Details != `{"error":"index_not_found_exception"}` {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if !isNotFound(err) {
+		t.Errorf("expected isNotFound to be true")
+	}
+	if expected := `elastic: Error 404 (Not Found): {"error":"index_not_found_exception"}`; e.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, e.Error())
+	}
+
+	// a missing snapshot is already deleted
+	if err := client.DeleteSnapshot("repo", "snapshot"); err != nil {
+		t.Errorf("expected DeleteSnapshot to ignore 404, got %v", err)
+	}
+}
+
+func TestESClientV7_GetClusterHealth(t *testing.T) {
+	client, closeFn := newTestClientV7(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_cluster/health" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		w.Write([]byte(`{"cluster_name":"es","status":"yellow","number_of_nodes":3,"number_of_data_nodes":2,"relocating_shards":1}`))
+	})
+	defer closeFn()
+
+	health, err := client.GetClusterHealth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.ClusterName != "es" || health.Status != "yellow" || health.NumberOfNodes != 3 ||
+		health.NumberOfDataNodes != 2 || health.RelocatingShards != 1 {
+		t.Errorf("unexpected health %+v", health)
+	}
+}
+
+func TestESClientV7_GetAllNodesInfo(t *testing.T) {
+	client, closeFn := newTestClientV7(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"nodes":{
+			"a":{"name":"es-master-0","roles":["master"],"settings":{}},
+			"b":{"name":"es-data-0","roles":["data","ingest"],"settings":{"node":{"name":"es-data-0","master":"false"}}}
+		}}`))
+	})
+	defer closeFn()
+
+	nodes, err := client.GetAllNodesInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	}
+	expected := map[string][3]string{
+		"es-master-0": {"true", "false", "false"},
+		"es-data-0":   {"false", "true", "true"},
+	}
+	for _, node := range nodes {
+		e, ok := expected[node.Name]
+		if !ok {
+			t.Errorf("unexpected node %q", node.Name)
+			continue
+		}
+		got := [3]string{node.Settings.Node.Master, node.Settings.Node.Data, node.Settings.Node.Ingest}
+		if got != e {
+			t.Errorf("node %q: expected master/data/ingest %v, got %v", node.Name, e, got)
+		}
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	roles := []string{"master", "ingest"}
+	if !hasRole(roles, "master") || !hasRole(roles, "ingest") {
+		t.Errorf("expected roles %v to contain master and ingest", roles)
+	}
+	if hasRole(roles, "data") || hasRole(nil, "data") {
+		t.Errorf("expected roles to not contain data")
+	}
+}
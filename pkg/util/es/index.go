@@ -0,0 +1,138 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	IndexSettingCreationDate = "index.creation_date"
+	IndexSettingBoxType      = "index.routing.allocation.require.box_type"
+
+	// NodeAttributeBoxType is the node attribute matched by IndexSettingBoxType
+	NodeAttributeBoxType = "box_type"
+)
+
+// forceMergeWait is how long ForceMerge waits for the merge. Merges of large indices
+// take longer, but keep running on the nodes after the request is abandoned.
+var forceMergeWait = 30 * time.Second
+
+// IndexInfo holds the settings of an index used to manage its lifecycle.
+type IndexInfo struct {
+	Name         string
+	CreationDate time.Time
+	BoxType      string
+}
+
+// getIndices returns the indices matching pattern. It works on 5.x and later, so index
+// lifecycle can be managed without ILM.
+func getIndices(perform performFunc, pattern string) ([]IndexInfo, error) {
+	var data map[string]struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	path := fmt.Sprintf("/%s/_settings/%s,%s?flat_settings=true", pattern, IndexSettingCreationDate, IndexSettingBoxType)
+	if err := perform(context.Background(), http.MethodGet, path, nil, &data); err != nil {
+		return nil, err
+	}
+
+	indices := make([]IndexInfo, 0, len(data))
+	for name, index := range data {
+		info := IndexInfo{Name: name}
+		if v, ok := index.Settings[IndexSettingCreationDate].(string); ok {
+			millis, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q of index %s", IndexSettingCreationDate, v, name)
+			}
+			info.CreationDate = time.Unix(0, millis*int64(time.Millisecond))
+		}
+		if v, ok := index.Settings[IndexSettingBoxType].(string); ok {
+			info.BoxType = v
+		}
+		indices = append(indices, info)
+	}
+	return indices, nil
+}
+
+func updateIndexSettings(perform performFunc, index string, settings map[string]interface{}) error {
+	return perform(context.Background(), http.MethodPut, "/"+index+"/_settings", settings, nil)
+}
+
+// isIndexOnBoxType reports whether all shards of index are started on nodes with the
+// box_type attribute, ie, none of them is still initializing, relocating or unassigned.
+func isIndexOnBoxType(perform performFunc, index, boxType string) (bool, error) {
+	var attrs []struct {
+		Node  string `json:"node"`
+		Attr  string `json:"attr"`
+		Value string `json:"value"`
+	}
+	if err := perform(context.Background(), http.MethodGet, "/_cat/nodeattrs?format=json&h=node,attr,value", nil, &attrs); err != nil {
+		return false, err
+	}
+	nodes := make(map[string]bool)
+	for _, attr := range attrs {
+		if attr.Attr == NodeAttributeBoxType && attr.Value == boxType {
+			nodes[attr.Node] = true
+		}
+	}
+
+	var shards []struct {
+		State string `json:"state"`
+		Node  string `json:"node"`
+	}
+	if err := perform(context.Background(), http.MethodGet, "/_cat/shards/"+index+"?format=json&h=state,node", nil, &shards); err != nil {
+		return false, err
+	}
+	if len(shards) == 0 {
+		return false, nil
+	}
+	for _, shard := range shards {
+		if shard.State != "STARTED" || !nodes[strings.TrimSpace(shard.Node)] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// getMaxSegmentCount returns the highest number of segments of any copy of a shard of index.
+func getMaxSegmentCount(perform performFunc, index string) (int, error) {
+	var segments []struct {
+		Shard  string `json:"shard"`
+		Prirep string `json:"prirep"`
+		IP     string `json:"ip"`
+	}
+	if err := perform(context.Background(), http.MethodGet, "/_cat/segments/"+index+"?format=json&h=shard,prirep,ip", nil, &segments); err != nil {
+		return 0, err
+	}
+	counts := make(map[string]int)
+	max := 0
+	for _, segment := range segments {
+		key := segment.Shard + "/" + segment.Prirep + "/" + segment.IP
+		counts[key]++
+		if counts[key] > max {
+			max = counts[key]
+		}
+	}
+	return max, nil
+}
+
+// forceMerge starts merging index down to maxNumSegments. It returns once the merge finished,
+// or after forceMergeWait while the merge goes on in the background.
+func forceMerge(perform performFunc, index string, maxNumSegments int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), forceMergeWait)
+	defer cancel()
+
+	path := fmt.Sprintf("/%s/_forcemerge?max_num_segments=%d", index, maxNumSegments)
+	err := perform(ctx, http.MethodPost, path, nil, nil)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return nil
+	}
+	return err
+}
+
+func deleteIndex(perform performFunc, index string) error {
+	return perform(context.Background(), http.MethodDelete, "/"+index, nil, nil)
+}
@@ -0,0 +1,99 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePerform answers GET requests with the response of the first path prefix that matches.
+func fakePerform(responses map[string]string) performFunc {
+	return func(ctx context.Context, method, path string, body, result interface{}) error {
+		for prefix, response := range responses {
+			if strings.HasPrefix(path, prefix) {
+				return json.Unmarshal([]byte(response), result)
+			}
+		}
+		return fmt.Errorf("unexpected request %s %s", method, path)
+	}
+}
+
+func TestIsIndexOnBoxType(t *testing.T) {
+	nodeattrs := `[
+		{"node":"data-0","attr":"box_type","value":"hot"},
+		{"node":"warm-0","attr":"box_type","value":"warm"},
+		{"node":"warm-1","attr":"box_type","value":"warm"},
+		{"node":"warm-1","attr":"ml.enabled","value":"true"}
+	]`
+	cases := []struct {
+		name     string
+		shards   string
+		expected bool
+	}{
+		{"all on warm nodes", `[{"state":"STARTED","node":"warm-0"},{"state":"STARTED","node":"warm-1"}]`, true},
+		{"still on hot nodes", `[{"state":"STARTED","node":"warm-0"},{"state":"STARTED","node":"data-0"}]`, false},
+		{"relocating", `[{"state":"RELOCATING","node":"data-0 -> 10.0.0.1 abc warm-0"}]`, false},
+		{"unassigned", `[{"state":"UNASSIGNED","node":null}]`, false},
+		{"no shards", `[]`, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			perform := fakePerform(map[string]string{
+				"/_cat/nodeattrs":   nodeattrs,
+				"/_cat/shards/logs": c.shards,
+			})
+			moved, err := isIndexOnBoxType(perform, "logs", "warm")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if moved != c.expected {
+				t.Errorf("expected %v, got %v", c.expected, moved)
+			}
+		})
+	}
+}
+
+func TestGetMaxSegmentCount(t *testing.T) {
+	perform := fakePerform(map[string]string{
+		"/_cat/segments/logs": `[
+			{"shard":"0","prirep":"p","ip":"10.0.0.1"},
+			{"shard":"0","prirep":"p","ip":"10.0.0.1"},
+			{"shard":"0","prirep":"r","ip":"10.0.0.2"},
+			{"shard":"1","prirep":"p","ip":"10.0.0.2"},
+			{"shard":"1","prirep":"p","ip":"10.0.0.2"},
+			{"shard":"1","prirep":"p","ip":"10.0.0.2"}
+		]`,
+	})
+	count, err := getMaxSegmentCount(perform, "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 segments, got %d", count)
+	}
+}
+
+func TestForceMerge(t *testing.T) {
+	wait := forceMergeWait
+	forceMergeWait = 10 * time.Millisecond
+	defer func() { forceMergeWait = wait }()
+
+	// the merge goes on after the request is abandoned, so running out of time is no error
+	slow := func(ctx context.Context, method, path string, body, result interface{}) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if err := forceMerge(slow, "logs", 1); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	failed := func(ctx context.Context, method, path string, body, result interface{}) error {
+		return fmt.Errorf("index_not_found_exception")
+	}
+	if err := forceMerge(failed, "logs", 1); err == nil {
+		t.Errorf("expected the error to be returned")
+	}
+}
@@ -0,0 +1,112 @@
+package es
+
+import (
+	"context"
+	"net/http"
+)
+
+// SnapshotRepository is a repository registered through the _snapshot API.
+type SnapshotRepository struct {
+	Type     string                 `json:"type"`
+	Settings map[string]interface{} `json:"settings,omitempty"`
+}
+
+type SnapshotShardsStats struct {
+	Initializing int `json:"initializing"`
+	Started      int `json:"started"`
+	Finalizing   int `json:"finalizing"`
+	Done         int `json:"done"`
+	Failed       int `json:"failed"`
+	Total        int `json:"total"`
+}
+
+// SnapshotStatus reports the state and shard progress of a snapshot.
+type SnapshotStatus struct {
+	Snapshot    string              `json:"snapshot"`
+	Repository  string              `json:"repository"`
+	State       string              `json:"state"`
+	ShardsStats SnapshotShardsStats `json:"shards_stats"`
+}
+
+const (
+	SnapshotStateSuccess = "SUCCESS"
+	SnapshotStateFailed  = "FAILED"
+	SnapshotStatePartial = "PARTIAL"
+	SnapshotStateAborted = "ABORTED"
+)
+
+// RecoveryProgress counts the shards being recovered from snapshots.
+type RecoveryProgress struct {
+	Done  int
+	Total int
+}
+
+// performFunc sends a request to path and decodes the JSON response into result, if result is not nil.
+type performFunc func(ctx context.Context, method, path string, body, result interface{}) error
+
+func createSnapshotRepository(perform performFunc, name string, repository *SnapshotRepository) error {
+	return perform(context.Background(), http.MethodPut, "/_snapshot/"+name, repository, nil)
+}
+
+// createSnapshot starts a snapshot of all indices without waiting for it to complete.
+func createSnapshot(perform performFunc, repository, name string) error {
+	return perform(context.Background(), http.MethodPut, "/_snapshot/"+repository+"/"+name, nil, nil)
+}
+
+func getSnapshotStatus(perform performFunc, repository, name string) (*SnapshotStatus, error) {
+	var data struct {
+		Snapshots []SnapshotStatus `json:"snapshots"`
+	}
+	if err := perform(context.Background(), http.MethodGet, "/_snapshot/"+repository+"/"+name+"/_status", nil, &data); err != nil {
+		return nil, err
+	}
+	for i := range data.Snapshots {
+		if data.Snapshots[i].Snapshot == name {
+			return &data.Snapshots[i], nil
+		}
+	}
+	return nil, &Error{Status: http.StatusNotFound, Details: "snapshot " + repository + "/" + name + " is missing"}
+}
+
+func deleteSnapshot(perform performFunc, repository, name string) error {
+	return perform(context.Background(), http.MethodDelete, "/_snapshot/"+repository+"/"+name, nil, nil)
+}
+
+// restoreIndices selects the indices of a snapshot, that are restored. The index of the security
+// plugin is skipped, as it holds the users of the new cluster, and can't be replaced while open.
+const restoreIndices = "*,-searchguard,-.security*,-.opendistro_security"
+
+// restoreSnapshot starts restoring the indices of a snapshot without waiting for it to complete.
+func restoreSnapshot(perform performFunc, repository, name string) error {
+	body := map[string]interface{}{
+		"indices":              restoreIndices,
+		"include_global_state": false,
+	}
+	return perform(context.Background(), http.MethodPost, "/_snapshot/"+repository+"/"+name+"/_restore", body, nil)
+}
+
+func getSnapshotRecovery(perform performFunc) (*RecoveryProgress, error) {
+	var data map[string]struct {
+		Shards []struct {
+			Type  string `json:"type"`
+			Stage string `json:"stage"`
+		} `json:"shards"`
+	}
+	if err := perform(context.Background(), http.MethodGet, "/_recovery", nil, &data); err != nil {
+		return nil, err
+	}
+
+	progress := new(RecoveryProgress)
+	for _, index := range data {
+		for _, shard := range index.Shards {
+			if shard.Type != "SNAPSHOT" {
+				continue
+			}
+			progress.Total++
+			if shard.Stage == "DONE" {
+				progress.Done++
+			}
+		}
+	}
+	return progress, nil
+}
@@ -3,6 +3,7 @@ package e2e_test
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/appscode/go/log"
 	"github.com/appscode/go/types"
@@ -19,6 +20,7 @@ import (
 	store "kmodules.xyz/objectstore-api/api/v1"
 	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
 	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	"kubedb.dev/elasticsearch/pkg/controller"
 	"kubedb.dev/elasticsearch/test/e2e/framework"
 	"kubedb.dev/elasticsearch/test/e2e/matcher"
 	stashV1alpha1 "stash.appscode.dev/stash/apis/stash/v1alpha1"
@@ -63,6 +65,9 @@ var _ = Describe("Elasticsearch", func() {
 		By("Wait for Running elasticsearch")
 		f.EventuallyElasticsearchRunning(elasticsearch.ObjectMeta).Should(BeTrue())
 
+		By("Wait for cluster health in status")
+		f.EventuallyElasticsearchReady(elasticsearch.ObjectMeta).Should(BeTrue())
+
 		By("Wait for AppBinding to create")
 		f.EventuallyAppBinding(elasticsearch.ObjectMeta).Should(BeTrue())
 
@@ -276,6 +281,43 @@ var _ = Describe("Elasticsearch", func() {
 						It("should take Snapshot successfully", shouldRunSuccessfully)
 					})
 
+					Context("with dedicated ingest nodes", func() {
+						BeforeEach(func() {
+							elasticsearch.Spec.Topology.Ingest = api.ElasticsearchNode{
+								Replicas:    types.Int32P(1),
+								Prefix:      "ingest",
+								StorageType: api.StorageTypeEphemeral,
+							}
+							elasticsearch.Spec.Topology.RouteToIngest = true
+						})
+
+						It("should run successfully", shouldRunSuccessfully)
+					})
+
+					Context("with node pools", func() {
+						BeforeEach(func() {
+							topology := elasticsearch.Spec.Topology
+							pool := func(name string, node api.ElasticsearchNode, attributes map[string]string, roles ...api.ElasticsearchNodeRole) api.ElasticsearchNodePool {
+								return api.ElasticsearchNodePool{
+									Name:       name,
+									Roles:      roles,
+									Attributes: attributes,
+									Replicas:   node.Replicas,
+									Storage:    node.Storage,
+								}
+							}
+							elasticsearch.Spec.Topology = &api.ElasticsearchClusterTopology{
+								NodePools: []api.ElasticsearchNodePool{
+									pool("master", topology.Master, nil, api.ElasticsearchNodeRoleMaster),
+									pool("hot", topology.Data, map[string]string{"box_type": "hot", "zone": "a"}, api.ElasticsearchNodeRoleData, api.ElasticsearchNodeRoleIngest),
+									pool("coordinating", topology.Client, nil, api.ElasticsearchNodeRoleCoordinating),
+								},
+							}
+						})
+
+						It("should run successfully", shouldRunSuccessfully)
+					})
+
 				})
 			})
 
@@ -359,6 +401,56 @@ var _ = Describe("Elasticsearch", func() {
 					Expect(err).NotTo(HaveOccurred())
 				})
 			})
+
+			Context("with LDAP", func() {
+				var ldapServer *framework.LDAPServer
+
+				BeforeEach(func() {
+					ldapServer = f.LDAPServer()
+
+					By("Create LDAP server")
+					err := f.CreateLDAPServer(ldapServer)
+					Expect(err).NotTo(HaveOccurred())
+					f.EventuallyLDAPServerReady(ldapServer).Should(BeTrue())
+				})
+
+				AfterEach(func() {
+					err := f.DeleteLDAPServer(ldapServer)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("should authenticate LDAP users with their LDAP groups as backend roles", func() {
+					elasticsearch.Spec.AuthPlugin = api.ElasticsearchAuthPluginSearchGuard
+					elasticsearch.Spec.Security = &api.ElasticsearchSecuritySpec{
+						LDAP: ldapServer.Spec(),
+						RoleMappings: []api.ElasticsearchRoleMapping{
+							{
+								Role:         "sg_all_access",
+								BackendRoles: []string{framework.LDAPGroup},
+							},
+						},
+					}
+					createAndWaitForRunning()
+
+					By("Check for Elastic client")
+					f.EventuallyElasticsearchClientReady(elasticsearch.ObjectMeta).Should(BeTrue())
+
+					By("Connect as LDAP user")
+					Eventually(func() error {
+						client, err := f.GetElasticClientWithAuth(elasticsearch.ObjectMeta, framework.LDAPUser, framework.LDAPUserPassword)
+						if err != nil {
+							return err
+						}
+						client.Stop()
+						f.Tunnel.Close()
+						return nil
+					}, time.Minute*5, time.Second*5).Should(Succeed())
+
+					By("Reject wrong password of LDAP user")
+					_, err := f.GetElasticClientWithAuth(elasticsearch.ObjectMeta, framework.LDAPUser, "wrong-password")
+					Expect(err).To(HaveOccurred())
+				})
+			})
 		})
 
 		Context("Snapshot", func() {
@@ -680,6 +772,14 @@ var _ = Describe("Elasticsearch", func() {
 					})
 				})
 
+				Context("with native snapshot", func() {
+					BeforeEach(func() {
+						snapshot.Labels[controller.LabelSnapshotMode] = controller.SnapshotModeNative
+					})
+
+					It("should take Snapshot successfully", shouldTakeSnapshot)
+				})
+
 				Context("Delete One Snapshot keeping others", func() {
 
 					It("Delete One Snapshot keeping others", func() {
@@ -14,19 +14,39 @@ import (
 func (f *Framework) GetClientPodName(elasticsearch *api.Elasticsearch) string {
 	clientName := elasticsearch.Name
 
-	if elasticsearch.Spec.Topology != nil {
-		if elasticsearch.Spec.Topology.Client.Prefix != "" {
-			clientName = fmt.Sprintf("%v-%v", elasticsearch.Spec.Topology.Client.Prefix, clientName)
+	if topology := elasticsearch.Spec.Topology; topology != nil {
+		prefix := topology.Client.Prefix
+		for _, pool := range topology.NodePools {
+			if hasRole(pool, api.ElasticsearchNodeRoleCoordinating) {
+				prefix = pool.Name
+				break
+			}
+		}
+		if prefix != "" {
+			clientName = fmt.Sprintf("%v-%v", prefix, clientName)
 		}
 	}
 	return fmt.Sprintf("%v-0", clientName)
 }
 
+func hasRole(pool api.ElasticsearchNodePool, role api.ElasticsearchNodeRole) bool {
+	for _, r := range pool.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
 func (f *Framework) GetElasticClient(meta metav1.ObjectMeta) (es.ESClient, error) {
 	db, err := f.GetElasticsearch(meta)
 	if err != nil {
 		return nil, err
 	}
+	tlsOpts, err := f.getTLSOptions(db)
+	if err != nil {
+		return nil, err
+	}
 	clientPodName := f.GetClientPodName(db)
 	f.Tunnel = portforward.NewTunnel(
 		f.kubeClient.CoreV1().RESTClient(),
@@ -40,5 +60,10 @@ func (f *Framework) GetElasticClient(meta metav1.ObjectMeta) (es.ESClient, error
 	}
 	url := fmt.Sprintf("%v://127.0.0.1:%d", db.GetConnectionScheme(), f.Tunnel.Local)
 	c := controller.New(nil, f.kubeClient, nil, f.dbClient, nil, nil, nil, nil, nil, amc.Config{}, nil)
-	return es.GetElasticClient(c.Client, c.ExtClient, db, url)
+	return es.GetElasticClient(c.Client, c.ExtClient, db, url, tlsOpts)
+}
+
+// getTLSOptions returns how the server certificate of the database is verified.
+func (f *Framework) getTLSOptions(db *api.Elasticsearch) (es.TLSOptions, error) {
+	return es.NewTLSOptions(f.kubeClient, db, db.Spec.TLS != nil && db.Spec.TLS.InsecureSkipVerify)
 }
@@ -173,6 +173,26 @@ func (f *Framework) EventuallyElasticsearchRunning(meta metav1.ObjectMeta) Gomeg
 	)
 }
 
+func (f *Framework) EventuallyElasticsearchReady(meta metav1.ObjectMeta) GomegaAsyncAssertion {
+	return Eventually(
+		func() bool {
+			elasticsearch, err := f.dbClient.KubedbV1alpha1().Elasticsearches(meta.Namespace).Get(meta.Name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			if elasticsearch.Status.Health == "" || elasticsearch.Status.Nodes == nil || elasticsearch.Status.Shards == nil {
+				return false
+			}
+			for _, cond := range elasticsearch.Status.Conditions {
+				if cond.Type == api.ElasticsearchConditionReady {
+					return cond.Status == core.ConditionTrue
+				}
+			}
+			return false
+		},
+		time.Minute*5,
+		time.Second*10,
+	)
+}
+
 func (f *Framework) EventuallyElasticsearchClientReady(meta metav1.ObjectMeta) GomegaAsyncAssertion {
 	return Eventually(
 		func() bool {
@@ -0,0 +1,278 @@
+package framework
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/appscode/go/crypto/rand"
+	"github.com/appscode/go/types"
+	. "github.com/onsi/gomega"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"kmodules.xyz/client-go/tools/portforward"
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	amc "kubedb.dev/apimachinery/pkg/controller"
+	"kubedb.dev/elasticsearch/pkg/controller"
+	"kubedb.dev/elasticsearch/pkg/util/es"
+)
+
+// The LDAP server has a single user, that is a member of LDAPGroup.
+const (
+	LDAPImage         = "osixia/openldap:1.2.4"
+	LDAPBaseDN        = "dc=example,dc=org"
+	LDAPAdminPassword = "admin"
+	LDAPUser          = "alice"
+	LDAPUserPassword  = "alice-password"
+	LDAPGroup         = "es-admins"
+	ldapPort          = 389
+)
+
+var ldapBootstrap = fmt.Sprintf(`
+dn: ou=people,%[1]s
+objectClass: organizationalUnit
+ou: people
+
+dn: uid=%[2]s,ou=people,%[1]s
+objectClass: inetOrgPerson
+cn: %[2]s
+sn: %[2]s
+uid: %[2]s
+userPassword: %[3]s
+
+dn: ou=groups,%[1]s
+objectClass: organizationalUnit
+ou: groups
+
+dn: cn=%[4]s,ou=groups,%[1]s
+objectClass: groupOfNames
+cn: %[4]s
+member: uid=%[2]s,ou=people,%[1]s
+`, LDAPBaseDN, LDAPUser, LDAPUserPassword, LDAPGroup)
+
+// LDAPServer is an OpenLDAP stand-in for an LDAP server or Active Directory.
+type LDAPServer struct {
+	ConfigMap  *core.ConfigMap
+	Deployment *apps.Deployment
+	Service    *core.Service
+}
+
+func (i *Invocation) LDAPServer() *LDAPServer {
+	name := rand.WithUniqSuffix("ldap")
+	labels := map[string]string{
+		"app":  i.app,
+		"ldap": name,
+	}
+	meta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: i.namespace,
+		Labels:    labels,
+	}
+	return &LDAPServer{
+		ConfigMap: &core.ConfigMap{
+			ObjectMeta: meta,
+			Data: map[string]string{
+				"50-bootstrap.ldif": ldapBootstrap,
+			},
+		},
+		Deployment: &apps.Deployment{
+			ObjectMeta: meta,
+			Spec: apps.DeploymentSpec{
+				Replicas: types.Int32P(1),
+				Selector: &metav1.LabelSelector{
+					MatchLabels: labels,
+				},
+				Template: core.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: labels,
+					},
+					Spec: core.PodSpec{
+						Containers: []core.Container{
+							{
+								Name:  "ldap",
+								Image: LDAPImage,
+								// copy the bootstrap ldif out of the read-only volume
+								Args: []string{"--copy-service"},
+								Env: []core.EnvVar{
+									{
+										Name:  "LDAP_DOMAIN",
+										Value: "example.org",
+									},
+									{
+										Name:  "LDAP_ADMIN_PASSWORD",
+										Value: LDAPAdminPassword,
+									},
+									{
+										Name:  "LDAP_TLS",
+										Value: "false",
+									},
+								},
+								Ports: []core.ContainerPort{
+									{
+										Name:          "ldap",
+										ContainerPort: ldapPort,
+									},
+								},
+								ReadinessProbe: &core.Probe{
+									Handler: core.Handler{
+										TCPSocket: &core.TCPSocketAction{
+											Port: intstr.FromInt(ldapPort),
+										},
+									},
+								},
+								VolumeMounts: []core.VolumeMount{
+									{
+										Name:      "bootstrap",
+										MountPath: "/container/service/slapd/assets/config/bootstrap/ldif/custom",
+									},
+								},
+							},
+						},
+						Volumes: []core.Volume{
+							{
+								Name: "bootstrap",
+								VolumeSource: core.VolumeSource{
+									ConfigMap: &core.ConfigMapVolumeSource{
+										LocalObjectReference: core.LocalObjectReference{
+											Name: name,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		Service: &core.Service{
+			ObjectMeta: meta,
+			Spec: core.ServiceSpec{
+				Selector: labels,
+				Ports: []core.ServicePort{
+					{
+						Name:       "ldap",
+						Port:       ldapPort,
+						TargetPort: intstr.FromInt(ldapPort),
+					},
+				},
+			},
+		},
+	}
+}
+
+// Host is the address of the LDAP server in the cluster.
+func (s *LDAPServer) Host() string {
+	return fmt.Sprintf("%v.%v.svc:%d", s.Service.Name, s.Service.Namespace, ldapPort)
+}
+
+// BindSecret holds the bind DN and password of the LDAP admin.
+func (s *LDAPServer) BindSecret() *core.Secret {
+	return &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.Service.Name + "-bind",
+			Namespace: s.Service.Namespace,
+		},
+		Type: core.SecretTypeBasicAuth,
+		StringData: map[string]string{
+			core.BasicAuthUsernameKey: "cn=admin," + LDAPBaseDN,
+			core.BasicAuthPasswordKey: LDAPAdminPassword,
+		},
+	}
+}
+
+// Spec is spec.security.ldap for the LDAP server, that resolves the groups of a user as backend roles.
+func (s *LDAPServer) Spec() *api.ElasticsearchLDAPSpec {
+	return &api.ElasticsearchLDAPSpec{
+		Hosts: []string{s.Host()},
+		BindSecret: &core.LocalObjectReference{
+			Name: s.BindSecret().Name,
+		},
+		UserBase:          "ou=people," + LDAPBaseDN,
+		UserSearch:        "(uid={0})",
+		UsernameAttribute: "uid",
+		RoleBase:          "ou=groups," + LDAPBaseDN,
+		RoleSearch:        "(member={0})",
+		RoleName:          "cn",
+	}
+}
+
+func (f *Framework) CreateLDAPServer(s *LDAPServer) error {
+	if _, err := f.kubeClient.CoreV1().ConfigMaps(s.ConfigMap.Namespace).Create(s.ConfigMap); err != nil {
+		return err
+	}
+	if _, err := f.kubeClient.CoreV1().Secrets(s.Service.Namespace).Create(s.BindSecret()); err != nil {
+		return err
+	}
+	if _, err := f.kubeClient.AppsV1().Deployments(s.Deployment.Namespace).Create(s.Deployment); err != nil {
+		return err
+	}
+	_, err := f.kubeClient.CoreV1().Services(s.Service.Namespace).Create(s.Service)
+	return err
+}
+
+func (f *Framework) DeleteLDAPServer(s *LDAPServer) error {
+	for _, del := range []func() error{
+		func() error {
+			return f.kubeClient.CoreV1().Services(s.Service.Namespace).Delete(s.Service.Name, deleteInForeground())
+		},
+		func() error {
+			return f.kubeClient.AppsV1().Deployments(s.Deployment.Namespace).Delete(s.Deployment.Name, deleteInForeground())
+		},
+		func() error {
+			return f.kubeClient.CoreV1().Secrets(s.Service.Namespace).Delete(s.BindSecret().Name, deleteInForeground())
+		},
+		func() error {
+			return f.kubeClient.CoreV1().ConfigMaps(s.ConfigMap.Namespace).Delete(s.ConfigMap.Name, deleteInForeground())
+		},
+	} {
+		if err := del(); err != nil && !kerr.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Framework) EventuallyLDAPServerReady(s *LDAPServer) GomegaAsyncAssertion {
+	return Eventually(
+		func() bool {
+			deploy, err := f.kubeClient.AppsV1().Deployments(s.Deployment.Namespace).Get(s.Deployment.Name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			return deploy.Status.ReadyReplicas == 1
+		},
+		time.Minute*5,
+		time.Second*5,
+	)
+}
+
+// GetElasticClientWithAuth is GetElasticClient with the given credentials, instead of the
+// admin credentials of the database secret. The tunnel is closed, if the client fails to connect.
+func (f *Framework) GetElasticClientWithAuth(meta metav1.ObjectMeta, username, password string) (es.ESClient, error) {
+	db, err := f.GetElasticsearch(meta)
+	if err != nil {
+		return nil, err
+	}
+	tlsOpts, err := f.getTLSOptions(db)
+	if err != nil {
+		return nil, err
+	}
+	f.Tunnel = portforward.NewTunnel(
+		f.kubeClient.CoreV1().RESTClient(),
+		f.restConfig,
+		db.Namespace,
+		f.GetClientPodName(db),
+		api.ElasticsearchRestPort,
+	)
+	if err := f.Tunnel.ForwardPort(); err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%v://127.0.0.1:%d", db.GetConnectionScheme(), f.Tunnel.Local)
+	c := controller.New(nil, f.kubeClient, nil, f.dbClient, nil, nil, nil, nil, nil, amc.Config{}, nil)
+	client, err := es.NewElasticClient(c.ExtClient, db, url, tlsOpts, username, password)
+	if err != nil {
+		f.Tunnel.Close()
+		return nil, err
+	}
+	return client, nil
+}
@@ -5,19 +5,21 @@
 // Package pkcs12 implements some of PKCS#12.
 //
 // This implementation is distilled from https://tools.ietf.org/html/rfc7292
-// and referenced documents. It is intended for decoding P12/PFX-stored
-// certificates and keys for use with the crypto/tls package.
+// and referenced documents. It is intended for decoding and encoding
+// P12/PFX-stored certificates and keys for use with the crypto/tls package.
 package pkcs12
 
 import (
 	"crypto/ecdsa"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/hex"
 	"encoding/pem"
 	"errors"
+	"io"
 )
 
 var (
@@ -57,6 +59,8 @@ func (i encryptedContentInfo) Algorithm() pkix.AlgorithmIdentifier {
 
 func (i encryptedContentInfo) Data() []byte { return i.EncryptedContent }
 
+func (i *encryptedContentInfo) SetData(data []byte) { i.EncryptedContent = data }
+
 type safeBag struct {
 	Id         asn1.ObjectIdentifier
 	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
@@ -81,6 +85,10 @@ func (i encryptedPrivateKeyInfo) Data() []byte {
 	return i.EncryptedData
 }
 
+func (i *encryptedPrivateKeyInfo) SetData(data []byte) {
+	i.EncryptedData = data
+}
+
 // PEM block types
 const (
 	certificateType = "CERTIFICATE"
@@ -383,3 +391,160 @@ func getSafeContents(p12Data, password []byte) (bags []safeBag, updatedPassword
 
 	return bags, password, nil
 }
+
+// Encode produces pfxData containing one private key (privateKey), an
+// end-entity certificate (certificate), and any number of CA certificates
+// (caCerts).
+//
+// The certificates are stored in an encrypted safe and the private key in a
+// shrouded key bag, both encrypted with password using
+// pbeWithSHAAnd3-KeyTripleDES-CBC, and the PFX PDU is protected by a
+// SHA-1 HMAC. The rand argument is used to provide entropy for the salts,
+// and can be set to rand.Reader from the crypto/rand package.
+func Encode(rand io.Reader, privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, password string) (pfxData []byte, err error) {
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var pfx pfxPdu
+	pfx.Version = 3
+
+	certFingerprint := sha1.Sum(certificate.Raw)
+	var localKeyIdAttr pkcs12Attribute
+	localKeyIdAttr.Id = oidLocalKeyID
+	localKeyIdAttr.Value.Class = asn1.ClassUniversal
+	localKeyIdAttr.Value.Tag = asn1.TagSet
+	localKeyIdAttr.Value.IsCompound = true
+	if localKeyIdAttr.Value.Bytes, err = asn1.Marshal(certFingerprint[:]); err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	certBag, err := makeCertBag(certificate.Raw, []pkcs12Attribute{localKeyIdAttr})
+	if err != nil {
+		return nil, err
+	}
+	certBags = append(certBags, *certBag)
+
+	for _, cert := range caCerts {
+		if certBag, err = makeCertBag(cert.Raw, nil); err != nil {
+			return nil, err
+		}
+		certBags = append(certBags, *certBag)
+	}
+
+	var keyBag safeBag
+	keyBag.Id = oidPKCS8ShroundedKeyBag
+	keyBag.Value.Class = asn1.ClassContextSpecific
+	keyBag.Value.Tag = 0
+	keyBag.Value.IsCompound = true
+	if keyBag.Value.Bytes, err = encodePkcs8ShroudedKeyBag(rand, privateKey, encodedPassword); err != nil {
+		return nil, err
+	}
+	keyBag.Attributes = append(keyBag.Attributes, localKeyIdAttr)
+
+	// Construct an authenticated safe with two SafeContents.
+	// The first SafeContents is encrypted and contains the cert bags.
+	// The second SafeContents is unencrypted and contains the shrouded key bag.
+	var authenticatedSafe [2]contentInfo
+	if authenticatedSafe[0], err = makeSafeContents(rand, certBags, encodedPassword); err != nil {
+		return nil, err
+	}
+	if authenticatedSafe[1], err = makeSafeContents(rand, []safeBag{keyBag}, nil); err != nil {
+		return nil, err
+	}
+
+	var authenticatedSafeBytes []byte
+	if authenticatedSafeBytes, err = asn1.Marshal(authenticatedSafe[:]); err != nil {
+		return nil, err
+	}
+
+	// compute the MAC
+	pfx.MacData.Mac.Algorithm.Algorithm = oidSHA1
+	pfx.MacData.MacSalt = make([]byte, 8)
+	if _, err = rand.Read(pfx.MacData.MacSalt); err != nil {
+		return nil, err
+	}
+	pfx.MacData.Iterations = 2048
+	if err = computeMac(&pfx.MacData, authenticatedSafeBytes, encodedPassword); err != nil {
+		return nil, err
+	}
+
+	pfx.AuthSafe.ContentType = oidDataContentType
+	pfx.AuthSafe.Content.Class = asn1.ClassContextSpecific
+	pfx.AuthSafe.Content.Tag = 0
+	pfx.AuthSafe.Content.IsCompound = true
+	if pfx.AuthSafe.Content.Bytes, err = asn1.Marshal(authenticatedSafeBytes); err != nil {
+		return nil, err
+	}
+
+	if pfxData, err = asn1.Marshal(pfx); err != nil {
+		return nil, errors.New("pkcs12: error writing P12 data: " + err.Error())
+	}
+	return pfxData, nil
+}
+
+func makeCertBag(certBytes []byte, attributes []pkcs12Attribute) (*safeBag, error) {
+	data, err := encodeCertBag(certBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	certBag := new(safeBag)
+	certBag.Id = oidCertBag
+	certBag.Value.Class = asn1.ClassContextSpecific
+	certBag.Value.Tag = 0
+	certBag.Value.IsCompound = true
+	certBag.Value.Bytes = data
+	certBag.Attributes = attributes
+	return certBag, nil
+}
+
+// makeSafeContents wraps bags into a contentInfo. The content is encrypted,
+// unless password is nil.
+func makeSafeContents(rand io.Reader, bags []safeBag, password []byte) (ci contentInfo, err error) {
+	var data []byte
+	if data, err = asn1.Marshal(bags); err != nil {
+		return
+	}
+
+	if password == nil {
+		ci.ContentType = oidDataContentType
+		ci.Content.Class = asn1.ClassContextSpecific
+		ci.Content.Tag = 0
+		ci.Content.IsCompound = true
+		if ci.Content.Bytes, err = asn1.Marshal(data); err != nil {
+			return
+		}
+		return
+	}
+
+	randomSalt := make([]byte, 8)
+	if _, err = rand.Read(randomSalt); err != nil {
+		return
+	}
+
+	var algo pkix.AlgorithmIdentifier
+	algo.Algorithm = oidPBEWithSHAAnd3KeyTripleDESCBC
+	if algo.Parameters.FullBytes, err = asn1.Marshal(pbeParams{Salt: randomSalt, Iterations: 2048}); err != nil {
+		return
+	}
+
+	var encryptedData encryptedData
+	encryptedData.Version = 0
+	encryptedData.EncryptedContentInfo.ContentType = oidDataContentType
+	encryptedData.EncryptedContentInfo.ContentEncryptionAlgorithm = algo
+	if err = pbEncrypt(&encryptedData.EncryptedContentInfo, data, password); err != nil {
+		return
+	}
+
+	ci.ContentType = oidEncryptedDataContentType
+	ci.Content.Class = asn1.ClassContextSpecific
+	ci.Content.Tag = 0
+	ci.Content.IsCompound = true
+	if ci.Content.Bytes, err = asn1.Marshal(encryptedData); err != nil {
+		return
+	}
+	return
+}